@@ -0,0 +1,10 @@
+//go:build go1.7 && !go1.8
+// +build go1.7,!go1.8
+
+package nethttp
+
+// isAbortHandler reports whether the panic value v is the sentinel used
+// to abort a response, which only exists from Go 1.8 on.
+func isAbortHandler(v interface{}) bool {
+	return false
+}
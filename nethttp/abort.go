@@ -0,0 +1,12 @@
+//go:build go1.8
+// +build go1.8
+
+package nethttp
+
+import "net/http"
+
+// isAbortHandler reports whether the panic value v is the sentinel used
+// by handlers, such as httputil.ReverseProxy, to abort a response.
+func isAbortHandler(v interface{}) bool {
+	return v == http.ErrAbortHandler
+}
@@ -0,0 +1,66 @@
+//go:build go1.8
+// +build go1.8
+
+package nethttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestAbortHandlerPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		handled bool
+		calls   int
+		aborted interface{}
+		panic   interface{}
+	}{
+		{"abort", http.ErrAbortHandler, false, 0, true, nil},
+		{"abort with panic handler", http.ErrAbortHandler, true, 0, true, nil},
+		{"crash", "boom", false, 0, nil, nil},
+		{"crash with panic handler", "boom", true, 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handlerCalls int
+			var options []MWOption
+			if tt.handled {
+				options = append(options, MWPanicHandler(func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction {
+					handlerCalls++
+					return PanicRepanic
+				}))
+			}
+			tr := mocktracer.New()
+			mw := MiddlewareFunc(tr, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("partial"))
+				panic(tt.value)
+			}, options...)
+
+			func() {
+				defer func() {
+					if v := recover(); v != tt.value {
+						t.Fatalf("got panic %v, expected %v", v, tt.value)
+					}
+				}()
+				mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}()
+
+			if handlerCalls != tt.calls {
+				t.Fatalf("got %d panic handler calls, expected %d", handlerCalls, tt.calls)
+			}
+			sp := tr.FinishedSpans()[0]
+			if got := sp.Tag("http.aborted"); got != tt.aborted {
+				t.Fatalf("got http.aborted %v, expected %v", got, tt.aborted)
+			}
+			if got := sp.Tag("panic"); got != tt.panic {
+				t.Fatalf("got panic tag %v, expected %v", got, tt.panic)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+//go:build go1.21
+// +build go1.21
+
+package nethttp
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// MWAccessLogger returns a MWOption that logs every request served by the
+// middleware to logger, once it is finished, at the info level with the
+// message "HTTP request" and the attributes:
+//
+//	method       the request method
+//	path         the request path
+//	operation    the operation name of the span
+//	route        the route that matched, see MWRouteNameFunc, if known
+//	status       the status code of the response, 0 if none was sent
+//	duration     the time spent serving the request
+//	bytes        the size of the response body
+//	trace_id     the identifier of the trace of the request, if known
+//	span_id      the identifier of the span of the request, if known
+//
+// Requests that are not traced are logged as well, with the identifiers
+// of the incoming span context if any, so that access logs and traces
+// can be correlated without a second middleware.
+func MWAccessLogger(logger *slog.Logger) MWOption {
+	return func(options *mwOptions) {
+		options.accessLog = func(r *http.Request, sp opentracing.Span, opName, route string, status int, size int64, elapsed time.Duration) {
+			logAccess(logger, r, sp, opName, route, status, size, elapsed)
+		}
+	}
+}
+
+func logAccess(logger *slog.Logger, r *http.Request, sp opentracing.Span, opName, route string, status int, size int64, elapsed time.Duration) {
+	attrs := make([]slog.Attr, 0, 9)
+	attrs = append(attrs,
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("operation", opName),
+	)
+	if route != "" {
+		attrs = append(attrs, slog.String("route", route))
+	}
+	attrs = append(attrs,
+		slog.Int("status", status),
+		slog.Duration("duration", elapsed),
+		slog.Int64("bytes", size),
+	)
+	if sp != nil {
+		if id := traceIDOf(sp.Context()); id != "" {
+			attrs = append(attrs, slog.String("trace_id", id))
+		}
+		if id := idOf(sp.Context(), "SpanID"); id != "" {
+			attrs = append(attrs, slog.String("span_id", id))
+		}
+	}
+	logger.LogAttrs(r.Context(), slog.LevelInfo, "HTTP request", attrs...)
+}
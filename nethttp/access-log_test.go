@@ -0,0 +1,73 @@
+//go:build go1.21
+// +build go1.21
+
+package nethttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestMWAccessLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	tr := mocktracer.New()
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("OK"))
+	}),
+		MWAccessLogger(logger),
+		MWSpanFilter(func(r *http.Request) bool { return r.URL.Path != "/health" }),
+		MWRouteNameFunc(func(r *http.Request) string { return "/items/{id}" }),
+	)
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/items/1", nil))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	dec := json.NewDecoder(&buf)
+	var records []map[string]interface{}
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if got, want := len(records), 2; got != want {
+		t.Fatalf("got %d records, expected %d", got, want)
+	}
+
+	sp := tr.FinishedSpans()[0]
+	expected := map[string]interface{}{
+		"msg":       "HTTP request",
+		"method":    "POST",
+		"path":      "/items/1",
+		"operation": "POST /items/{id}",
+		"route":     "/items/{id}",
+		"status":    float64(201),
+		"bytes":     float64(2),
+		"trace_id":  strconv.Itoa(sp.SpanContext.TraceID),
+		"span_id":   strconv.Itoa(sp.SpanContext.SpanID),
+	}
+	for k, v := range expected {
+		if got := records[0][k]; got != v {
+			t.Fatalf("got %s %v, expected %v", k, got, v)
+		}
+	}
+	if _, ok := records[0]["duration"]; !ok {
+		t.Fatal("got no duration")
+	}
+
+	if got := records[1]["path"]; got != "/health" {
+		t.Fatalf("got path %v for the untraced request", got)
+	}
+	if _, ok := records[1]["trace_id"]; ok {
+		t.Fatal("got a trace_id for an untraced request")
+	}
+}
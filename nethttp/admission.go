@@ -0,0 +1,137 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// AdmissionController decides when Transport may send an outbound
+// request, e.g. to hold back low-priority requests while the connection
+// pool is under pressure.
+type AdmissionController interface {
+	// Admit blocks until req may be sent and returns the function to
+	// call once its response is done with. It returns an error if req
+	// must not be sent, e.g. because its context is done.
+	Admit(req *http.Request) (release func(), err error)
+}
+
+// PriorityAdmission is an AdmissionController that lets a bounded
+// number of requests in flight. Requests beyond the limit wait and are
+// admitted highest priority first, in arrival order within a priority.
+type PriorityAdmission struct {
+	max      int
+	priority func(r *http.Request) int
+
+	mu       sync.Mutex
+	inflight int
+	waiting  []*admissionWaiter
+}
+
+type admissionWaiter struct {
+	priority int
+	admitted chan struct{}
+}
+
+// NewPriorityAdmission returns a PriorityAdmission letting maxInFlight
+// requests in flight. priority returns the priority of a request, a
+// higher value being more urgent; if nil, all requests have the same
+// priority.
+func NewPriorityAdmission(maxInFlight int, priority func(r *http.Request) int) *PriorityAdmission {
+	if priority == nil {
+		priority = func(*http.Request) int { return 0 }
+	}
+	return &PriorityAdmission{max: maxInFlight, priority: priority}
+}
+
+// Admit implements AdmissionController.
+func (a *PriorityAdmission) Admit(req *http.Request) (func(), error) {
+	a.mu.Lock()
+	if a.inflight < a.max && len(a.waiting) == 0 {
+		a.inflight++
+		a.mu.Unlock()
+		return a.releaseFunc(), nil
+	}
+	w := &admissionWaiter{priority: a.priority(req), admitted: make(chan struct{})}
+	i := len(a.waiting)
+	for i > 0 && a.waiting[i-1].priority < w.priority {
+		i--
+	}
+	a.waiting = append(a.waiting, nil)
+	copy(a.waiting[i+1:], a.waiting[i:])
+	a.waiting[i] = w
+	a.mu.Unlock()
+
+	select {
+	case <-w.admitted:
+		return a.releaseFunc(), nil
+	case <-req.Context().Done():
+		a.mu.Lock()
+		for i, waiting := range a.waiting {
+			if waiting == w {
+				a.waiting = append(a.waiting[:i], a.waiting[i+1:]...)
+				a.mu.Unlock()
+				return nil, req.Context().Err()
+			}
+		}
+		a.mu.Unlock()
+		// Admitted concurrently: hand the slot over.
+		a.releaseFunc()()
+		return nil, req.Context().Err()
+	}
+}
+
+// releaseFunc returns the function releasing one admitted request,
+// which admits the first waiting request if any.
+func (a *PriorityAdmission) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if len(a.waiting) > 0 {
+				w := a.waiting[0]
+				a.waiting = a.waiting[1:]
+				close(w.admitted)
+				return
+			}
+			a.inflight--
+		})
+	}
+}
+
+// admittedRoundTripper releases the admission of the request it sends
+// once its response is done with.
+type admittedRoundTripper struct {
+	rt      http.RoundTripper
+	release func()
+}
+
+func (a admittedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return roundTripAdmitted(a.rt, req, a.release)
+}
+
+func roundTripAdmitted(rt http.RoundTripper, req *http.Request, release func()) (*http.Response, error) {
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		release()
+		return resp, err
+	}
+	resp.Body = releaseOnClose{resp.Body, release}
+	return resp, nil
+}
+
+// releaseOnClose calls release once the body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
@@ -0,0 +1,58 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+)
+
+type anomalyOptions struct {
+	maxHeaderBytes int
+	reject         bool
+}
+
+// MWProtocolAnomalies returns a MWOption that inspects every request for
+// protocol anomalies: header blocks larger than maxHeaderBytes (0
+// disables the check), which the server accepts up to its
+// MaxHeaderBytes.
+//
+// Conflicting or duplicated Content-Length and Transfer-Encoding
+// headers, unknown transfer codings and duplicated Host headers, typical
+// of request smuggling attempts, are not looked for: net/http rejects
+// them, or normalizes them, before the handler is called.
+//
+// Suspicious requests get an http.anomaly=true tag and an http.anomalies
+// tag listing what was found. If reject is true, they are answered with
+// 400 Bad Request instead of being passed to the handler.
+func MWProtocolAnomalies(maxHeaderBytes int, reject bool) MWOption {
+	return func(options *mwOptions) {
+		options.anomalies = &anomalyOptions{
+			maxHeaderBytes: maxHeaderBytes,
+			reject:         reject,
+		}
+	}
+}
+
+// protocolAnomalies returns the list of anomalies found in r.
+func protocolAnomalies(r *http.Request, maxHeaderBytes int) []string {
+	var found []string
+	if maxHeaderBytes > 0 {
+		if size, _ := headerSize(r.Header); size > maxHeaderBytes {
+			found = append(found, "oversized_headers")
+		}
+	}
+	return found
+}
+
+// headerSize returns the approximate wire size of h, counting each
+// value as "Key: value\r\n", and the number of header fields.
+func headerSize(h http.Header) (size, count int) {
+	for k, vv := range h {
+		for _, v := range vv {
+			size += len(k) + len(v) + 4
+			count++
+		}
+	}
+	return size, count
+}
@@ -0,0 +1,129 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// AsyncFinisher finishes spans on a bounded pool of goroutines, so that
+// tracer clients serializing or enqueuing spans in Finish do not add
+// latency to the requests. Spans keep the time they were finished at.
+type AsyncFinisher struct {
+	queue      chan asyncFinish
+	maxWait    time.Duration
+	overflowed int64
+	wg         sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type asyncFinish struct {
+	sp          opentracing.Span
+	t           time.Time
+	afterFinish func()
+}
+
+// NewAsyncFinisher returns an AsyncFinisher finishing spans with workers
+// goroutines, queuing up to queueSize spans. Both are at least 1. When
+// the queue is full, finishing a span waits up to maxWait for room, then
+// finishes the span synchronously, in the request path, and counts it,
+// see Overflowed, so that no span is lost nor left to MWMaxSpanLifetime.
+// Close must be called to stop the goroutines, e.g. at shutdown once the
+// server is stopped.
+func NewAsyncFinisher(workers, queueSize int, maxWait time.Duration) *AsyncFinisher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	f := &AsyncFinisher{
+		queue:   make(chan asyncFinish, queueSize),
+		maxWait: maxWait,
+	}
+	f.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go f.work()
+	}
+	return f
+}
+
+// MWAsyncFinisher returns a MWOption that finishes the server-side spans
+// with f rather than in the request path. The spans of hijacked
+// connections kept open by MWKeepHijackedSpans are finished when the
+// connection is closed, as usual. MWSpanAfterFinish hooks are called
+// once the span is finished, by the goroutines of f.
+func MWAsyncFinisher(f *AsyncFinisher) MWOption {
+	return func(options *mwOptions) {
+		options.asyncFinisher = f
+	}
+}
+
+// Overflowed returns the number of spans finished synchronously because
+// the queue was full, a hint to add workers or room.
+func (f *AsyncFinisher) Overflowed() int64 {
+	return atomic.LoadInt64(&f.overflowed)
+}
+
+// Close finishes the queued spans and stops the goroutines. Spans
+// finished afterwards are finished synchronously.
+func (f *AsyncFinisher) Close() {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.closed = true
+	close(f.queue)
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+func (f *AsyncFinisher) work() {
+	defer f.wg.Done()
+	for af := range f.queue {
+		af.finish()
+	}
+}
+
+// finish finishes sp at the current time on the goroutines of f, then
+// calls afterFinish if not nil.
+func (f *AsyncFinisher) finish(sp opentracing.Span, afterFinish func()) {
+	af := asyncFinish{sp: sp, t: time.Now(), afterFinish: afterFinish}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		af.finish()
+		return
+	}
+	select {
+	case f.queue <- af:
+		return
+	default:
+	}
+	if f.maxWait > 0 {
+		t := time.NewTimer(f.maxWait)
+		defer t.Stop()
+		select {
+		case f.queue <- af:
+			return
+		case <-t.C:
+		}
+	}
+	atomic.AddInt64(&f.overflowed, 1)
+	af.finish()
+}
+
+func (af asyncFinish) finish() {
+	af.sp.FinishWithOptions(opentracing.FinishOptions{FinishTime: af.t})
+	if af.afterFinish != nil {
+		af.afterFinish()
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// MWBaggageToContext returns a MWOption that copies the given baggage
+// items of the request's span into the request context, so that
+// handlers can read them with BaggageItem and BaggageItems without
+// depending on opentracing. Items that are not set are not copied.
+func MWBaggageToContext(keys ...string) MWOption {
+	return func(options *mwOptions) {
+		options.baggageKeys = keys
+	}
+}
+
+// BaggageItem returns the baggage item copied into ctx by
+// MWBaggageToContext under key, and whether it was set.
+func BaggageItem(ctx context.Context, key string) (string, bool) {
+	items, _ := ctx.Value(keyBaggage).(map[string]string)
+	v, ok := items[key]
+	return v, ok
+}
+
+// BaggageItems returns a copy of all baggage items copied into ctx by
+// MWBaggageToContext.
+func BaggageItems(ctx context.Context) map[string]string {
+	items, _ := ctx.Value(keyBaggage).(map[string]string)
+	res := make(map[string]string, len(items))
+	for k, v := range items {
+		res[k] = v
+	}
+	return res
+}
+
+func contextWithBaggage(ctx context.Context, sp opentracing.Span, keys []string) context.Context {
+	items := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if v := sp.BaggageItem(key); v != "" {
+			items[key] = v
+		}
+	}
+	return context.WithValue(ctx, keyBaggage, items)
+}
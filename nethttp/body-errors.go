@@ -0,0 +1,109 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// BodyErrorMapping describes an error returned while reading a request
+// body. Errors matched by a mapping are recorded with precise tags
+// instead of a generic error log.
+type BodyErrorMapping struct {
+	// Match reports whether the mapping applies to err.
+	Match func(err error) bool
+	// Kind is set as the http.request.body_error tag, e.g. "too_large".
+	Kind string
+	// Tags optionally returns additional tags describing err.
+	Tags func(err error) opentracing.Tags
+	// Error tells whether the span is tagged error=true.
+	Error bool
+}
+
+// defaultBodyErrorMappings recognizes the errors of net/http and of
+// request cancellation.
+var defaultBodyErrorMappings = []BodyErrorMapping{
+	maxBytesErrorMapping,
+	{
+		Match: func(err error) bool { return errorIs(err, http.ErrBodyReadAfterClose) },
+		Kind:  "read_after_close",
+		Error: true,
+	},
+	{
+		Match: func(err error) bool { return errorIs(err, context.Canceled) },
+		Kind:  "canceled",
+	},
+	{
+		Match: func(err error) bool { return errorIs(err, context.DeadlineExceeded) },
+		Kind:  "deadline_exceeded",
+		Error: true,
+	},
+}
+
+// MWBodyErrorMappings returns a MWOption that extends the table used to
+// recognize errors returned while reading request bodies, including
+// the error of an http.MaxBytesReader the handler replaces the body of
+// its request with. The given mappings are tried in order before the
+// default ones, which cover http.MaxBytesError (kind too_large, tagged
+// with the limit from Go 1.19 on), http.ErrBodyReadAfterClose
+// (read_after_close), context.Canceled (canceled) and
+// context.DeadlineExceeded (deadline_exceeded).
+//
+// The option turns on request body tracking, as MWRequestSizeTag does,
+// without adding the http.request_size tag. Errors that no mapping
+// matches are logged to the span.
+func MWBodyErrorMappings(mappings ...BodyErrorMapping) MWOption {
+	return func(options *mwOptions) {
+		options.bodyErrors = append(options.bodyErrors, mappings...)
+	}
+}
+
+// recordBodyError tags sp with the first mapping matching err and
+// reports whether there was one. The error tag is only set if autoError
+// is true.
+func recordBodyError(sp opentracing.Span, err error, mappings []BodyErrorMapping, autoError bool) bool {
+	for _, table := range [][]BodyErrorMapping{mappings, defaultBodyErrorMappings} {
+		for _, m := range table {
+			if !m.Match(err) {
+				continue
+			}
+			sp.SetTag("http.request.body_error", m.Kind)
+			if m.Tags != nil {
+				for k, v := range m.Tags(err) {
+					sp.SetTag(k, v)
+				}
+			}
+			if m.Error && autoError {
+				ext.Error.Set(sp, true)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// maxBytesReaderType is the type of the bodies returned by
+// http.MaxBytesReader.
+var maxBytesReaderType = reflect.TypeOf(http.MaxBytesReader(nil, nil, 0))
+
+// recordReplacedError records the error of the http.MaxBytesReader the
+// handler replaced the request body with, which wraps b so that b never
+// sees it. The reader keeps its error and returns it before checking
+// the length of the buffer, so an empty read gets it without reading
+// the body further. Other bodies are left alone, as an empty read may
+// block or consume them.
+func (b *bodyTracker) recordReplacedError(replaced io.ReadCloser) {
+	if b.err != nil || reflect.TypeOf(replaced) != maxBytesReaderType {
+		return
+	}
+	if _, err := replaced.Read(nil); err != nil && err != io.EOF {
+		b.recordError(err)
+	}
+}
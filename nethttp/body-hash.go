@@ -0,0 +1,40 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"encoding/hex"
+	"hash"
+)
+
+type bodyHashOptions struct {
+	algorithm string
+	newHash   func() hash.Hash
+}
+
+// MWResponseBodyHash returns a MWOption that hashes the response body as
+// it is written, and tags the server-side span with
+// http.response.body_hash set to the algorithm name followed by a colon
+// and the hex-encoded hash, e.g. "sha256:e3b0c4...". Comparing the hash
+// with the one computed by the client, or by another service serving the
+// same resource, verifies the integrity and the consistency of the
+// payloads from the traces.
+//
+// Hashing costs CPU time on every written byte, and bodies sent with
+// io.ReaderFrom can no longer be sent with sendfile.
+//
+// Example:
+//
+//	nethttp.MWResponseBodyHash("sha256", sha256.New)
+//	nethttp.MWResponseBodyHash("crc32", func() hash.Hash { return crc32.NewIEEE() })
+func MWResponseBodyHash(algorithm string, newHash func() hash.Hash) MWOption {
+	return func(options *mwOptions) {
+		options.bodyHash = &bodyHashOptions{algorithm: algorithm, newHash: newHash}
+	}
+}
+
+// tag returns the value of the http.response.body_hash tag.
+func (o *bodyHashOptions) tag(h hash.Hash) string {
+	return o.algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
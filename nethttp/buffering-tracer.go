@@ -0,0 +1,107 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// BufferingTracer wraps a tracer and buffers the spans it creates per
+// trace, until every span of the trace is finished, then delivers the
+// whole trace at once. It enables assertions over complete traces, e.g.
+// the server, client and database spans of a request in an integration
+// test, rather than over individual spans in arbitrary order.
+//
+// Spans are grouped by the trace ID of their span context, so the
+// wrapped tracer must expose it (Jaeger, Zipkin and mocktracer do).
+// Spans of other tracers are delivered alone.
+type BufferingTracer struct {
+	opentracing.Tracer
+	deliver func(trace []opentracing.Span)
+
+	mu     sync.Mutex
+	traces map[string]*bufferedTrace
+}
+
+type bufferedTrace struct {
+	open  int
+	spans []opentracing.Span
+}
+
+// NewBufferingTracer returns a BufferingTracer wrapping tr and calling
+// deliver with the spans of every complete trace, as created by tr, in
+// the order they were finished.
+//
+// Example:
+//
+//	tracer := nethttp.NewBufferingTracer(mocktracer.New(), func(trace []opentracing.Span) {
+//		traces <- trace
+//	})
+func NewBufferingTracer(tr opentracing.Tracer, deliver func(trace []opentracing.Span)) *BufferingTracer {
+	return &BufferingTracer{
+		Tracer:  tr,
+		deliver: deliver,
+		traces:  make(map[string]*bufferedTrace),
+	}
+}
+
+// StartSpan implements opentracing.Tracer.
+func (t *BufferingTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	sp := t.Tracer.StartSpan(operationName, opts...)
+	key := traceIDOf(sp.Context())
+	if key == "" {
+		key = fmt.Sprintf("%p", sp)
+	}
+	t.mu.Lock()
+	trace, ok := t.traces[key]
+	if !ok {
+		trace = &bufferedTrace{}
+		t.traces[key] = trace
+	}
+	trace.open++
+	t.mu.Unlock()
+	return &bufferedSpan{Span: sp, t: t, key: key}
+}
+
+func (t *BufferingTracer) finished(key string, sp opentracing.Span) {
+	t.mu.Lock()
+	trace := t.traces[key]
+	trace.spans = append(trace.spans, sp)
+	trace.open--
+	if trace.open > 0 {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.traces, key)
+	t.mu.Unlock()
+	t.deliver(trace.spans)
+}
+
+// bufferedSpan reports its finish to the BufferingTracer.
+type bufferedSpan struct {
+	opentracing.Span
+	t    *BufferingTracer
+	key  string
+	once sync.Once
+}
+
+func (s *bufferedSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *bufferedSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.Span.FinishWithOptions(opts)
+	s.once.Do(func() {
+		s.t.finished(s.key, s.Span)
+	})
+}
+
+// Tracer returns the BufferingTracer, so that the spans started from
+// this one are buffered as well.
+func (s *bufferedSpan) Tracer() opentracing.Tracer {
+	return s.t
+}
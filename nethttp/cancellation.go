@@ -0,0 +1,34 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// tagCancellation tags sp if the context of r is done by the time the
+// handler returns: context.canceled=true, plus client.disconnected=true
+// if it was canceled rather than past its deadline, as net/http cancels
+// the context when the client closes the connection. The cause is
+// logged.
+func tagCancellation(sp opentracing.Span, r *http.Request) {
+	ctx := r.Context()
+	err := ctx.Err()
+	if err == nil {
+		return
+	}
+	sp.SetTag("context.canceled", true)
+	if err == context.Canceled {
+		sp.SetTag("client.disconnected", true)
+	}
+	sp.LogFields(
+		log.String("event", "canceled"),
+		log.String("message", err.Error()),
+		log.Error(contextCause(ctx)),
+	)
+}
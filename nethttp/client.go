@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptrace"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
@@ -17,6 +18,13 @@ type contextKey int
 
 const (
 	keyTracer contextKey = iota
+	keyBaggage
+	keySegmentMode
+	keyConnSequence
+	keyCosts
+	keyRequestID
+	keyServerSpan
+	keyDebugTrace
 )
 
 const defaultComponentName = "net/http"
@@ -28,6 +36,11 @@ type Transport struct {
 	// The actual RoundTripper to use for the request. A nil
 	// RoundTripper defaults to http.DefaultTransport.
 	http.RoundTripper
+
+	// Admission, if not nil, admits every request before it is sent,
+	// and is told when its response body is closed. The time traced
+	// requests spend waiting for admission is tagged admission.wait_ms.
+	Admission AdmissionController
 }
 
 type clientOptions struct {
@@ -35,7 +48,21 @@ type clientOptions struct {
 	componentName            string
 	disableClientTrace       bool
 	disableInjectSpanContext bool
+	presignedURLs            bool
+	propagators              []string
+	errorFunc                func(status int, r *http.Request) bool
+	tagAliases               map[string]string
+	reproCommand             bool
+	metrics                  MetricsRecorder
+	tagLimits                *tagLimits
+	pathNormalizer           func(path string) string
+	requestHeaders           []string
+	headerRedactor           func(name, value string) string
 	spanObserver             func(span opentracing.Span, r *http.Request)
+	rootIDHint               func(r *http.Request) string
+	errorLogs                bool
+	sentAtHeader             string
+	correlationHeader        string
 }
 
 // ClientOption contols the behavior of TraceRequest.
@@ -75,6 +102,26 @@ func InjectSpanContext(enabled bool) ClientOption {
 	}
 }
 
+// PresignedURLTags returns a ClientOption that turns on or off the
+// detection of presigned object store URLs (X-Amz-Signature and the
+// like). When enabled, the credentials are stripped from the http.url
+// tag and the bucket and object key are tagged separately.
+func PresignedURLTags(enabled bool) ClientOption {
+	return func(options *clientOptions) {
+		options.presignedURLs = enabled
+	}
+}
+
+// ClientErrorFunc returns a ClientOption that uses given function f to
+// decide whether the client-side span is tagged as an error, given the
+// status code of the response. By default, status codes >= 500 are
+// errors.
+func ClientErrorFunc(f func(status int, r *http.Request) bool) ClientOption {
+	return func(options *clientOptions) {
+		options.errorFunc = f
+	}
+}
+
 // ClientSpanObserver returns a ClientOption that observes the span
 // for the client-side span.
 func ClientSpanObserver(f func(span opentracing.Span, r *http.Request)) ClientOption {
@@ -110,19 +157,34 @@ func ClientSpanObserver(f func(span opentracing.Span, r *http.Request)) ClientOp
 func TraceRequest(tr opentracing.Tracer, req *http.Request, options ...ClientOption) (*http.Request, *Tracer) {
 	opts := &clientOptions{
 		spanObserver: func(_ opentracing.Span, _ *http.Request) {},
+		errorFunc: func(status int, _ *http.Request) bool {
+			return status >= http.StatusInternalServerError
+		},
+		headerRedactor: DefaultHeaderRedactor,
 	}
 	for _, opt := range options {
 		opt(opts)
 	}
 	ht := &Tracer{tr: tr, opts: opts}
 	ctx := req.Context()
-	if !opts.disableClientTrace {
+	if !opts.disableClientTrace || isDebugContext(ctx) {
 		ctx = httptrace.WithClientTrace(ctx, ht.clientTrace())
 	}
 	req = req.WithContext(context.WithValue(ctx, keyTracer, ht))
 	return req, ht
 }
 
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 type closeTracker struct {
 	io.ReadCloser
 	sp opentracing.Span
@@ -153,29 +215,110 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	tracer := TracerFromRequest(req)
 	if tracer == nil {
-		return rt.RoundTrip(req)
+		if t.Admission == nil {
+			return rt.RoundTrip(req)
+		}
+		release, err := t.Admission.Admit(req)
+		if err != nil {
+			return nil, err
+		}
+		return roundTripAdmitted(rt, req, release)
 	}
 
 	tracer.start(req)
+	if t.Admission != nil {
+		start := time.Now()
+		release, err := t.Admission.Admit(req)
+		tracer.sp.SetTag("admission.wait_ms", int64(time.Since(start)/time.Millisecond))
+		if err != nil {
+			ext.Error.Set(tracer.sp, true)
+			tracer.sp.LogFields(log.String("event", "error"), log.Error(err))
+			tracer.sp.Finish()
+			return nil, err
+		}
+		rt = admittedRoundTripper{rt, release}
+	}
 
 	ext.HTTPMethod.Set(tracer.sp, req.Method)
-	ext.HTTPUrl.Set(tracer.sp, req.URL.String())
+	u := req.URL
+	if tracer.opts.pathNormalizer != nil {
+		u = normalizeURL(u, tracer.opts.pathNormalizer)
+	}
+	if tracer.opts.presignedURLs && isPresignedURL(u) {
+		tagPresignedURL(tracer.sp, u)
+	} else {
+		ext.HTTPUrl.Set(tracer.sp, u.String())
+	}
+	tagHeaders(tracer.sp, "http.request.header.", req.Header, tracer.opts.requestHeaders, tracer.opts.headerRedactor)
 	tracer.opts.spanObserver(tracer.sp, req)
 
-	if !tracer.opts.disableInjectSpanContext {
-		carrier := opentracing.HTTPHeadersCarrier(req.Header)
-		tracer.sp.Tracer().Inject(tracer.sp.Context(), opentracing.HTTPHeaders, carrier)
+	// The command is built before injection to leave the span context
+	// headers out.
+	var repro string
+	if tracer.opts.reproCommand {
+		repro = reproCommand(req)
+	}
+	if tracer.opts.correlationHeader != "" {
+		injectCorrelationHeader(tracer.sp, req.Header, tracer.opts.correlationHeader)
+	} else if !tracer.opts.disableInjectSpanContext {
+		injectSpanContext(tracer.sp.Tracer(), tracer.sp.Context(), req.Header, tracer.opts.propagators)
+	}
+	propagateRequestID(req.Context(), req.Header)
+	if tracer.opts.sentAtHeader != "" {
+		req.Header.Set(tracer.opts.sentAtHeader, sentAtValue(time.Now()))
+	}
+
+	var body *countingReadCloser
+	if req.Body != nil && req.ContentLength != 0 {
+		// Only bodies of non-zero or unknown length are wrapped, so that
+		// the wrapper does not change how the length is determined.
+		body = &countingReadCloser{ReadCloser: req.Body}
+		r := *req
+		r.Body = body
+		req = &r
 	}
 
+	start := time.Now()
 	resp, err := rt.RoundTrip(req)
 
+	var size int64
+	if body != nil {
+		size = body.n
+	}
+	tracer.sp.SetTag("http.request_size", size)
+
+	if m := tracer.opts.metrics; m != nil {
+		l := MetricsLabels{Kind: "client", Operation: "HTTP " + req.Method, Method: req.Method}
+		m.ObserveLatency(l, time.Since(start))
+		if err != nil {
+			m.IncStatus(l, 0)
+			m.ObserveSize(l, size, 0)
+		} else {
+			m.IncStatus(l, resp.StatusCode)
+			resp.Body = &metricsBody{ReadCloser: resp.Body, m: m, l: l, requestBytes: size}
+		}
+	}
+
 	if err != nil {
+		if tracer.opts.errorLogs {
+			ext.Error.Set(tracer.sp, true)
+			logErrorValue(tracer.sp, err, nil)
+		}
+		if repro != "" {
+			logReproCommand(tracer.sp, repro)
+		}
 		tracer.sp.Finish()
 		return resp, err
 	}
 	ext.HTTPStatusCode.Set(tracer.sp, uint16(resp.StatusCode))
-	if resp.StatusCode >= http.StatusInternalServerError {
+	if tracer.opts.errorFunc(resp.StatusCode, req) {
 		ext.Error.Set(tracer.sp, true)
+		if tracer.opts.errorLogs {
+			logStatusError(tracer.sp, resp.StatusCode)
+		}
+		if repro != "" {
+			logReproCommand(tracer.sp, repro)
+		}
 	}
 	if req.Method == "HEAD" {
 		tracer.sp.Finish()
@@ -204,12 +347,27 @@ func (h *Tracer) start(req *http.Request) opentracing.Span {
 		if operationName == "" {
 			operationName = "HTTP Client"
 		}
-		root := h.tr.StartSpan(operationName, opentracing.ChildOf(spanctx))
+		var root opentracing.Span
+		var hint opentracing.StartSpanOption
+		if parent == nil {
+			hint = rootIDHint(h.opts.rootIDHint, req)
+		}
+		if hint != nil {
+			root = h.tr.StartSpan(operationName, opentracing.ChildOf(spanctx), hint)
+		} else {
+			root = h.tr.StartSpan(operationName, opentracing.ChildOf(spanctx))
+		}
 		h.root = root
 	}
 
 	ctx := h.root.Context()
 	h.sp = h.tr.StartSpan("HTTP "+req.Method, opentracing.ChildOf(ctx))
+	if len(h.opts.tagAliases) > 0 {
+		h.sp = &aliasSpan{Span: h.sp, aliases: h.opts.tagAliases}
+	}
+	if h.opts.tagLimits != nil {
+		h.sp = newLimitSpan(h.sp, h.opts.tagLimits)
+	}
 	ext.SpanKindRPCClient.Set(h.sp)
 
 	componentName := h.opts.componentName
@@ -259,6 +417,9 @@ func (h *Tracer) getConn(hostPort string) {
 func (h *Tracer) gotConn(info httptrace.GotConnInfo) {
 	h.sp.SetTag("net/http.reused", info.Reused)
 	h.sp.SetTag("net/http.was_idle", info.WasIdle)
+	if info.Conn != nil {
+		tagPeerAddr(h.sp, info.Conn.RemoteAddr())
+	}
 	h.sp.LogFields(log.String("event", "GotConn"))
 }
 
@@ -1,9 +1,21 @@
 package nethttp
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
@@ -40,12 +52,18 @@ func TestClientTrace(t *testing.T) {
 	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failure", http.StatusInternalServerError)
 	})
+	mux.HandleFunc("/conflict", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "conflict", http.StatusConflict)
+	})
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
 	helloWorldObserver := func(s opentracing.Span, r *http.Request) {
 		s.SetTag("hello", "world")
 	}
+	conflictIsError := func(status int, r *http.Request) bool {
+		return status == http.StatusConflict
+	}
 
 	tests := []struct {
 		url          string
@@ -58,6 +76,8 @@ func TestClientTrace(t *testing.T) {
 		{url: "/redirect", num: 4, opts: []ClientOption{OperationName("client-span")}, opName: "client-span"},
 		{url: "/fail", num: 3, opts: nil, opName: "HTTP Client", expectedTags: makeTags(string(ext.Error), true)},
 		{url: "/ok", num: 3, opts: []ClientOption{ClientSpanObserver(helloWorldObserver)}, opName: "HTTP Client", expectedTags: makeTags("hello", "world")},
+		{url: "/conflict", num: 3, opts: []ClientOption{ClientErrorFunc(conflictIsError)}, opName: "HTTP Client", expectedTags: makeTags(string(ext.Error), true)},
+		{url: "/fail", num: 3, opts: []ClientOption{ClientErrorFunc(conflictIsError)}, opName: "HTTP Client", expectedTags: makeTags(string(ext.Error), nil)},
 	}
 
 	for _, tt := range tests {
@@ -208,3 +228,862 @@ func makeTags(keyVals ...interface{}) map[string]interface{} {
 	}
 	return result
 }
+
+func TestSSEReader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": comment\n\nid: 1\nevent: greeting\ndata: hello\ndata: world\n\nid: 2\ndata: bye\n\n"))
+	}))
+	defer srv.Close()
+
+	tr := &mocktracer.MockTracer{}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(tr, req)
+	client := &http.Client{Transport: &Transport{}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := NewSSEReader(resp)
+	var events []*SSEEvent
+	for {
+		ev, err := stream.Next()
+		if err != nil {
+			break
+		}
+		events = append(events, ev)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ht.Finish()
+
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("got %d events, expected %d", got, want)
+	}
+	if got, want := events[0].Data, "hello\nworld"; got != want {
+		t.Fatalf("got %q data, expected %q", got, want)
+	}
+	if got, want := events[0].Event, "greeting"; got != want {
+		t.Fatalf("got %q event, expected %q", got, want)
+	}
+	if got, want := stream.LastEventID(), "2"; got != want {
+		t.Fatalf("got %q last event id, expected %q", got, want)
+	}
+
+	for _, span := range tr.FinishedSpans() {
+		if span.OperationName != "HTTP GET" {
+			continue
+		}
+		if got, want := span.Tag("sse.events"), 2; got != want {
+			t.Fatalf("got %v sse.events tag, expected %v", got, want)
+		}
+		return
+	}
+	t.Fatal("cannot find client span")
+}
+
+func TestPoll(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	tr := &mocktracer.MockTracer{}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &Transport{}}
+	var handled int
+	err = Poll(context.Background(), client, req, func(resp *http.Response) error {
+		handled++
+		if handled == 2 {
+			return ErrPollDone
+		}
+		return nil
+	}, PollOptions{Tracer: tr, MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := calls, 3; got != want {
+		t.Fatalf("got %d calls, expected %d", got, want)
+	}
+
+	var parent *mocktracer.MockSpan
+	iterations := map[int]*mocktracer.MockSpan{}
+	for _, span := range tr.FinishedSpans() {
+		switch span.OperationName {
+		case "HTTP Poll":
+			parent = span
+		case "HTTP Client":
+			iterations[span.Tag("poll.iteration").(int)] = span
+		}
+	}
+	if parent == nil {
+		t.Fatal("cannot find poll span")
+	}
+	if got, want := parent.Tag("poll.iterations"), 3; got != want {
+		t.Fatalf("got %v iterations, expected %v", got, want)
+	}
+	if got, want := len(iterations), 3; got != want {
+		t.Fatalf("got %d iteration spans, expected %d", got, want)
+	}
+	for _, span := range iterations {
+		if span.ParentID != parent.SpanContext.SpanID {
+			t.Fatalf("iteration span is not a child of the poll span")
+		}
+	}
+	if got, want := iterations[2].Tag("poll.backoff_ms"), int64(1); got != want {
+		t.Fatalf("got %v backoff, expected %v", got, want)
+	}
+}
+
+func TestPollStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &Transport{}}
+	noop := func(resp *http.Response) error { return nil }
+
+	t.Run("LastAttempt", func(t *testing.T) {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- Poll(context.Background(), client, req, noop, PollOptions{Tracer: &mocktracer.MockTracer{}, MinBackoff: time.Hour, MaxIterations: 1})
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("waited for a backoff after the last attempt")
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := &mocktracer.MockTracer{}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if err := Poll(ctx, client, req, noop, PollOptions{Tracer: tr, MinBackoff: time.Hour}); err != context.Canceled {
+			t.Fatalf("got error %v, expected %v", err, context.Canceled)
+		}
+		for _, span := range tr.FinishedSpans() {
+			if span.OperationName == "HTTP Poll" && span.Tag(string(ext.Error)) != nil {
+				t.Fatal("cancellation tagged as an error")
+			}
+		}
+	})
+
+	t.Run("BodyNotReplayable", func(t *testing.T) {
+		req, err := http.NewRequest("POST", srv.URL, ioutil.NopCloser(strings.NewReader("hello")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Poll(context.Background(), client, req, noop, PollOptions{Tracer: &mocktracer.MockTracer{}}); err == nil {
+			t.Fatal("polled a request whose body cannot be sent again")
+		}
+	})
+}
+
+func TestDownload(t *testing.T) {
+	content := strings.Repeat("0123456789", 2) + "abcde"
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tr := &mocktracer.MockTracer{}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	var buf bytes.Buffer
+	n, err := Download(context.Background(), &http.Client{Transport: &Transport{}}, req, &buf, DownloadOptions{
+		Tracer:           tr,
+		ChunkSize:        10,
+		Hash:             sha256.New(),
+		ExpectedChecksum: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, int64(len(content)); got != want {
+		t.Fatalf("got %d bytes, expected %d", got, want)
+	}
+	if got, want := buf.String(), content; got != want {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+
+	var parent *mocktracer.MockSpan
+	var ranges int
+	for _, span := range tr.FinishedSpans() {
+		switch span.OperationName {
+		case "HTTP Download":
+			parent = span
+		case "HTTP Download Range":
+			ranges++
+		}
+	}
+	if parent == nil {
+		t.Fatal("cannot find download span")
+	}
+	if got, want := ranges, 4; got != want {
+		t.Fatalf("got %d range spans, expected %d", got, want)
+	}
+	expectedTags := makeTags("download.size", int64(len(content)), "download.ranges", 4, "download.retries", 1, "download.checksum_ok", true)
+	for k, expected := range expectedTags {
+		if got := parent.Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestDownloadResumeUnknownSize(t *testing.T) {
+	content := strings.Repeat("0123456789", 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		if start >= len(content) {
+			w.Header().Set("Content-Range", "bytes */*")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end >= len(content) {
+			end = len(content) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(content[start : end+1]))
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	h := sha256.New()
+	h.Write([]byte(content[:10]))
+	var buf bytes.Buffer
+	n, err := Download(context.Background(), &http.Client{Transport: &Transport{}}, req, &buf, DownloadOptions{
+		Tracer:           &mocktracer.MockTracer{},
+		ChunkSize:        10,
+		Offset:           10,
+		Hash:             h,
+		ExpectedChecksum: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, int64(len(content)-10); got != want {
+		t.Fatalf("got %d bytes, expected %d", got, want)
+	}
+	if got, want := buf.String(), content[10:]; got != want {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+}
+
+func TestPresignedURLTags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	query := "?versionId=3&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIA%2F20190820&X-Amz-Signature=deadbeef"
+	spans := makeRequest(t, srv.URL+"/my-bucket/path/to/object"+query, PresignedURLTags(true), ClientTrace(false))
+	for _, span := range spans {
+		if span.OperationName != "HTTP GET" {
+			continue
+		}
+		expectedTags := makeTags(
+			"http.url", srv.URL+"/my-bucket/path/to/object?versionId=3",
+			"object_store.bucket", "my-bucket",
+			"object_store.key", "path/to/object",
+		)
+		for k, expected := range expectedTags {
+			if got := span.Tag(k); got != expected {
+				t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+			}
+		}
+		return
+	}
+	t.Fatal("cannot find client span")
+}
+
+func TestRequestSizeTag(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	tr := &mocktracer.MockTracer{}
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(tr, req)
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+
+	for _, span := range tr.FinishedSpans() {
+		if span.OperationName != "HTTP POST" {
+			continue
+		}
+		if got, want := span.Tag("http.request_size"), int64(11); got != want {
+			t.Fatalf("got %v http.request_size, expected %v", got, want)
+		}
+		return
+	}
+	t.Fatal("cannot find client span")
+}
+
+func TestClientDualTagNames(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	spans := makeRequest(t, srv.URL, ClientDualTagNames(TagGroupHTTP, TagGroupSize))
+	for _, span := range spans {
+		if span.OperationName != "HTTP GET" {
+			continue
+		}
+		expectedTags := makeTags(
+			"http.response.status_code", uint16(200),
+			"http.request.body.size", int64(0),
+		)
+		for k, expected := range expectedTags {
+			if got := span.Tag(k); got != expected {
+				t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+			}
+		}
+		return
+	}
+	t.Fatal("cannot find client span")
+}
+
+func TestPriorityAdmission(t *testing.T) {
+	a := NewPriorityAdmission(1, func(r *http.Request) int {
+		if r.Header.Get("X-Priority") == "high" {
+			return 1
+		}
+		return 0
+	})
+	newRequest := func(priority string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Priority", priority)
+		return req
+	}
+	waiting := func(n int) {
+		for {
+			a.mu.Lock()
+			l := len(a.waiting)
+			a.mu.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release, err := a.Admit(newRequest("low"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admitted := make(chan string, 2)
+	for i, priority := range []string{"low", "high"} {
+		priority := priority
+		go func() {
+			release, err := a.Admit(newRequest(priority))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			admitted <- priority
+			release()
+		}()
+		waiting(i + 1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.Admit(newRequest("high").WithContext(ctx)); err != context.Canceled {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+
+	release()
+	if got, want := <-admitted+","+<-admitted, "high,low"; got != want {
+		t.Fatalf("got admission order %s, expected %s", got, want)
+	}
+}
+
+func TestTransportAdmission(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	admission := NewPriorityAdmission(1, nil)
+	tr := &mocktracer.MockTracer{}
+	client := &http.Client{Transport: &Transport{Admission: admission}}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, ht := TraceRequest(tr, req)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		ht.Finish()
+	}
+
+	if admission.inflight != 0 {
+		t.Fatalf("got %d requests in flight, expected 0", admission.inflight)
+	}
+	var n int
+	for _, span := range tr.FinishedSpans() {
+		if span.OperationName == "HTTP GET" {
+			if _, ok := span.Tag("admission.wait_ms").(int64); !ok {
+				t.Fatalf("got %v admission.wait_ms, expected a duration", span.Tag("admission.wait_ms"))
+			}
+			n++
+		}
+	}
+	if got, want := n, 2; got != want {
+		t.Fatalf("got %d client spans, expected %d", got, want)
+	}
+}
+
+func TestClientReproCommand(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failure", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	tr := mocktracer.New()
+	req, err := http.NewRequest("GET", strings.Replace(srv.URL, "http://", "http://user:pass@", 1)+"/fail?q=it's", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/json")
+	req, ht := TraceRequest(tr, req, ClientReproCommand(true))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+
+	want := "curl -X GET '" + srv.URL + "/fail?q=it'\\''s' -H 'Accept: application/json'"
+	for _, span := range tr.FinishedSpans() {
+		if span.OperationName != "HTTP GET" {
+			continue
+		}
+		for _, l := range span.Logs() {
+			for _, f := range l.Fields {
+				if f.Key == "repro.command" {
+					if f.ValueString != want {
+						t.Fatalf("got %s, expected %s", f.ValueString, want)
+					}
+					return
+				}
+			}
+		}
+	}
+	t.Fatal("cannot find repro.command log field")
+}
+
+func TestClientPropagators(t *testing.T) {
+	registerPrefixedPropagator("first", "X-First-")
+	registerPrefixedPropagator("second", "X-Second-")
+
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(mocktracer.New(), req, ClientPropagators("first", "second", "unknown"))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+
+	for _, prefix := range []string{"X-First-", "X-Second-"} {
+		var found bool
+		for k := range header {
+			found = found || strings.HasPrefix(k, prefix)
+		}
+		if !found {
+			t.Fatalf("cannot find %s headers in %v", prefix, header)
+		}
+	}
+}
+
+func TestClientPropagatorsFallback(t *testing.T) {
+	tr := mocktracer.New()
+	sp := tr.StartSpan("root")
+	h := http.Header{}
+	// mocktracer only speaks its own format, so the B3 filter keeps nothing
+	if err := injectSpanContext(tr, sp.Context(), h, []string{PropagatorB3Headers}); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := tr.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	if err != nil {
+		t.Fatalf("native headers not injected in %v: %v", h, err)
+	}
+	if got, want := sc.(mocktracer.MockSpanContext).SpanID, sp.Context().(mocktracer.MockSpanContext).SpanID; got != want {
+		t.Fatalf("got span %d, expected %d", got, want)
+	}
+}
+
+func TestClientMetricsRecorder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	m := &metricsRecord{}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(&mocktracer.MockTracer{}, req, ClientMetricsRecorder(m))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	ht.Finish()
+
+	want := "latency client HTTP GET GET\nstatus client HTTP GET 200\nsize client HTTP GET 0 5"
+	if got := strings.Join(m.calls, "\n"); got != want {
+		t.Fatalf("got calls\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestClientTagLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	spans := makeRequest(t, srv.URL+"/items/1234", ClientTrace(false), ClientPathNormalizer(CollapseIDs), ClientTagLimits(0, 1),
+		ClientSpanObserver(func(sp opentracing.Span, r *http.Request) {
+			sp.SetTag("custom", true)
+			sp.SetTag("other", true)
+		}))
+	for _, span := range spans {
+		if span.OperationName != "HTTP GET" {
+			continue
+		}
+		expectedTags := makeTags(
+			"http.url", srv.URL+"/items/%7Bid%7D",
+			"custom", true,
+			"other", nil,
+		)
+		for k, expected := range expectedTags {
+			if got := span.Tag(k); got != expected {
+				t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+			}
+		}
+		return
+	}
+	t.Fatal("cannot find client span")
+}
+
+func TestClientTagRequestHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		options  []ClientOption
+		expected map[string]interface{}
+	}{
+		{
+			"default redactor",
+			[]ClientOption{ClientTagRequestHeaders("x-api-version", "Authorization", "X-Missing")},
+			makeTags(
+				"http.request.header.x-api-version", "2",
+				"http.request.header.authorization", RedactedValue,
+				"http.request.header.x-missing", nil,
+			),
+		},
+		{
+			"custom redactor",
+			[]ClientOption{
+				ClientTagRequestHeaders("X-Api-Version"),
+				ClientHeaderRedactor(func(name, value string) string { return name + "=" + value }),
+			},
+			makeTags("http.request.header.x-api-version", "X-Api-Version=2"),
+		},
+		{
+			"disabled",
+			nil,
+			makeTags("http.request.header.x-api-version", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Api-Version", "2")
+			req.Header.Set("Authorization", "Bearer secret")
+			req, ht := TraceRequest(tr, req, append(tt.options, ClientTrace(false))...)
+			resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			ht.Finish()
+
+			for _, span := range tr.FinishedSpans() {
+				if span.OperationName != "HTTP GET" {
+					continue
+				}
+				for k, expected := range tt.expected {
+					if got := span.Tag(k); got != expected {
+						t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+					}
+				}
+				return
+			}
+			t.Fatal("cannot find client span")
+		})
+	}
+}
+
+func TestClientRootIDHint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	hint := func(r *http.Request) string { return r.Header.Get("X-Request-Id") }
+	for _, withParent := range []bool{false, true} {
+		tr := mocktracer.New()
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-Id", "abc")
+		if withParent {
+			parent := tr.StartSpan("parent")
+			req = req.WithContext(opentracing.ContextWithSpan(req.Context(), parent))
+		}
+		req, ht := TraceRequest(tr, req, ClientTrace(false), ClientRootIDHint(hint))
+		resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		ht.Finish()
+
+		var expected interface{} = "abc"
+		if withParent {
+			expected = nil
+		}
+		for _, span := range tr.FinishedSpans() {
+			if span.OperationName != "HTTP Client" {
+				continue
+			}
+			if got := span.Tag(RootIDHintTag); got != expected {
+				t.Fatalf("got hint %v with parent %v, expected %v", got, withParent, expected)
+			}
+		}
+	}
+}
+
+func TestClientCorrelationHeader(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer srv.Close()
+
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(opentracing.ContextWithSpan(req.Context(), parent))
+	req, ht := TraceRequest(tr, req, ClientTrace(false), ClientCorrelationHeader(""))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+
+	traceID := strconv.Itoa(parent.Context().(mocktracer.MockSpanContext).TraceID)
+	if got := header.Get(DefaultCorrelationHeader); got != traceID {
+		t.Fatalf("got %s %q, expected %q", DefaultCorrelationHeader, got, traceID)
+	}
+	if _, err := tr.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != opentracing.ErrSpanContextNotFound {
+		t.Fatalf("got extract error %v, expected the span context not to be injected", err)
+	}
+	for _, span := range tr.FinishedSpans() {
+		if span.OperationName != "HTTP GET" {
+			continue
+		}
+		if got := span.Tag("propagation.downgraded"); got != true {
+			t.Fatalf("got propagation.downgraded %v, expected true", got)
+		}
+	}
+}
+
+func TestClientPeerTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nethttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "server.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("cannot listen on a Unix socket: %v", err)
+	}
+	unixSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unixSrv.Listener.Close()
+	unixSrv.Listener = l
+	unixSrv.Start()
+	defer unixSrv.Close()
+	tcpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tcpSrv.Close()
+
+	unixTransport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	tests := []struct {
+		name      string
+		url       string
+		transport http.RoundTripper
+		expected  map[string]interface{}
+	}{
+		{"unix", "http://unix/", unixTransport, makeTags("transport", "unix", "peer.address", socket, "peer.port", nil)},
+		{"tcp", tcpSrv.URL, http.DefaultTransport, makeTags(
+			"transport", "tcp",
+			"peer.address", "127.0.0.1",
+			"peer.port", uint16(tcpSrv.Listener.Addr().(*net.TCPAddr).Port),
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, ht := TraceRequest(tr, req)
+			resp, err := (&http.Client{Transport: &Transport{RoundTripper: tt.transport}}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			ht.Finish()
+
+			for _, span := range tr.FinishedSpans() {
+				if span.OperationName != "HTTP GET" {
+					continue
+				}
+				for k, expected := range tt.expected {
+					if got := span.Tag(k); got != expected {
+						t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestClientErrorLogs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		enabled  bool
+		expected map[string]string
+		error    interface{}
+	}{
+		{"status", srv.URL, true, map[string]string{"event": "error", "error.kind": "HTTPStatus", "message": "502 Bad Gateway"}, true},
+		{"status disabled", srv.URL, false, nil, true},
+		{"transport failure", closed.URL, true, map[string]string{"event": "error", "error.kind": "*net.OpError"}, true},
+		{"transport failure disabled", closed.URL, false, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, ht := TraceRequest(tr, req, ClientTrace(false), ClientErrorLogs(tt.enabled))
+			if resp, err := (&http.Client{Transport: &Transport{}}).Do(req); err == nil {
+				resp.Body.Close()
+			}
+			ht.Finish()
+
+			for _, span := range tr.FinishedSpans() {
+				if span.OperationName != "HTTP GET" {
+					continue
+				}
+				if got := span.Tag("error"); got != tt.error {
+					t.Fatalf("got error %v, expected %v", got, tt.error)
+				}
+				fields := errorLogFields(span)
+				if (fields == nil) != (tt.expected == nil) {
+					t.Fatalf("got error log %v, expected %v", fields, tt.expected)
+				}
+				for k, want := range tt.expected {
+					if got := fields[k]; got != want {
+						t.Fatalf("got log field %s = %q, expected %q", k, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// DefaultSentAtHeader is the header carrying the time a request was sent
+// at, used by MWClockSkew and ClientSentAtHeader if none is given.
+const DefaultSentAtHeader = "X-Request-Sent-At"
+
+type clockSkewOptions struct {
+	header    string
+	threshold time.Duration
+}
+
+// MWClockSkew returns a MWOption that compares the time the upstream
+// service sent the request at, read from the request header named
+// header, DefaultSentAtHeader if empty, with the time the middleware
+// received it. The difference is tagged clock.upstream_delta_us, in
+// microseconds, and clock.skew_suspected=true is tagged if it is
+// negative, meaning that the request was received before it was sent,
+// or larger than threshold, which should be well above the network
+// latency between the services. Durations between the spans of both
+// services are then distorted by the difference between their clocks.
+//
+// The header holds a Unix timestamp in seconds, milliseconds or
+// microseconds, as sent by ClientSentAtHeader, or a RFC 3339 time.
+// Requests without it, or with an invalid one, are not tagged.
+func MWClockSkew(header string, threshold time.Duration) MWOption {
+	if header == "" {
+		header = DefaultSentAtHeader
+	}
+	o := &clockSkewOptions{header: header, threshold: threshold}
+	return func(options *mwOptions) {
+		options.clockSkew = o
+	}
+}
+
+// ClientSentAtHeader returns a ClientOption that sets the request header
+// named header, DefaultSentAtHeader if empty, to the time the request is
+// sent at, as a Unix timestamp in milliseconds, for services checking
+// the clock skew with MWClockSkew.
+func ClientSentAtHeader(header string) ClientOption {
+	if header == "" {
+		header = DefaultSentAtHeader
+	}
+	return func(options *clientOptions) {
+		options.sentAtHeader = header
+	}
+}
+
+// tag tags sp with the difference between the time r was sent at and
+// received, the latter being now.
+func (o *clockSkewOptions) tag(sp opentracing.Span, r *http.Request, now time.Time) {
+	v := r.Header.Get(o.header)
+	if v == "" {
+		return
+	}
+	sent, ok := parseSentAt(v)
+	if !ok {
+		return
+	}
+	d := now.Sub(sent)
+	sp.SetTag("clock.upstream_delta_us", int64(d/time.Microsecond))
+	if d < 0 || d > o.threshold {
+		sp.SetTag("clock.skew_suspected", true)
+	}
+}
+
+func parseSentAt(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	return parseEdgeStart(v)
+}
+
+// sentAtValue returns the header value telling that a request is sent at
+// t.
+func sentAtValue(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
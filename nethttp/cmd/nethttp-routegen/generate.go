@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+)
+
+// generate returns the source of the file declaring the options of
+// routes, generated from the file named source.
+func generate(pkg, source string, routes []route) ([]byte, error) {
+	var needsHTTP, needsURL, needsRedactor bool
+	for _, r := range routes {
+		if r.Operation != "" || r.Untraced || len(r.RedactHeaders) > 0 {
+			needsHTTP = true
+		}
+		if r.RedactQuery {
+			needsURL = true
+		}
+		if len(r.RedactHeaders) > 0 {
+			needsRedactor = true
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by nethttp-routegen from %s; DO NOT EDIT.\n\n", filepath.Base(source))
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "import (\n")
+	if needsHTTP {
+		fmt.Fprintf(&b, "\t\"net/http\"\n")
+	}
+	if needsURL {
+		fmt.Fprintf(&b, "\t\"net/url\"\n")
+	}
+	fmt.Fprintf(&b, "\n\t\"github.com/opentracing-contrib/go-stdlib/nethttp\"\n")
+	fmt.Fprintf(&b, ")\n\n")
+
+	fmt.Fprintf(&b, "// Patterns of the traced routes.\n")
+	fmt.Fprintf(&b, "const (\n")
+	for _, r := range routes {
+		fmt.Fprintf(&b, "\tRoute%s = %s\n", r.Name, strconv.Quote(r.Pattern))
+	}
+	fmt.Fprintf(&b, ")\n\n")
+
+	fmt.Fprintf(&b, "// TracingRoutes returns the tracing options of every route, to be\n")
+	fmt.Fprintf(&b, "// given to nethttp.MWRouteOptions.\n")
+	fmt.Fprintf(&b, "func TracingRoutes() *nethttp.RouteOptions {\n")
+	fmt.Fprintf(&b, "\treturn nethttp.NewRouteOptions()")
+	for _, r := range routes {
+		fmt.Fprintf(&b, ".\n\t\tAdd(Route%s, %sTracingOptions()...)", r.Name, r.Name)
+	}
+	fmt.Fprintf(&b, "\n}\n")
+
+	for _, r := range routes {
+		fmt.Fprintf(&b, "\n// %sTracingOptions returns the tracing options of Route%s.\n", r.Name, r.Name)
+		fmt.Fprintf(&b, "func %sTracingOptions() []nethttp.MWOption {\n", r.Name)
+		fmt.Fprintf(&b, "\treturn []nethttp.MWOption{\n")
+		writeOptions(&b, r)
+		fmt.Fprintf(&b, "\t}\n")
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	if needsURL {
+		fmt.Fprintf(&b, "\n// urlWithoutQuery returns u without its query.\n")
+		fmt.Fprintf(&b, "func urlWithoutQuery(u *url.URL) string {\n")
+		fmt.Fprintf(&b, "\tv := *u\n")
+		fmt.Fprintf(&b, "\tv.RawQuery = \"\"\n")
+		fmt.Fprintf(&b, "\tv.ForceQuery = false\n")
+		fmt.Fprintf(&b, "\treturn v.String()\n")
+		fmt.Fprintf(&b, "}\n")
+	}
+	if needsRedactor {
+		fmt.Fprintf(&b, "\n// headerRedactor returns a header redactor redacting the named\n")
+		fmt.Fprintf(&b, "// headers on top of those of nethttp.DefaultHeaderRedactor.\n")
+		fmt.Fprintf(&b, "func headerRedactor(names ...string) func(name, value string) string {\n")
+		fmt.Fprintf(&b, "\tredacted := make(map[string]bool, len(names))\n")
+		fmt.Fprintf(&b, "\tfor _, name := range names {\n")
+		fmt.Fprintf(&b, "\t\tredacted[http.CanonicalHeaderKey(name)] = true\n")
+		fmt.Fprintf(&b, "\t}\n")
+		fmt.Fprintf(&b, "\treturn func(name, value string) string {\n")
+		fmt.Fprintf(&b, "\t\tif redacted[http.CanonicalHeaderKey(name)] {\n")
+		fmt.Fprintf(&b, "\t\t\treturn nethttp.RedactedValue\n")
+		fmt.Fprintf(&b, "\t\t}\n")
+		fmt.Fprintf(&b, "\t\treturn nethttp.DefaultHeaderRedactor(name, value)\n")
+		fmt.Fprintf(&b, "\t}\n")
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	return format.Source(b.Bytes())
+}
+
+// writeOptions writes the options of r, one per line.
+func writeOptions(b *bytes.Buffer, r route) {
+	if r.Untraced {
+		fmt.Fprintf(b, "\t\tnethttp.MWSpanFilter(func(r *http.Request) bool { return false }),\n")
+		return
+	}
+	switch {
+	case r.methodOperation:
+		fmt.Fprintf(b, "\t\tnethttp.OperationNameFunc(func(r *http.Request) string { return r.Method + %s }),\n", strconv.Quote(" "+r.Operation))
+	case r.Operation != "":
+		fmt.Fprintf(b, "\t\tnethttp.OperationNameFunc(func(r *http.Request) string { return %s }),\n", strconv.Quote(r.Operation))
+	}
+	if r.Sampling != nil {
+		fmt.Fprintf(b, "\t\tnethttp.MWSpanSampler(nethttp.ProbabilisticSampler(%s)),\n", strconv.FormatFloat(*r.Sampling, 'g', -1, 64))
+	}
+	if r.RedactQuery {
+		fmt.Fprintf(b, "\t\tnethttp.MWURLTagFunc(urlWithoutQuery),\n")
+	}
+	if len(r.CaptureHeaders) > 0 {
+		fmt.Fprintf(b, "\t\tnethttp.MWCaptureRequestHeaders([]string{%s}),\n", quoteList(r.CaptureHeaders))
+	}
+	if len(r.RedactHeaders) > 0 {
+		fmt.Fprintf(b, "\t\tnethttp.MWHeaderRedactor(headerRedactor(%s)),\n", quoteList(r.RedactHeaders))
+	}
+}
+
+func quoteList(values []string) string {
+	var b bytes.Buffer
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Quote(v))
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+// Command nethttp-routegen generates the tracing options of the routes of
+// an API, as code registering them in a nethttp.RouteOptions, from a
+// route list or an OpenAPI file. It is meant to be run with go generate:
+//
+//	//go:generate go run github.com/opentracing-contrib/go-stdlib/nethttp/cmd/nethttp-routegen -in routes.json -out tracing_routes.go -pkg api
+//
+// A route list is a JSON file such as:
+//
+//	{
+//	  "routes": [
+//	    {
+//	      "name": "Users",
+//	      "pattern": "/users/*",
+//	      "operation": "users",
+//	      "sampling": 0.1,
+//	      "redactQuery": true,
+//	      "captureHeaders": ["X-Api-Version", "X-Client-Secret"],
+//	      "redactHeaders": ["X-Client-Secret"]
+//	    },
+//	    {"name": "Health", "pattern": "/health", "untraced": true}
+//	  ]
+//	}
+//
+// The settings of a route are:
+//
+//	name            Go name of the route, used in the generated identifiers
+//	pattern         pattern of the route, see nethttp.RouteOptions.Add
+//	operation       operation name of the spans
+//	sampling        fraction of the requests traced
+//	untraced        true to not trace the requests at all
+//	redactQuery     true to leave the query out of the http.url tag
+//	captureHeaders  request headers copied into tags
+//	redactHeaders   captured headers whose values are redacted
+//
+// In an OpenAPI (or Swagger) JSON file, every path is a route. Its
+// settings are read from the x-tracing extension of the path item,
+// except for the name, derived from the path and numbered if paths
+// differing only by punctuation would share it, and the pattern, the
+// path itself, whose parameters are RouteOptions wildcards, e.g.
+// "/users/{id}". Unless set, the operation name is the method followed
+// by the path.
+//
+// For every route, the generated file declares a Route<name> constant
+// holding its pattern and a <name>TracingOptions function returning its
+// options. TracingRoutes returns the RouteOptions registering them all,
+// to be given to nethttp.MWRouteOptions.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	in := flag.String("in", "", "route list or OpenAPI JSON `file`")
+	out := flag.String("out", "", "output `file`, standard output if empty")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package `name` of the generated file")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("nethttp-routegen: ")
+	if *in == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	routes, err := parseRoutes(data)
+	if err != nil {
+		log.Fatalf("%s: %v", *in, err)
+	}
+	src, err := generate(*pkg, *in, routes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		fmt.Print(string(src))
+		return
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
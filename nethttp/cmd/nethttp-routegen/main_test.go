@@ -0,0 +1,135 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const routeList = `{
+  "routes": [
+    {
+      "name": "Users",
+      "pattern": "/users/*",
+      "operation": "users",
+      "sampling": 0.1,
+      "redactQuery": true,
+      "captureHeaders": ["X-Api-Version", "X-Client-Secret"],
+      "redactHeaders": ["X-Client-Secret"]
+    },
+    {"name": "Health", "pattern": "/health", "untraced": true}
+  ]
+}`
+
+const openAPI = `{
+  "openapi": "3.0.0",
+  "paths": {
+    "/users/{id}/orders": {
+      "get": {},
+      "x-tracing": {"sampling": 0.5}
+    },
+    "/status": {
+      "get": {},
+      "x-tracing": {"operation": "status"}
+    },
+    "/users/{id}": {
+      "get": {}
+    },
+    "/files/{name}.json": {
+      "get": {}
+    },
+    "/a-b": {
+      "get": {}
+    },
+    "/a_b": {
+      "get": {}
+    },
+    "/unused": {
+      "parameters": []
+    }
+  }
+}`
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			"route list",
+			routeList,
+			[]string{
+				`RouteUsers  = "/users/*"`,
+				`RouteHealth = "/health"`,
+				`Add(RouteUsers, UsersTracingOptions()...)`,
+				`nethttp.OperationNameFunc(func(r *http.Request) string { return "users" })`,
+				`nethttp.MWSpanSampler(nethttp.ProbabilisticSampler(0.1))`,
+				`nethttp.MWURLTagFunc(urlWithoutQuery)`,
+				`nethttp.MWCaptureRequestHeaders([]string{"X-Api-Version", "X-Client-Secret"})`,
+				`nethttp.MWHeaderRedactor(headerRedactor("X-Client-Secret"))`,
+				`nethttp.MWSpanFilter(func(r *http.Request) bool { return false })`,
+				`func urlWithoutQuery(u *url.URL) string {`,
+			},
+		},
+		{
+			"openapi",
+			openAPI,
+			[]string{
+				`RouteStatus        = "/status"`,
+				`RouteUsersIDOrders = "/users/{id}/orders"`,
+				`RouteUsersID       = "/users/{id}"`,
+				`RouteFilesNameJson = "/files/{name.json}"`,
+				`RouteAB            = "/a-b"`,
+				`RouteAB2           = "/a_b"`,
+				`nethttp.OperationNameFunc(func(r *http.Request) string { return r.Method + " /users/{id}/orders" })`,
+				`nethttp.OperationNameFunc(func(r *http.Request) string { return "status" })`,
+				`nethttp.MWSpanSampler(nethttp.ProbabilisticSampler(0.5))`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := parseRoutes([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := generate("api", "routes.json", routes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+				t.Fatalf("generated invalid code: %v\n%s", err, src)
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(string(src), s) {
+					t.Fatalf("generated code lacks %q:\n%s", s, src)
+				}
+			}
+			if tt.name == "openapi" && strings.Contains(string(src), "Unused") {
+				t.Fatalf("generated a route for a path without operation:\n%s", src)
+			}
+		})
+	}
+}
+
+func TestParseRoutesErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`{"routes": []}`, "no routes"},
+		{`{"routes": [{"name": "users", "pattern": "/users"}]}`, "not an exported Go identifier"},
+		{`{"routes": [{"name": "A", "pattern": "/a"}, {"name": "A", "pattern": "/b"}]}`, "duplicate name"},
+		{`{"routes": [{"name": "A", "pattern": "/a"}, {"name": "B", "pattern": "/a"}]}`, "duplicate pattern"},
+		{`{"routes": [{"name": "A", "pattern": "a"}]}`, "does not start with /"},
+		{`{"routes": [{"name": "A", "pattern": "/a", "sampling": 2}]}`, "not between 0 and 1"},
+	}
+	for _, tt := range tests {
+		_, err := parseRoutes([]byte(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("got error %v for %s, expected %q", err, tt.input, tt.err)
+		}
+	}
+}
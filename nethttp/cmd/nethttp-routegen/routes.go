@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// route holds the tracing settings of a route.
+type route struct {
+	Name           string   `json:"name"`
+	Pattern        string   `json:"pattern"`
+	Operation      string   `json:"operation"`
+	Sampling       *float64 `json:"sampling"`
+	Untraced       bool     `json:"untraced"`
+	RedactQuery    bool     `json:"redactQuery"`
+	CaptureHeaders []string `json:"captureHeaders"`
+	RedactHeaders  []string `json:"redactHeaders"`
+
+	// methodOperation is true if the operation name is the method
+	// followed by Operation.
+	methodOperation bool
+}
+
+// openAPIMethods are the operations of an OpenAPI path item.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// parseRoutes parses a route list or an OpenAPI file and validates the
+// routes.
+func parseRoutes(data []byte) ([]route, error) {
+	var doc struct {
+		Routes  []route                               `json:"routes"`
+		OpenAPI string                                `json:"openapi"`
+		Swagger string                                `json:"swagger"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	routes := doc.Routes
+	if doc.OpenAPI != "" || doc.Swagger != "" {
+		var err error
+		if routes, err = openAPIRoutes(doc.Paths); err != nil {
+			return nil, err
+		}
+	}
+	if len(routes) == 0 {
+		return nil, errors.New("no routes")
+	}
+	names := make(map[string]bool)
+	patterns := make(map[string]bool)
+	for _, r := range routes {
+		if !token.IsIdentifier(r.Name) || !token.IsExported(r.Name) {
+			return nil, fmt.Errorf("route %q: name is not an exported Go identifier", r.Name)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("route %q: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+		if !strings.HasPrefix(r.Pattern, "/") {
+			return nil, fmt.Errorf("route %q: pattern %q does not start with /", r.Name, r.Pattern)
+		}
+		if patterns[r.Pattern] {
+			return nil, fmt.Errorf("route %q: duplicate pattern %q", r.Name, r.Pattern)
+		}
+		patterns[r.Pattern] = true
+		if r.Sampling != nil && (*r.Sampling < 0 || *r.Sampling > 1) {
+			return nil, fmt.Errorf("route %q: sampling %v is not between 0 and 1", r.Name, *r.Sampling)
+		}
+	}
+	return routes, nil
+}
+
+// openAPIRoutes returns a route for every path of an OpenAPI file.
+func openAPIRoutes(paths map[string]map[string]json.RawMessage) ([]route, error) {
+	keys := make([]string, 0, len(paths))
+	for path := range paths {
+		keys = append(keys, path)
+	}
+	sort.Strings(keys)
+
+	routes := make([]route, 0, len(keys))
+	names := make(map[string]bool, len(keys))
+	for _, path := range keys {
+		item := paths[path]
+		hasOperation := false
+		for _, method := range openAPIMethods {
+			if _, ok := item[method]; ok {
+				hasOperation = true
+			}
+		}
+		if !hasOperation {
+			continue
+		}
+		var r route
+		if ext, ok := item["x-tracing"]; ok {
+			if err := json.Unmarshal(ext, &r); err != nil {
+				return nil, fmt.Errorf("path %q: x-tracing: %v", path, err)
+			}
+		}
+		// distinct paths may differ only by punctuation, e.g. "/a-b" and
+		// "/a_b", so their names are numbered in the order of the paths
+		r.Name = pathName(path)
+		for i := 2; names[r.Name]; i++ {
+			r.Name = fmt.Sprintf("%s%d", pathName(path), i)
+		}
+		names[r.Name] = true
+		r.Pattern = pathPattern(path)
+		if r.Operation == "" {
+			r.Operation = path
+			r.methodOperation = true
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// pathPattern returns the RouteOptions pattern matching an OpenAPI path.
+// Templated segments are kept as wildcards, and those only partly
+// templated, e.g. "{name}.json", are widened to whole segments, since
+// RouteOptions only matches whole segments.
+func pathPattern(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if !strings.Contains(seg, "{") || (strings.HasPrefix(seg, "{") && strings.Count(seg, "{") == 1 && strings.HasSuffix(seg, "}")) {
+			continue
+		}
+		segments[i] = "{" + strings.NewReplacer("{", "", "}", "").Replace(seg) + "}"
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathName returns a Go name for an OpenAPI path, e.g. UsersIDOrders
+// for "/users/{id}/orders", or Root for "/".
+func pathName(path string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if strings.EqualFold(word, "id") {
+			b.WriteString("ID")
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	name := b.String()
+	if name == "" {
+		return "Root"
+	}
+	if unicode.IsDigit(rune(name[0])) {
+		name = "Path" + name
+	}
+	return name
+}
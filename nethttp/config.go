@@ -0,0 +1,169 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// mwConfig is the effective configuration of a middleware reported by
+// ConfigHandler.
+type mwConfig struct {
+	Tracer      string          `json:"tracer"`
+	Defaults    []string        `json:"default_options,omitempty"`
+	Options     []string        `json:"options"`
+	Filters     []string        `json:"filters,omitempty"`
+	Component   string          `json:"component,omitempty"`
+	Propagators []string        `json:"propagators,omitempty"`
+	Sampling    samplingConfig  `json:"sampling"`
+	Redaction   redactionConfig `json:"redaction"`
+}
+
+type samplingConfig struct {
+	Sampler               string  `json:"sampler,omitempty"`
+	Probability           float64 `json:"probability,omitempty"`
+	SpansPerSecond        float64 `json:"spans_per_second,omitempty"`
+	AllocationSampling    float64 `json:"allocation_sampling,omitempty"`
+	GoroutineLeakSampling float64 `json:"goroutine_leak_sampling,omitempty"`
+}
+
+type redactionConfig struct {
+	HeaderRedactor      string   `json:"header_redactor"`
+	RequestHeaders      []string `json:"request_headers,omitempty"`
+	ResponseHeaders     []string `json:"response_headers,omitempty"`
+	RedactedQueryParams []string `json:"redacted_query_params,omitempty"`
+	AllowedQueryParams  []string `json:"allowed_query_params,omitempty"`
+}
+
+// ConfigHandler returns a handler reporting the effective configuration
+// of mw, which must be returned by Middleware, as JSON, to debug
+// deployments where it is unclear which options are live: the tracer,
+// the options set by SetDefaultMWOptions and those given to Middleware
+// in order, e.g. "MWSpanFilter", the sampling rates and the redaction
+// rules. It is meant to be served on an administration port:
+//
+//	h := nethttp.Middleware(tracer, mux, options...)
+//	adminMux.Handle("/debug/tracing", nethttp.ConfigHandler(h))
+//
+// The configuration is the one resolved when Middleware was called.
+// Custom functions, such as span filters, are reported by name only.
+// If mw is not returned by Middleware, e.g. if it is returned by
+// MiddlewareFunc, the handler responds with an error.
+func ConfigHandler(mw http.Handler) http.Handler {
+	th, ok := mw.(*tracedHandler)
+	if !ok {
+		msg := fmt.Sprintf("nethttp: ConfigHandler of %T, not returned by Middleware", mw)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, msg, http.StatusInternalServerError)
+		})
+	}
+	body, err := json.MarshalIndent(newMWConfig(th.tr, th.opts, th.options), "", "  ")
+	if err != nil {
+		msg := fmt.Sprintf("nethttp: cannot encode the configuration: %v", err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, msg, http.StatusInternalServerError)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+// newMWConfig returns the configuration of a middleware created with
+// tr and options, which resolved to opts.
+func newMWConfig(tr opentracing.Tracer, opts *mwOptions, options []MWOption) *mwConfig {
+	c := &mwConfig{
+		Tracer:      fmt.Sprintf("%T", tr),
+		Options:     make([]string, 0, len(options)),
+		Component:   opts.componentName,
+		Propagators: opts.propagators,
+	}
+	for _, opt := range opts.defaultOptions {
+		c.Defaults = append(c.Defaults, funcName(opt))
+	}
+	for _, opt := range options {
+		c.Options = append(c.Options, funcName(opt))
+	}
+	filters := []struct {
+		option string
+		set    bool
+	}{
+		{"MWSpanFilter", reflect.ValueOf(opts.spanFilter).Pointer() != reflect.ValueOf(traceAll).Pointer()},
+		{"MWSpanContextFilter", opts.spanContextFilter != nil},
+		{"MWSkipPreflight", opts.skipPreflight},
+		{"MWTagFilter", opts.tagFilter != nil},
+	}
+	for _, f := range filters {
+		if f.set {
+			c.Filters = append(c.Filters, f.option)
+		}
+	}
+
+	switch s := opts.sampler.(type) {
+	case nil:
+	case probabilisticSampler:
+		c.Sampling.Sampler = "probabilistic"
+		c.Sampling.Probability = float64(s)
+	case *tokenBucket:
+		c.Sampling.Sampler = "rate_limiting"
+		c.Sampling.SpansPerSecond = s.rate
+	case SpanSamplerFunc:
+		c.Sampling.Sampler = funcName(s)
+	default:
+		c.Sampling.Sampler = fmt.Sprintf("%T", s)
+	}
+	c.Sampling.AllocationSampling = opts.allocSampling
+	if opts.leaks != nil {
+		c.Sampling.GoroutineLeakSampling = opts.leaks.fraction
+	}
+
+	c.Redaction.HeaderRedactor = "default"
+	if reflect.ValueOf(opts.headerRedactor).Pointer() != reflect.ValueOf(DefaultHeaderRedactor).Pointer() {
+		c.Redaction.HeaderRedactor = funcName(opts.headerRedactor)
+	}
+	c.Redaction.RequestHeaders = opts.requestHeaders
+	c.Redaction.ResponseHeaders = opts.responseHeaders
+	if q := opts.queryRedaction; q != nil {
+		names := make([]string, 0, len(q.names))
+		for name := range q.names {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if q.allow {
+			c.Redaction.AllowedQueryParams = names
+		} else {
+			c.Redaction.RedactedQueryParams = names
+		}
+	}
+	return c
+}
+
+// closureSuffix matches the suffix of the names of closures, which may
+// be nested.
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// funcName returns the name of the function f, without its package, or
+// of the function that created it if it is a closure, e.g. MWSpanFilter
+// for the MWOption it returns.
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := closureSuffix.ReplaceAllString(fn.Name(), "")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
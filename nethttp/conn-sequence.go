@@ -0,0 +1,48 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// connSequence counts the requests served on a connection.
+type connSequence struct {
+	n int64
+}
+
+// ConnSequenceContext is meant to be used as, or called by, the
+// ConnContext function of an http.Server (Go 1.13 and later). It adds a
+// request counter to the context of every connection, so that the
+// server-side spans of its requests are tagged http.connection.sequence
+// with their rank on the connection: 1 for the first request, 2 for the
+// second one reusing a keep-alive connection, and so on. This helps
+// diagnosing proxies that only misbehave on reused connections.
+//
+// Requests multiplexed on an HTTP/2 connection are numbered in the order
+// they reach the middleware.
+//
+// Example:
+//
+//	srv := &http.Server{
+//		Handler:     nethttp.Middleware(tracer, mux),
+//		ConnContext: nethttp.ConnSequenceContext,
+//	}
+func ConnSequenceContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, keyConnSequence, &connSequence{})
+}
+
+// tagConnSequence counts the request on the connection of ctx, if it is
+// numbered, and tags sp with its rank.
+func tagConnSequence(ctx context.Context, sp opentracing.Span) {
+	seq, ok := ctx.Value(keyConnSequence).(*connSequence)
+	if !ok {
+		return
+	}
+	sp.SetTag("http.connection.sequence", atomic.AddInt64(&seq.n, 1))
+}
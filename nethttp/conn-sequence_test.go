@@ -0,0 +1,47 @@
+//go:build go1.13
+// +build go1.13
+
+package nethttp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestConnSequenceContext(t *testing.T) {
+	tr := mocktracer.New()
+	srv := httptest.NewUnstartedServer(Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})))
+	srv.Config.ConnContext = ConnSequenceContext
+	srv.Start()
+	defer srv.Close()
+
+	get := func(client *http.Client) {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	keepAlive := &http.Client{Transport: &http.Transport{}}
+	get(keepAlive)
+	get(keepAlive)
+	get(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}})
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 3; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	for i, want := range []int64{1, 2, 1} {
+		if got := spans[i].Tag("http.connection.sequence"); got != want {
+			t.Fatalf("got sequence %v for request %d, expected %d", got, i, want)
+		}
+	}
+}
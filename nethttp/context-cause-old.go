@@ -0,0 +1,12 @@
+//go:build go1.7 && !go1.20
+// +build go1.7,!go1.20
+
+package nethttp
+
+import "context"
+
+// contextCause returns the cause of the cancellation of ctx, which is
+// only known from Go 1.20 on.
+func contextCause(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,45 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// DefaultCorrelationHeader is the header carrying the trace ID sent by
+// ClientCorrelationHeader if none is given.
+const DefaultCorrelationHeader = "X-Trace-Id"
+
+// ClientCorrelationHeader returns a ClientOption that, instead of
+// injecting the span context, only sets the request header named
+// header, DefaultCorrelationHeader if empty, to the trace ID, for
+// backends known not to propagate the trace context, e.g. legacy
+// services or third parties, so that their logs can still be correlated
+// with the trace. The client-side span is then tagged
+// propagation.downgraded=true.
+//
+// The trace ID is read from the span context of tracers exposing it,
+// such as Jaeger, Zipkin and mocktracer. Nothing is sent if it is not
+// known.
+func ClientCorrelationHeader(header string) ClientOption {
+	if header == "" {
+		header = DefaultCorrelationHeader
+	}
+	return func(options *clientOptions) {
+		options.correlationHeader = header
+	}
+}
+
+// injectCorrelationHeader sets the header named name of h to the trace
+// ID of sp.
+func injectCorrelationHeader(sp opentracing.Span, h http.Header, name string) {
+	traceID := traceIDOf(sp.Context())
+	if traceID == "" {
+		return
+	}
+	h.Set(name, traceID)
+	sp.SetTag("propagation.downgraded", true)
+}
@@ -0,0 +1,77 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// costs sums the cost units reported for a request.
+type costs struct {
+	mu     sync.Mutex
+	totals map[string]float64
+}
+
+// MWCostAccounting returns a MWOption that turns on or off the
+// accounting of the costs reported by handlers with AddCost. The total
+// of every unit is tagged cost.<unit> on the server-side span once the
+// request is served, so that the cost of requests is recorded the same
+// way by every service.
+func MWCostAccounting(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.costAccounting = enabled
+	}
+}
+
+// AddCost adds amount to the cost of the request being served with ctx,
+// in the given unit, e.g. "db.rows_scanned", "llm.tokens" or "cents".
+// It is safe to call from concurrent goroutines of the handler, and does
+// nothing unless the request is traced with MWCostAccounting.
+//
+// Example:
+//
+//	rows, err := db.QueryContext(ctx, query)
+//	...
+//	nethttp.AddCost(r.Context(), "db.rows_scanned", float64(n))
+func AddCost(ctx context.Context, unit string, amount float64) {
+	c, ok := ctx.Value(keyCosts).(*costs)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.totals[unit] += amount
+	c.mu.Unlock()
+}
+
+// Costs returns the total of every unit of cost reported so far for the
+// request being served with ctx, or nil if costs are not accounted.
+func Costs(ctx context.Context) map[string]float64 {
+	c, ok := ctx.Value(keyCosts).(*costs)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	totals := make(map[string]float64, len(c.totals))
+	for unit, total := range c.totals {
+		totals[unit] = total
+	}
+	return totals
+}
+
+func contextWithCosts(ctx context.Context) (context.Context, *costs) {
+	c := &costs{totals: make(map[string]float64)}
+	return context.WithValue(ctx, keyCosts, c), c
+}
+
+func (c *costs) tag(sp opentracing.Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for unit, total := range c.totals {
+		sp.SetTag("cost."+unit, total)
+	}
+}
@@ -0,0 +1,47 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// CSRFValidator reports whether a request carries a CSRF token and
+// whether the token is valid.
+type CSRFValidator func(r *http.Request) (present, valid bool)
+
+// DoubleSubmitCSRF returns a CSRFValidator for the double-submit cookie
+// pattern: the token is present if the request has both the cookie
+// cookieName and the header headerName, and valid if they are equal.
+func DoubleSubmitCSRF(cookieName, headerName string) CSRFValidator {
+	return func(r *http.Request) (bool, bool) {
+		cookie, err := r.Cookie(cookieName)
+		token := r.Header.Get(headerName)
+		if err != nil || cookie.Value == "" || token == "" {
+			return false, false
+		}
+		return true, subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+	}
+}
+
+// MWCSRFObservation returns a MWOption that checks the CSRF token of
+// state-changing requests, i.e. requests whose method is not GET, HEAD,
+// OPTIONS or TRACE, with v and tags the span with csrf.present and
+// csrf.valid, so that CSRF failures show up per trace. Requests are
+// only observed, never rejected.
+func MWCSRFObservation(v CSRFValidator) MWOption {
+	return func(options *mwOptions) {
+		options.csrf = v
+	}
+}
+
+// isSafeMethod reports whether method is not state-changing.
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// DebugTraceHeader is the request header escalating a request to a full
+// debug trace, see MWDebugTraceHeader.
+const DebugTraceHeader = "X-Debug-Trace"
+
+// maxDebugBodyBytes bounds the request body captured for debug traces.
+const maxDebugBodyBytes = 64 << 10
+
+// MWDebugTraceHeader returns a MWOption that escalates the requests
+// carrying a X-Debug-Trace header accepted by verify to full
+// verbosity, enabling on-demand deep traces in production:
+//
+//   - the span is forced to be sampled, regardless of MWSpanSampler and
+//     MWTracingDecision, and tagged trace.verbosity=debug,
+//   - the first 64KiB of the request body are logged,
+//   - TraceRequest turns on the httptrace child spans of the outgoing
+//     requests made with the request context, even if disabled with
+//     ClientTrace(false).
+//
+// verify must authenticate the header, e.g. with the verifier returned
+// by HMACDebugTraceVerifier, as anyone can send it. The escalation is
+// kept in the request context rather than in baggage, which callers
+// could forge, so it does not reach other services: they escalate their
+// requests if they are given the header too.
+func MWDebugTraceHeader(verify func(value string) bool) MWOption {
+	return func(options *mwOptions) {
+		options.debugTrace = verify
+	}
+}
+
+// HMACDebugTraceVerifier returns a verifier for MWDebugTraceHeader
+// accepting the values produced by SignDebugTrace with key, for maxAge
+// after they were signed.
+func HMACDebugTraceVerifier(key []byte, maxAge time.Duration) func(value string) bool {
+	return func(value string) bool {
+		i := strings.IndexByte(value, '.')
+		if i < 0 {
+			return false
+		}
+		ts, err := strconv.ParseInt(value[:i], 10, 64)
+		if err != nil {
+			return false
+		}
+		signedAt := time.Unix(ts, 0)
+		if age := time.Since(signedAt); age > maxAge || age < -maxAge {
+			return false
+		}
+		return hmac.Equal([]byte(value), []byte(SignDebugTrace(key, signedAt)))
+	}
+}
+
+// SignDebugTrace returns a X-Debug-Trace header value signed with key at
+// time t, of the form "<unix seconds>.<hex HMAC-SHA256>".
+func SignDebugTrace(key []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts))
+	return ts + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isDebugTrace reports whether r asks for a debug trace accepted by
+// verify.
+func isDebugTrace(r *http.Request, verify func(value string) bool) bool {
+	value := r.Header.Get(DebugTraceHeader)
+	return value != "" && verify(value)
+}
+
+// isDebugContext reports whether ctx is the context of a request
+// escalated by MWDebugTraceHeader, once verified by this process.
+func isDebugContext(ctx context.Context) bool {
+	debug, _ := ctx.Value(keyDebugTrace).(bool)
+	return debug
+}
+
+// logDebugBody logs the request body captured by b.
+func logDebugBody(sp opentracing.Span, b *bodyTracker) {
+	sp.LogFields(
+		log.String("event", "RequestBody"),
+		log.String("http.request.body", string(b.captured)),
+		log.Bool("truncated", b.n > int64(len(b.captured))),
+	)
+}
@@ -0,0 +1,149 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Verbosity is the level of tracing detail applied to a request.
+type Verbosity int
+
+const (
+	// VerbosityDefault traces the request as usual.
+	VerbosityDefault Verbosity = iota
+	// VerbosityOff does not create a span for the request.
+	VerbosityOff
+	// VerbosityDebug forces the span to be sampled and tags it with
+	// trace.verbosity=debug.
+	VerbosityDebug
+)
+
+// maxDecisionCacheEntries bounds the memory used by the decision cache.
+// The least recently used keys are evicted beyond this size.
+const maxDecisionCacheEntries = 10000
+
+type decisionEntry struct {
+	key       string
+	verbosity Verbosity
+	expires   time.Time
+}
+
+// decisionCall is a call to decide in progress, which the requests of
+// the same key wait for instead of calling decide too.
+type decisionCall struct {
+	done      chan struct{}
+	verbosity Verbosity
+}
+
+type decisionCache struct {
+	keyFunc    func(r *http.Request) string
+	decide     func(key string) (Verbosity, error)
+	ttl        time.Duration
+	now        func() time.Time
+	maxEntries int
+
+	mu sync.Mutex
+	// entries holds the elements of lru, whose values are
+	// *decisionEntry, most recently used first.
+	entries map[string]*list.Element
+	lru     *list.List
+	calls   map[string]*decisionCall
+}
+
+// MWTracingDecision returns a MWOption that asks decide for the
+// Verbosity of every request, which may consult a remote configuration
+// service or authorizer. Decisions are cached per key, as computed by
+// keyFunc (e.g. the path or the customer ID), for ttl. Concurrent
+// requests of a key whose decision expired share a single call to
+// decide.
+//
+// The decision fails open: when decide returns an error the last known
+// decision for the key is kept, or VerbosityDefault is used if there is
+// none, so that an unavailable authorizer never disables tracing.
+func MWTracingDecision(keyFunc func(r *http.Request) string, decide func(key string) (Verbosity, error), ttl time.Duration) MWOption {
+	c := newDecisionCache(keyFunc, decide, ttl)
+	return func(options *mwOptions) {
+		options.decision = c
+	}
+}
+
+func newDecisionCache(keyFunc func(r *http.Request) string, decide func(key string) (Verbosity, error), ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		keyFunc:    keyFunc,
+		decide:     decide,
+		ttl:        ttl,
+		now:        time.Now,
+		maxEntries: maxDecisionCacheEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		calls:      make(map[string]*decisionCall),
+	}
+}
+
+func (c *decisionCache) verbosity(r *http.Request) Verbosity {
+	key := c.keyFunc(r)
+	now := c.now()
+
+	c.mu.Lock()
+	var last decisionEntry
+	el, known := c.entries[key]
+	if known {
+		c.lru.MoveToFront(el)
+		last = *el.Value.(*decisionEntry)
+		if now.Before(last.expires) {
+			c.mu.Unlock()
+			return last.verbosity
+		}
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.verbosity
+	}
+	call := &decisionCall{done: make(chan struct{}), verbosity: VerbosityDefault}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	// the waiting requests are released even if decide panics
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	v, err := c.decide(key)
+	if err != nil {
+		if !known {
+			v = VerbosityDefault
+		} else {
+			v = last.verbosity
+		}
+	}
+	call.verbosity = v
+
+	c.mu.Lock()
+	c.store(decisionEntry{key: key, verbosity: v, expires: now.Add(c.ttl)})
+	c.mu.Unlock()
+	return v
+}
+
+// store caches e, evicting the least recently used entry if the cache
+// is full. c.mu must be held.
+func (c *decisionCache) store(e decisionEntry) {
+	if el, ok := c.entries[e.key]; ok {
+		*el.Value.(*decisionEntry) = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(&e)
+	if c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decisionEntry).key)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"sync"
+)
+
+var (
+	defaultMWOptionsMu sync.RWMutex
+	defaultMWOptions   []MWOption
+)
+
+// SetDefaultMWOptions sets the options applied by every Middleware
+// before its own options, which override them, so that a platform team
+// can enforce defaults such as redaction rules, filters or the
+// component name across an organization, while services only give
+// their specific options:
+//
+//	func init() {
+//		nethttp.SetDefaultMWOptions(
+//			nethttp.MWComponentName("acme-http"),
+//			nethttp.MWRedactQueryParams("token", "api_key"),
+//		)
+//	}
+//
+// Each call replaces the defaults set before. They only apply to the
+// Middlewares created afterwards, so they should be set at startup.
+func SetDefaultMWOptions(options ...MWOption) {
+	defaultMWOptionsMu.Lock()
+	defer defaultMWOptionsMu.Unlock()
+	defaultMWOptions = append([]MWOption(nil), options...)
+}
+
+func getDefaultMWOptions() []MWOption {
+	defaultMWOptionsMu.RLock()
+	defer defaultMWOptionsMu.RUnlock()
+	return defaultMWOptions
+}
@@ -0,0 +1,92 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"math/rand"
+	"runtime"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// maxStackDumpBytes bounds the size of the goroutine dump logged by the
+// goroutine leak detection.
+const maxStackDumpBytes = 64 << 10
+
+type leakOptions struct {
+	fraction   float64
+	dumpStacks bool
+}
+
+// MWAllocationSampling returns a MWOption that, for the given fraction
+// of requests (between 0 and 1), measures the heap allocations made
+// while the handler runs and tags the span with runtime.alloc_bytes and
+// runtime.alloc_objects.
+//
+// The counters are process wide: allocations made by concurrent
+// requests are included, so the tags are an estimate that is most
+// accurate at low concurrency. Reading them is not free either, which
+// is why only a sample of the requests is measured.
+func MWAllocationSampling(fraction float64) MWOption {
+	return func(options *mwOptions) {
+		options.allocSampling = fraction
+	}
+}
+
+// MWGoroutineLeakDetection returns a MWOption that, for the given
+// fraction of requests, compares the number of goroutines before and
+// after the handler runs. Spans of requests that appear to leak
+// goroutines are tagged runtime.goroutine_leak=true along with the
+// runtime.goroutine_delta. If dumpStacks is true, the stacks of all
+// goroutines are also logged to the span, which is meant for
+// development environments only.
+//
+// Goroutines started or stopped by concurrent requests are counted
+// too, so a single tagged span is a hint rather than a proof.
+func MWGoroutineLeakDetection(fraction float64, dumpStacks bool) MWOption {
+	return func(options *mwOptions) {
+		options.leaks = &leakOptions{
+			fraction:   fraction,
+			dumpStacks: dumpStacks,
+		}
+	}
+}
+
+func sampled(fraction float64) bool {
+	return fraction >= 1 || (fraction > 0 && rand.Float64() < fraction)
+}
+
+// trackAllocations reads the allocation counters and returns a function
+// tagging sp with the allocations made since.
+func trackAllocations(sp opentracing.Span) func() {
+	bytes, objects := readAllocs()
+	return func() {
+		afterBytes, afterObjects := readAllocs()
+		sp.SetTag("runtime.alloc_bytes", afterBytes-bytes)
+		sp.SetTag("runtime.alloc_objects", afterObjects-objects)
+	}
+}
+
+// trackGoroutines counts the goroutines and returns a function tagging
+// sp if more are running when it is called.
+func trackGoroutines(sp opentracing.Span, dumpStacks bool) func() {
+	before := runtime.NumGoroutine()
+	return func() {
+		delta := runtime.NumGoroutine() - before
+		if delta <= 0 {
+			return
+		}
+		sp.SetTag("runtime.goroutine_leak", true)
+		sp.SetTag("runtime.goroutine_delta", delta)
+		if dumpStacks {
+			buf := make([]byte, maxStackDumpBytes)
+			buf = buf[:runtime.Stack(buf, true)]
+			sp.LogFields(
+				log.String("event", "GoroutineLeak"),
+				log.String("stack", string(buf)),
+			)
+		}
+	}
+}
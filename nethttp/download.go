@@ -0,0 +1,235 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// ErrChecksumMismatch is returned by Download when the checksum of the
+// downloaded content does not match DownloadOptions.ExpectedChecksum.
+var ErrChecksumMismatch = errors.New("nethttp: checksum mismatch")
+
+// DownloadOptions controls the behavior of Download.
+type DownloadOptions struct {
+	// Tracer used to create the spans. Defaults to the tracer of the
+	// span in the context given to Download, or opentracing.GlobalTracer().
+	Tracer opentracing.Tracer
+	// OperationName of the parent span covering the whole download.
+	// Defaults to "HTTP Download".
+	OperationName string
+	// ChunkSize is the number of bytes requested per range. Defaults
+	// to 8 MiB.
+	ChunkSize int64
+	// Offset resumes a previous download at the given byte offset.
+	Offset int64
+	// MaxRetries is the number of consecutive failed attempts allowed
+	// for a range before giving up. Defaults to 3.
+	MaxRetries int
+	// Hash, if set, is fed with every downloaded byte. Its hex encoded
+	// sum is recorded in the download.checksum tag. When resuming with
+	// Offset, Hash must already have been fed with the first Offset
+	// bytes, e.g. by reading them back from the partial file, for its
+	// sum to cover the whole content.
+	Hash hash.Hash
+	// ExpectedChecksum is the hex encoded sum Hash must produce. A
+	// mismatch is reported as ErrChecksumMismatch.
+	ExpectedChecksum string
+	// ClientOptions are passed to TraceRequest for every range.
+	ClientOptions []ClientOption
+}
+
+// Download fetches the resource of req into dst using ranged requests,
+// resuming from the last written byte when a range fails. The download
+// is traced as a parent span with one child span per ranged request,
+// and the parent span is tagged with the total size, the number of
+// ranges and retries, and the integrity check results.
+//
+// The client must use Transport for the range spans to be recorded.
+// Download returns the number of bytes written to dst.
+func Download(ctx context.Context, client *http.Client, req *http.Request, dst io.Writer, opts DownloadOptions) (int64, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	tr := opts.Tracer
+	if tr == nil {
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			tr = parent.Tracer()
+		} else {
+			tr = opentracing.GlobalTracer()
+		}
+	}
+	operationName := opts.OperationName
+	if operationName == "" {
+		operationName = "HTTP Download"
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 8 << 20
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.Hash != nil {
+		dst = io.MultiWriter(dst, opts.Hash)
+	}
+
+	sp, ctx := opentracing.StartSpanFromContextWithTracer(ctx, tr, operationName)
+	defer sp.Finish()
+
+	var (
+		offset   = opts.Offset
+		total    = int64(-1)
+		ranges   int
+		retries  int
+		attempts int
+		written  int64
+		err      error
+	)
+	for total < 0 || offset < total {
+		var (
+			n     int64
+			size  int64
+			retry bool
+		)
+		n, size, retry, err = downloadRange(ctx, tr, client, req, dst, offset, opts)
+		ranges++
+		offset += n
+		written += n
+		if size >= 0 {
+			total = size
+		}
+		if err == nil {
+			attempts = 0
+			continue
+		}
+		if n > 0 {
+			attempts = 0
+		}
+		attempts++
+		if !retry || attempts > opts.MaxRetries || ctx.Err() != nil {
+			break
+		}
+		retries++
+		sp.LogFields(
+			log.String("event", "DownloadRetry"),
+			log.Int64("offset", offset),
+			log.Error(err),
+		)
+		err = nil
+	}
+
+	sp.SetTag("download.size", offset)
+	sp.SetTag("download.ranges", ranges)
+	sp.SetTag("download.retries", retries)
+	if err == nil && opts.Hash != nil {
+		sum := hex.EncodeToString(opts.Hash.Sum(nil))
+		sp.SetTag("download.checksum", sum)
+		if opts.ExpectedChecksum != "" {
+			ok := strings.EqualFold(sum, opts.ExpectedChecksum)
+			sp.SetTag("download.checksum_ok", ok)
+			if !ok {
+				err = ErrChecksumMismatch
+			}
+		}
+	}
+	if err != nil {
+		ext.Error.Set(sp, true)
+		sp.LogFields(log.String("event", "error"), log.Error(err))
+	}
+	return written, err
+}
+
+func downloadRange(ctx context.Context, tr opentracing.Tracer, client *http.Client, req *http.Request, dst io.Writer, offset int64, opts DownloadOptions) (n, total int64, retry bool, err error) {
+	total = -1
+	r := req.WithContext(ctx)
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	byteRange := fmt.Sprintf("bytes=%d-%d", offset, offset+opts.ChunkSize-1)
+	r.Header.Set("Range", byteRange)
+
+	options := append([]ClientOption{OperationName("HTTP Download Range")}, opts.ClientOptions...)
+	r, ht := TraceRequest(tr, r, options...)
+	defer ht.Finish()
+
+	resp, err := client.Do(r)
+	if root := ht.Span(); root != nil {
+		root.SetTag("http.range", byteRange)
+		defer func() {
+			root.SetTag("download.bytes", n)
+			if err != nil {
+				ext.Error.Set(root, true)
+				root.LogFields(log.String("event", "error"), log.Error(err))
+			}
+		}()
+	}
+	if err != nil {
+		return 0, total, true, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		total = parseContentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		if offset != 0 {
+			return 0, total, false, errors.New("nethttp: server does not support range requests")
+		}
+		if resp.ContentLength >= 0 {
+			total = resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// A resource whose size is unknown and a multiple of ChunkSize
+		// ends exactly at offset, which is only known once the range
+		// past its end is refused.
+		if size := parseContentRangeTotal(resp.Header.Get("Content-Range")); offset > 0 && (size < 0 || size == offset) {
+			return 0, offset, false, nil
+		}
+		return 0, total, false, fmt.Errorf("nethttp: range %s not satisfiable", byteRange)
+	default:
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return 0, total, retry, fmt.Errorf("nethttp: unexpected status %d", resp.StatusCode)
+	}
+
+	n, err = io.Copy(dst, resp.Body)
+	if err != nil {
+		return n, total, true, err
+	}
+	if total < 0 && (resp.StatusCode == http.StatusOK || n < opts.ChunkSize) {
+		// The size is unknown, the end of the body is the end of
+		// the resource.
+		total = offset + n
+	}
+	if n == 0 && total > offset {
+		return 0, total, true, io.ErrUnexpectedEOF
+	}
+	return n, total, false, nil
+}
+
+// parseContentRangeTotal returns the complete length from a
+// "bytes start-end/total" header value, or -1 if it is unknown.
+func parseContentRangeTotal(v string) int64 {
+	i := strings.LastIndexByte(v, '/')
+	if i < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(v[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
@@ -0,0 +1,86 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEdgeQueueDuration bounds the time a request may have been queued
+// before reaching the middleware. Edge timestamps further in the past
+// are assumed to be wrong and ignored, as are those in the future.
+const maxEdgeQueueDuration = time.Hour
+
+// edgeStartHeaders are the headers in which load balancers record when
+// they received the request, by order of preference.
+var edgeStartHeaders = []string{"X-Request-Start", "X-Queue-Start"}
+
+type edgeStartOptions struct {
+	startSpan bool
+}
+
+// MWEdgeStartTime returns a MWOption that reads the time at which the
+// edge load balancer received the request, from the X-Request-Start or
+// X-Queue-Start header, and tags the server-side span with the time the
+// request spent queued before reaching the middleware as
+// queue.edge_duration_us, in microseconds. If startSpan is true, the span
+// also starts at the edge time, so that its duration includes the
+// queuing; the other durations recorded by the middleware are still
+// measured from when it received the request.
+//
+// The header holds a Unix timestamp in seconds, milliseconds or
+// microseconds, optionally prefixed by "t=", as set by nginx
+// ("t=${msec}"), HAProxy or Heroku. Timestamps in the future, because
+// of clock skew between the hosts, or more than an hour in the past are
+// ignored.
+func MWEdgeStartTime(startSpan bool) MWOption {
+	return func(options *mwOptions) {
+		options.edgeStart = &edgeStartOptions{startSpan: startSpan}
+	}
+}
+
+// edgeStart returns the time at which the edge received r, and whether
+// r carries a plausible one given that the middleware received it at
+// now.
+func edgeStart(r *http.Request, now time.Time) (time.Time, bool) {
+	for _, name := range edgeStartHeaders {
+		v := r.Header.Get(name)
+		if v == "" {
+			continue
+		}
+		t, ok := parseEdgeStart(v)
+		if !ok {
+			return time.Time{}, false
+		}
+		if d := now.Sub(t); d < 0 || d > maxEdgeQueueDuration {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseEdgeStart parses a timestamp in seconds, milliseconds or
+// microseconds, telling them apart by their magnitude.
+func parseEdgeStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) {
+		return time.Time{}, false
+	}
+	var us float64
+	switch {
+	case f >= 1e15:
+		us = f
+	case f >= 1e12:
+		us = f * 1e3
+	default:
+		us = f * 1e6
+	}
+	return time.Unix(0, int64(us)*int64(time.Microsecond)), true
+}
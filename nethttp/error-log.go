@@ -0,0 +1,173 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"io"
+	stdlog "log"
+	"strings"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// ServerErrorKind classifies the errors net/http reports through
+// http.Server.ErrorLog.
+type ServerErrorKind string
+
+// Kinds of errors recognized by ServerErrorLog.
+const (
+	ServerErrorAccept           ServerErrorKind = "accept"
+	ServerErrorTLSHandshake     ServerErrorKind = "tls_handshake"
+	ServerErrorMalformedRequest ServerErrorKind = "malformed_request"
+	ServerErrorPanic            ServerErrorKind = "panic"
+	ServerErrorOther            ServerErrorKind = "other"
+)
+
+type serverErrorLogOptions struct {
+	tracer opentracing.Tracer
+	output *stdlog.Logger
+	hook   func(kind ServerErrorKind, msg string)
+}
+
+// ServerErrorLogOption controls the behavior of ServerErrorLog.
+type ServerErrorLogOption func(*serverErrorLogOptions)
+
+// ServerErrorLogSpans returns a ServerErrorLogOption that records every
+// error as a synthetic "HTTP Server Error" span created with tr.
+func ServerErrorLogSpans(tr opentracing.Tracer) ServerErrorLogOption {
+	return func(options *serverErrorLogOptions) {
+		options.tracer = tr
+	}
+}
+
+// ServerErrorLogOutput returns a ServerErrorLogOption that forwards the
+// original messages to l. By default they are forwarded to the
+// standard logger.
+func ServerErrorLogOutput(l *stdlog.Logger) ServerErrorLogOption {
+	return func(options *serverErrorLogOptions) {
+		options.output = l
+	}
+}
+
+// ServerErrorLogHook returns a ServerErrorLogOption that calls f for
+// every error, e.g. to increment a metric.
+func ServerErrorLogHook(f func(kind ServerErrorKind, msg string)) ServerErrorLogOption {
+	return func(options *serverErrorLogOptions) {
+		options.hook = f
+	}
+}
+
+// ServerErrorLog counts the errors that net/http reports outside of any
+// handler: accept errors, TLS handshake failures, malformed requests
+// and recovered panics. These never reach Middleware, so they are
+// invisible in traces otherwise.
+//
+// Example:
+//
+//	errLog := nethttp.NewServerErrorLog(nethttp.ServerErrorLogSpans(tracer))
+//	srv := &http.Server{Handler: mw, ErrorLog: errLog.Logger()}
+type ServerErrorLog struct {
+	opts   serverErrorLogOptions
+	logger *stdlog.Logger
+
+	mu     sync.Mutex
+	counts map[ServerErrorKind]int64
+}
+
+// NewServerErrorLog returns a new ServerErrorLog.
+func NewServerErrorLog(options ...ServerErrorLogOption) *ServerErrorLog {
+	l := &ServerErrorLog{
+		counts: make(map[ServerErrorKind]int64),
+	}
+	for _, opt := range options {
+		opt(&l.opts)
+	}
+	l.logger = stdlog.New(l, "", 0)
+	return l
+}
+
+// Logger returns the logger to set as http.Server.ErrorLog.
+func (l *ServerErrorLog) Logger() *stdlog.Logger {
+	return l.logger
+}
+
+// Count returns the number of errors of the given kind seen so far.
+func (l *ServerErrorLog) Count(kind ServerErrorKind) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts[kind]
+}
+
+// Write implements io.Writer. It is called by the logger returned by
+// Logger once per message.
+func (l *ServerErrorLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	kind := classifyServerError(msg)
+
+	l.mu.Lock()
+	l.counts[kind]++
+	l.mu.Unlock()
+
+	if l.opts.hook != nil {
+		l.opts.hook(kind, msg)
+	}
+	if l.opts.tracer != nil {
+		sp := l.opts.tracer.StartSpan("HTTP Server Error")
+		ext.SpanKindRPCServer.Set(sp)
+		ext.Component.Set(sp, defaultComponentName)
+		ext.Error.Set(sp, true)
+		sp.SetTag("server.error.kind", string(kind))
+		if peer := serverErrorPeer(msg); peer != "" {
+			sp.SetTag("peer.address", peer)
+		}
+		sp.LogFields(log.String("event", "error"), log.String("message", msg))
+		sp.Finish()
+	}
+
+	if l.opts.output != nil {
+		l.opts.output.Print(msg)
+	} else {
+		stdlog.Print(msg)
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = (*ServerErrorLog)(nil)
+
+func classifyServerError(msg string) ServerErrorKind {
+	switch {
+	case strings.Contains(msg, "Accept error"):
+		return ServerErrorAccept
+	case strings.Contains(msg, "TLS handshake error"):
+		return ServerErrorTLSHandshake
+	case strings.Contains(msg, "panic serving"):
+		return ServerErrorPanic
+	case strings.Contains(msg, "malformed"),
+		strings.Contains(msg, "invalid"),
+		strings.Contains(msg, "bad request"),
+		strings.Contains(msg, "error reading preface"):
+		return ServerErrorMalformedRequest
+	}
+	return ServerErrorOther
+}
+
+// serverErrorPeer extracts the remote address from messages such as
+// "http: TLS handshake error from 10.0.0.1:1234: EOF".
+func serverErrorPeer(msg string) string {
+	for _, marker := range []string{" from ", "panic serving "} {
+		i := strings.Index(msg, marker)
+		if i < 0 {
+			continue
+		}
+		rest := msg[i+len(marker):]
+		if j := strings.Index(rest, ": "); j >= 0 {
+			rest = rest[:j]
+		}
+		return rest
+	}
+	return ""
+}
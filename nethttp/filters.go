@@ -0,0 +1,87 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SkipPaths returns a span filter, to be given to MWSpanFilter, that
+// does not trace requests whose URL path is one of paths, e.g.
+//
+//	nethttp.MWSpanFilter(nethttp.SkipPaths("/healthz", "/metrics"))
+//
+// Paths are matched exactly.
+func SkipPaths(paths ...string) func(r *http.Request) bool {
+	skipped := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		skipped[p] = true
+	}
+	return func(r *http.Request) bool {
+		return !skipped[r.URL.Path]
+	}
+}
+
+// SkipUserAgentPrefixes returns a span filter that does not trace
+// requests whose User-Agent header starts with one of prefixes, such as
+// "kube-probe" for the probes of Kubernetes.
+func SkipUserAgentPrefixes(prefixes ...string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		ua := r.UserAgent()
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(ua, prefix) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SkipMethods returns a span filter that does not trace requests with
+// one of methods, e.g. "OPTIONS".
+func SkipMethods(methods ...string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		for _, method := range methods {
+			if r.Method == method {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AllFilters returns a span filter that traces requests traced by all
+// of filters, e.g. to skip both health checks and probes:
+//
+//	nethttp.MWSpanFilter(nethttp.AllFilters(
+//		nethttp.SkipPaths("/healthz"),
+//		nethttp.SkipUserAgentPrefixes("kube-probe"),
+//	))
+//
+// The filters are called in order until one returns false.
+func AllFilters(filters ...func(r *http.Request) bool) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		for _, f := range filters {
+			if !f(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyFilter returns a span filter that traces requests traced by at
+// least one of filters. The filters are called in order until one
+// returns true.
+func AnyFilter(filters ...func(r *http.Request) bool) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		for _, f := range filters {
+			if f(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
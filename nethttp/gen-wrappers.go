@@ -0,0 +1,84 @@
+//go:build ignore
+// +build ignore
+
+// This program generates the wrappedResponseWriter methods of
+// statusCodeTracker. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// iface is an optional interface a http.ResponseWriter may implement.
+type iface struct {
+	name string
+	// old is true if the interface is available before Go 1.8.
+	old bool
+}
+
+var ifaces = []iface{
+	{"http.Hijacker", true},
+	{"http.CloseNotifier", true},
+	{"http.Pusher", false},
+	{"http.Flusher", true},
+	{"io.ReaderFrom", true},
+}
+
+func main() {
+	generate("status-code-tracker-wrap.go", "go1.8", ifaces)
+
+	var old []iface
+	for _, i := range ifaces {
+		if i.old {
+			old = append(old, i)
+		}
+	}
+	generate("status-code-tracker-old.go", "go1.7 && !go1.8", old)
+}
+
+func generate(filename, constraint string, ifaces []iface) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gen-wrappers.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "//go:build %s\n", constraint)
+	fmt.Fprintf(&b, "// +build %s\n\n", strings.NewReplacer(" && ", ",", "!", "!").Replace(constraint))
+	fmt.Fprintf(&b, "package nethttp\n\n")
+	fmt.Fprintf(&b, "import (\n\t\"io\"\n\t\"net/http\"\n)\n\n")
+
+	fmt.Fprintf(&b, "// wrappedResponseWriter returns a wrapped version of the original\n")
+	fmt.Fprintf(&b, "// ResponseWriter and only implements the same combination of additional\n")
+	fmt.Fprintf(&b, "// interfaces as the original. Every interface is implemented by the\n")
+	fmt.Fprintf(&b, "// tracker itself, so that calls through them are tracked as well.\n")
+	fmt.Fprintf(&b, "// This implementation is based on https://github.com/felixge/httpsnoop.\n")
+	fmt.Fprintf(&b, "func (w *statusCodeTracker) wrappedResponseWriter() http.ResponseWriter {\n")
+	fmt.Fprintf(&b, "\tvar i int\n")
+	for n, iface := range ifaces {
+		fmt.Fprintf(&b, "\tif _, ok := w.ResponseWriter.(%s); ok {\n\t\ti |= 1 << %d\n\t}\n", iface.name, n)
+	}
+	fmt.Fprintf(&b, "\n\tswitch i {\n")
+	for i := 0; i < 1<<uint(len(ifaces)); i++ {
+		fields := []string{"responseWriter"}
+		values := []string{"w"}
+		for n, iface := range ifaces {
+			if i&(1<<uint(n)) != 0 {
+				fields = append(fields, iface.name)
+				values = append(values, "w")
+			}
+		}
+		fmt.Fprintf(&b, "\tcase %d:\n\t\treturn struct {\n\t\t\t%s\n\t\t}{%s}\n",
+			i, strings.Join(fields, "\n\t\t\t"), strings.Join(values, ", "))
+	}
+	fmt.Fprintf(&b, "\tdefault:\n\t\treturn struct {\n\t\t\tresponseWriter\n\t\t}{w}\n\t}\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
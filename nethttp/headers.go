@@ -0,0 +1,108 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// RedactedValue replaces the values of sensitive headers in span tags.
+const RedactedValue = "[REDACTED]"
+
+// sensitiveHeaders are redacted by DefaultHeaderRedactor.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
+
+// DefaultHeaderRedactor replaces the value of credential-bearing headers
+// (Authorization, Cookie, Set-Cookie, X-Api-Key...) with RedactedValue
+// and returns any other value unchanged.
+func DefaultHeaderRedactor(name, value string) string {
+	if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+		return RedactedValue
+	}
+	return value
+}
+
+// MWCaptureRequestHeaders returns a MWOption that copies the listed
+// request headers into http.request.header.<name> tags of the
+// server-side span, <name> being the lower-cased header name. Values are
+// passed through the header redactor, see MWHeaderRedactor.
+func MWCaptureRequestHeaders(names []string) MWOption {
+	return func(options *mwOptions) {
+		options.requestHeaders = canonicalHeaderNames(names)
+	}
+}
+
+// MWCaptureResponseHeaders returns a MWOption that copies the listed
+// response headers, as they are when the headers are written, into
+// http.response.header.<name> tags of the server-side span. Values are
+// passed through the header redactor, see MWHeaderRedactor.
+func MWCaptureResponseHeaders(names []string) MWOption {
+	return func(options *mwOptions) {
+		options.responseHeaders = canonicalHeaderNames(names)
+	}
+}
+
+// MWHeaderRedactor returns a MWOption that uses given function f to
+// redact the values of captured headers. It is called for every value
+// and returns the value to record. Defaults to DefaultHeaderRedactor.
+func MWHeaderRedactor(f func(name, value string) string) MWOption {
+	return func(options *mwOptions) {
+		options.headerRedactor = f
+	}
+}
+
+// ClientTagRequestHeaders returns a ClientOption that copies the listed
+// headers of outbound requests into http.request.header.<name> tags of
+// the client-side span, like MWCaptureRequestHeaders does on the server
+// side. Only the headers set on the request are seen, not those added by
+// the RoundTripper nor the injected span context. Values are passed
+// through the header redactor, see ClientHeaderRedactor.
+func ClientTagRequestHeaders(names ...string) ClientOption {
+	return func(options *clientOptions) {
+		options.requestHeaders = canonicalHeaderNames(names)
+	}
+}
+
+// ClientHeaderRedactor returns a ClientOption that uses given function f
+// to redact the values of the headers tagged by ClientTagRequestHeaders.
+// Defaults to DefaultHeaderRedactor.
+func ClientHeaderRedactor(f func(name, value string) string) ClientOption {
+	return func(options *clientOptions) {
+		options.headerRedactor = f
+	}
+}
+
+func canonicalHeaderNames(names []string) []string {
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = http.CanonicalHeaderKey(name)
+	}
+	return canonical
+}
+
+// tagHeaders sets a prefix+<name> tag on sp for each of the listed
+// headers present in h.
+func tagHeaders(sp opentracing.Span, prefix string, h http.Header, names []string, redact func(name, value string) string) {
+	for _, name := range names {
+		values := h[name]
+		if len(values) == 0 {
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			redacted[i] = redact(name, v)
+		}
+		sp.SetTag(prefix+strings.ToLower(name), strings.Join(redacted, ","))
+	}
+}
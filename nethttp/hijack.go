@@ -0,0 +1,168 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// MWKeepHijackedSpans returns a MWOption that turns on or off keeping
+// the span of a request whose connection is hijacked, e.g. for a
+// WebSocket, open until the hijacked connection is closed, instead of
+// finishing it when the handler returns.
+//
+// The connection returned by Hijack is then a wrapper of the original
+// one. The span is tagged with http.hijacked.lifetime_ms when the
+// connection is closed. A hijacked connection that is never closed
+// leaks its span, see MWHijackedConnLimits.
+//
+// Regardless of this option, the span of a hijacked request is tagged
+// http.hijacked=true, gets the 101 Switching Protocols status for
+// protocol upgrades and is not tagged as an error for the lack of a
+// status code.
+func MWKeepHijackedSpans(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.keepHijackedSpans = enabled
+	}
+}
+
+// MWHijackedConnLimits returns a MWOption that enforces limits on
+// hijacked connections, e.g. WebSockets and tunnels, to contain
+// upgrade-based resource leaks. A connection without any read or write
+// for idleTimeout, or open for longer than maxLifetime, is closed. A
+// zero duration disables the corresponding limit.
+//
+// The option turns on MWKeepHijackedSpans. When the connection is
+// closed the span is tagged with http.hijacked.lifetime_ms and, if a
+// limit closed it, with http.hijacked.closed_by set to idle_timeout or
+// max_lifetime.
+func MWHijackedConnLimits(idleTimeout, maxLifetime time.Duration) MWOption {
+	return func(options *mwOptions) {
+		options.keepHijackedSpans = true
+		options.hijackIdleTimeout = idleTimeout
+		options.hijackMaxLifetime = maxLifetime
+	}
+}
+
+// isUpgrade reports whether r asks for a protocol upgrade.
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hijackFinisher finishes a span once both the handler has returned and
+// the hijacked connection is closed, then calls afterFinish if not nil.
+type hijackFinisher struct {
+	sp          opentracing.Span
+	afterFinish func()
+	pending     int32
+}
+
+func newHijackFinisher(sp opentracing.Span, afterFinish func()) *hijackFinisher {
+	return &hijackFinisher{sp: sp, afterFinish: afterFinish, pending: 2}
+}
+
+func (f *hijackFinisher) done() {
+	if atomic.AddInt32(&f.pending, -1) == 0 {
+		f.sp.Finish()
+		if f.afterFinish != nil {
+			f.afterFinish()
+		}
+	}
+}
+
+// hijackedConn calls onClose once, when it is first closed, and
+// enforces the limits set by MWHijackedConnLimits.
+type hijackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+
+	sp          opentracing.Span
+	opened      time.Time
+	idleTimeout time.Duration
+	idle        *time.Timer
+	lifetime    *time.Timer
+
+	mu       sync.Mutex
+	closedBy string
+}
+
+func newHijackedConn(conn net.Conn, sp opentracing.Span, onClose func(), idleTimeout, maxLifetime time.Duration) *hijackedConn {
+	c := &hijackedConn{
+		Conn:        conn,
+		onClose:     onClose,
+		sp:          sp,
+		opened:      time.Now(),
+		idleTimeout: idleTimeout,
+	}
+	if idleTimeout > 0 {
+		c.idle = time.AfterFunc(idleTimeout, func() { c.closeBy("idle_timeout") })
+	}
+	if maxLifetime > 0 {
+		c.lifetime = time.AfterFunc(maxLifetime, func() { c.closeBy("max_lifetime") })
+	}
+	return c
+}
+
+func (c *hijackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.active()
+	return n, err
+}
+
+func (c *hijackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.active()
+	return n, err
+}
+
+func (c *hijackedConn) active() {
+	if c.idle != nil {
+		c.idle.Reset(c.idleTimeout)
+	}
+}
+
+func (c *hijackedConn) closeBy(limit string) {
+	c.mu.Lock()
+	if c.closedBy == "" {
+		c.closedBy = limit
+	}
+	c.mu.Unlock()
+	c.Close()
+}
+
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		if c.idle != nil {
+			c.idle.Stop()
+		}
+		if c.lifetime != nil {
+			c.lifetime.Stop()
+		}
+		c.sp.SetTag("http.hijacked.lifetime_ms", int64(time.Since(c.opened)/time.Millisecond))
+		c.mu.Lock()
+		closedBy := c.closedBy
+		c.mu.Unlock()
+		if closedBy != "" {
+			c.sp.SetTag("http.hijacked.closed_by", closedBy)
+		}
+		c.onClose()
+	})
+	return err
+}
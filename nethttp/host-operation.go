@@ -0,0 +1,66 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MWOperationPerHost returns a MWOption that prefixes the operation name
+// of the server-side span with the name given in names to the host of
+// the request, e.g. "shop HTTP GET" for a request to shop.example.com
+// with names:
+//
+//	map[string]string{
+//		"shop.example.com":    "shop",
+//		"*.admin.example.com": "admin",
+//		"*":                   "other",
+//	}
+//
+// Hosts are matched without their port and case-insensitively. A key
+// "*.example.com" matches every subdomain of example.com, but not
+// example.com itself, and "*" matches every host. An exact key wins over
+// a wildcard one, and a longer wildcard over a shorter one. The name is
+// left unchanged if no key matches the host, or if the name of the host
+// is empty.
+//
+// MWOperationPerHost panics if a key has a wildcard elsewhere than as
+// its first label.
+func MWOperationPerHost(names map[string]string) MWOption {
+	hosts := make(map[string]string, len(names))
+	for host, name := range names {
+		key := strings.TrimSuffix(strings.ToLower(host), ".")
+		if strings.Contains(strings.TrimPrefix(key, "*."), "*") && key != "*" {
+			panic("nethttp: invalid host " + strconv.Quote(host))
+		}
+		hosts[key] = name
+	}
+	return MWOperationNameDecorator(func(name string, r *http.Request) string {
+		if prefix := hostName(hosts, r.Host); prefix != "" {
+			return prefix + " " + name
+		}
+		return name
+	})
+}
+
+// hostName returns the name in hosts of host, which may have a port.
+func hostName(hosts map[string]string, host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if name, ok := hosts[host]; ok {
+		return name
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if name, ok := hosts["*."+host]; ok {
+			return name
+		}
+	}
+	return hosts["*"]
+}
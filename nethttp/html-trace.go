@@ -0,0 +1,174 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// maxHTMLScanBytes bounds the beginning of HTML responses buffered while
+// looking for the head element. Responses whose head element starts
+// later are sent unchanged.
+const maxHTMLScanBytes = 8 << 10
+
+type htmlTraceMeta struct {
+	name    string
+	traceID func(sp opentracing.Span) string
+}
+
+// MWHTMLTraceMeta returns a MWOption that injects a
+// <meta name="{name}" content="{trace ID}"> element right after the
+// opening tag of the head element of HTML responses, so that the real
+// user monitoring scripts of the page can reference the trace of the
+// backend request. If f is nil, the trace ID is read from the span
+// context as MWResponseTraceHeader does. Nothing is injected if the
+// identifier is empty.
+//
+// Only responses with a text/html content type, set by the handler or
+// sniffed from the body, and no Content-Encoding are modified, and their
+// Content-Length header is removed. The response is buffered until the
+// head element is found, at most 8KB, and sent unchanged if it is not,
+// or if the handler flushes it before.
+//
+// Example:
+//
+//	nethttp.MWHTMLTraceMeta("traceparent", func(sp opentracing.Span) string {
+//		return w3cTraceParent(sp.Context())
+//	})
+func MWHTMLTraceMeta(name string, f func(sp opentracing.Span) string) MWOption {
+	if f == nil {
+		f = func(sp opentracing.Span) string {
+			return traceIDOf(sp.Context())
+		}
+	}
+	return func(options *mwOptions) {
+		options.htmlTraceMeta = &htmlTraceMeta{name: name, traceID: f}
+	}
+}
+
+// hook returns the header hook setting up the injection of the meta
+// element into the response written to w by sct.
+func (m *htmlTraceMeta) hook(sp opentracing.Span, w http.ResponseWriter, sct *statusCodeTracker) func(int) {
+	return func(status int) {
+		if !bodyAllowed(status) {
+			return
+		}
+		h := w.Header()
+		if h.Get("Content-Encoding") != "" {
+			return
+		}
+		contentType := h.Get("Content-Type")
+		if contentType != "" && !isHTML(contentType) {
+			return
+		}
+		id := m.traceID(sp)
+		if id == "" {
+			return
+		}
+		h.Del("Content-Length")
+		sct.html = &htmlInjector{
+			meta:  []byte(`<meta name="` + html.EscapeString(m.name) + `" content="` + html.EscapeString(id) + `">`),
+			sniff: contentType == "",
+		}
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/html"
+}
+
+// htmlInjector buffers the beginning of an HTML response until the head
+// element is found, and injects meta after its opening tag.
+type htmlInjector struct {
+	meta []byte
+	// sniff is true if the content type is detected from the body.
+	sniff bool
+	buf   []byte
+	done  bool
+}
+
+// write writes b to w once the meta element is injected, or buffers it
+// until then. It returns len(b) if b is buffered.
+func (in *htmlInjector) write(w io.Writer, b []byte) (int, error) {
+	if in.done {
+		return w.Write(b)
+	}
+	if in.sniff && len(in.buf) == 0 {
+		in.sniff = false
+		if !isHTML(http.DetectContentType(b)) {
+			in.done = true
+			return w.Write(b)
+		}
+	}
+	in.buf = append(in.buf, b...)
+	if i := headEnd(in.buf); i >= 0 {
+		in.done = true
+		out := make([]byte, 0, len(in.buf)+len(in.meta))
+		out = append(append(append(out, in.buf[:i]...), in.meta...), in.buf[i:]...)
+		in.buf = nil
+		if _, err := w.Write(out); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if len(in.buf) >= maxHTMLScanBytes {
+		return len(b), in.flush(w)
+	}
+	return len(b), nil
+}
+
+// flush writes the buffered response unchanged and stops looking for the
+// head element.
+func (in *htmlInjector) flush(w io.Writer) error {
+	if in.done {
+		return nil
+	}
+	in.done = true
+	buf := in.buf
+	in.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+var headTag = []byte("<head")
+
+// headEnd returns the index right after the opening tag of the head
+// element in b, or -1 if b does not contain all of it.
+func headEnd(b []byte) int {
+	lower := bytes.ToLower(b)
+	for offset := 0; ; {
+		i := bytes.Index(lower[offset:], headTag)
+		if i < 0 {
+			return -1
+		}
+		i += offset + len(headTag)
+		if i == len(b) {
+			return -1
+		}
+		switch b[i] {
+		case '>':
+			return i + 1
+		case ' ', '\t', '\n', '\r', '\f', '/':
+			if j := bytes.IndexByte(b[i:], '>'); j >= 0 {
+				return i + j + 1
+			}
+			return -1
+		}
+		offset = i
+	}
+}
@@ -0,0 +1,66 @@
+//go:build go1.19
+// +build go1.19
+
+package nethttp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestInformationalResponses(t *testing.T) {
+	tr := mocktracer.New()
+	var hooked []int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("OK"))
+	})
+	srv := httptest.NewServer(Middleware(tr, h, MWSpanOnWriteHeader(func(sp opentracing.Span, r *http.Request, status int, _ time.Time) {
+		hooked = append(hooked, status)
+	})))
+	defer srv.Close()
+
+	var interim []int
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			interim = append(interim, code)
+			return nil
+		},
+	}))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || len(interim) != 1 || interim[0] != http.StatusEarlyHints {
+		t.Fatalf("got status %d after %v, expected 201 after [103]", resp.StatusCode, interim)
+	}
+	if len(hooked) != 1 || hooked[0] != http.StatusCreated {
+		t.Fatalf("got header hooks called with %v, expected [201]", hooked)
+	}
+	sp := tr.FinishedSpans()[0]
+	if got := sp.Tag("http.status_code"); got != uint16(http.StatusCreated) {
+		t.Fatalf("got status %v, expected 201", got)
+	}
+	if len(sp.Logs()) != 1 {
+		t.Fatalf("got %d logs, expected 1", len(sp.Logs()))
+	}
+	fields := sp.Logs()[0].Fields
+	if fields[0].ValueString != "InformationalResponse" || fields[1].ValueString != "103" {
+		t.Fatalf("got log %v", fields)
+	}
+}
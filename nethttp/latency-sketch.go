@@ -0,0 +1,358 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sketchSubBits is the number of bits of precision kept by the
+	// latency sketches: every power of two is split in 1<<sketchSubBits
+	// buckets, which bounds the relative error to about 3%.
+	sketchSubBits = 4
+	sketchSub     = 1 << sketchSubBits
+	// sketchMaxExp is the exponent of the largest latency recorded, in
+	// microseconds, about 12 days. Longer latencies are clamped.
+	sketchMaxExp  = 39
+	sketchBuckets = (sketchMaxExp - sketchSubBits + 2) * sketchSub
+	// sizeBuckets is the number of buckets of the response size
+	// histograms: one for empty responses and one for every power of two.
+	sizeBuckets = 64
+	// defaultMaxSketchOperations bounds the number of operations of
+	// LatencySketches to about ten megabytes.
+	defaultMaxSketchOperations = 1000
+)
+
+// LatencySketches keeps an approximate distribution of the latencies and
+// of the response sizes of every operation served by the middleware, so
+// that quantiles can be queried by the process itself, without a tracing
+// backend.
+//
+// Latencies are recorded in log-linear buckets, HDR histogram style:
+// each operation takes about ten kilobytes whatever the number of
+// requests, and quantiles are accurate to a few percent. Response sizes
+// are recorded in exponential buckets, one per power of two, which is
+// enough to notice payloads growing.
+type LatencySketches struct {
+	window        time.Duration
+	maxOperations int
+	now           func() time.Time
+
+	// mu guards operations; every sketch has its own lock, so that
+	// requests of different operations do not contend.
+	mu         sync.RWMutex
+	operations map[string]*latencySketch
+}
+
+// NewLatencySketches returns LatencySketches keeping the latencies of up
+// to maxOperations operations; requests of further operations are not
+// recorded. Zero or less means 1000, about ten megabytes, as operation
+// names derived from paths may otherwise grow memory without bound.
+//
+// If window is not zero, quantiles are computed over the latencies of
+// the last one to two windows: older latencies are dropped as time
+// passes. Otherwise they are computed over every request since the
+// sketches were created or reset.
+func NewLatencySketches(window time.Duration, maxOperations int) *LatencySketches {
+	if maxOperations <= 0 {
+		maxOperations = defaultMaxSketchOperations
+	}
+	return &LatencySketches{
+		window:        window,
+		maxOperations: maxOperations,
+		now:           time.Now,
+		operations:    make(map[string]*latencySketch),
+	}
+}
+
+// MWLatencySketches returns a MWOption that records the latency and the
+// response size of every request in s, under the operation name of its
+// span. Requests that are not traced are recorded as well.
+func MWLatencySketches(s *LatencySketches) MWOption {
+	return func(options *mwOptions) {
+		options.latencies = s
+	}
+}
+
+// Quantile returns the q-quantile (between 0 and 1) of the latencies of
+// operation, e.g. 0.99 for the 99th percentile. It returns false if no
+// latency of operation was recorded.
+func (s *LatencySketches) Quantile(operation string, q float64) (time.Duration, bool) {
+	sk := s.lookup(operation)
+	if sk == nil {
+		return 0, false
+	}
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	sk.rotate(s.now(), s.window)
+	return sk.quantile(q)
+}
+
+// Count returns the number of latencies of operation the quantiles are
+// computed over.
+func (s *LatencySketches) Count(operation string) int64 {
+	sk := s.lookup(operation)
+	if sk == nil {
+		return 0
+	}
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	sk.rotate(s.now(), s.window)
+	return sk.current.count + sk.previous.count
+}
+
+// SizeBucket is a bucket of a response size histogram.
+type SizeBucket struct {
+	// UpperBound is the largest size, in bytes, counted in the bucket.
+	UpperBound int64
+	// Count is the number of responses in the bucket.
+	Count int64
+}
+
+// SizeQuantile returns an upper bound of the q-quantile of the response
+// sizes of operation, in bytes: the upper bound of its bucket, at most
+// twice the quantile, or the largest size recorded if lower. It returns
+// false if no response of operation was recorded.
+func (s *LatencySketches) SizeQuantile(operation string, q float64) (int64, bool) {
+	sk := s.lookup(operation)
+	if sk == nil {
+		return 0, false
+	}
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	sk.rotate(s.now(), s.window)
+	return sk.sizeQuantile(q)
+}
+
+// SizeBuckets returns the non-empty buckets of the response sizes of
+// operation, by increasing upper bound, e.g. to export them as a
+// histogram metric.
+func (s *LatencySketches) SizeBuckets(operation string) []SizeBucket {
+	sk := s.lookup(operation)
+	if sk == nil {
+		return nil
+	}
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	sk.rotate(s.now(), s.window)
+	var buckets []SizeBucket
+	for i := range sk.currentSizes.buckets {
+		if n := sk.currentSizes.buckets[i] + sk.previousSizes.buckets[i]; n > 0 {
+			buckets = append(buckets, SizeBucket{UpperBound: sizeUpperBound(i), Count: n})
+		}
+	}
+	return buckets
+}
+
+// Operations returns the sorted names of the operations recorded.
+func (s *LatencySketches) Operations() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.operations))
+	for name := range s.operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reset drops every recorded latency.
+func (s *LatencySketches) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.operations = make(map[string]*latencySketch)
+}
+
+// lookup returns the sketch of operation, or nil if there is none.
+func (s *LatencySketches) lookup(operation string) *latencySketch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.operations[operation]
+}
+
+func (s *LatencySketches) observe(operation string, d time.Duration, size int64) {
+	now := s.now()
+	sk := s.lookup(operation)
+	if sk == nil {
+		s.mu.Lock()
+		if sk = s.operations[operation]; sk == nil {
+			if len(s.operations) >= s.maxOperations {
+				s.mu.Unlock()
+				return
+			}
+			sk = &latencySketch{start: now}
+			s.operations[operation] = sk
+		}
+		s.mu.Unlock()
+	}
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	sk.rotate(now, s.window)
+	sk.current.add(d)
+	sk.currentSizes.add(size)
+}
+
+// latencySketch is the sketch of an operation. With a window, latencies
+// are recorded in current, and previous holds those of the window
+// before, and likewise for response sizes.
+type latencySketch struct {
+	mu            sync.Mutex
+	start         time.Time
+	current       histogram
+	previous      histogram
+	currentSizes  sizeHistogram
+	previousSizes sizeHistogram
+}
+
+func (sk *latencySketch) rotate(now time.Time, window time.Duration) {
+	if window <= 0 || now.Sub(sk.start) < window {
+		return
+	}
+	if now.Sub(sk.start) < 2*window {
+		sk.previous = sk.current
+		sk.previousSizes = sk.currentSizes
+	} else {
+		sk.previous = histogram{}
+		sk.previousSizes = sizeHistogram{}
+	}
+	sk.current = histogram{}
+	sk.currentSizes = sizeHistogram{}
+	sk.start = now
+}
+
+func (sk *latencySketch) quantile(q float64) (time.Duration, bool) {
+	n := sk.current.count + sk.previous.count
+	if n == 0 {
+		return 0, false
+	}
+	rank := int64(q*float64(n) + 0.5)
+	if rank < 1 {
+		rank = 1
+	} else if rank > n {
+		rank = n
+	}
+	max := sk.current.max
+	if sk.previous.max > max {
+		max = sk.previous.max
+	}
+	var seen int64
+	for i := range sk.current.buckets {
+		seen += sk.current.buckets[i] + sk.previous.buckets[i]
+		if seen >= rank {
+			v := bucketValue(i)
+			if v > max {
+				v = max
+			}
+			return time.Duration(v) * time.Microsecond, true
+		}
+	}
+	return time.Duration(max) * time.Microsecond, true
+}
+
+func (sk *latencySketch) sizeQuantile(q float64) (int64, bool) {
+	n := sk.currentSizes.count + sk.previousSizes.count
+	if n == 0 {
+		return 0, false
+	}
+	rank := int64(q*float64(n) + 0.5)
+	if rank < 1 {
+		rank = 1
+	} else if rank > n {
+		rank = n
+	}
+	max := sk.currentSizes.max
+	if sk.previousSizes.max > max {
+		max = sk.previousSizes.max
+	}
+	var seen int64
+	for i := range sk.currentSizes.buckets {
+		seen += sk.currentSizes.buckets[i] + sk.previousSizes.buckets[i]
+		if seen >= rank {
+			if v := sizeUpperBound(i); v < max {
+				return v, true
+			}
+			break
+		}
+	}
+	return max, true
+}
+
+type histogram struct {
+	buckets [sketchBuckets]int64
+	count   int64
+	max     uint64
+}
+
+func (h *histogram) add(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d / time.Microsecond)
+	}
+	if v >= 1<<(sketchMaxExp+1) {
+		v = 1<<(sketchMaxExp+1) - 1
+	}
+	h.buckets[bucketIndex(v)]++
+	h.count++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// bucketIndex returns the index of the bucket of v: values below
+// sketchSub have a bucket each, and every larger power of two is split
+// in sketchSub buckets.
+func bucketIndex(v uint64) int {
+	if v < sketchSub {
+		return int(v)
+	}
+	exp := 0
+	for x := v; x > 1; x >>= 1 {
+		exp++
+	}
+	shift := uint(exp - sketchSubBits)
+	return (exp-sketchSubBits+1)*sketchSub + int(v>>shift) - sketchSub
+}
+
+// bucketValue returns the middle of the values of bucket i.
+func bucketValue(i int) uint64 {
+	if i < sketchSub {
+		return uint64(i)
+	}
+	exp := i/sketchSub + sketchSubBits - 1
+	shift := uint(exp - sketchSubBits)
+	lower := uint64(i%sketchSub+sketchSub) << shift
+	return lower + (uint64(1)<<shift)/2
+}
+
+// sizeHistogram counts response sizes in exponential buckets: bucket 0
+// holds empty responses and bucket i the sizes from 1<<(i-1) to
+// 1<<i - 1 bytes.
+type sizeHistogram struct {
+	buckets [sizeBuckets]int64
+	count   int64
+	max     int64
+}
+
+func (h *sizeHistogram) add(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	i := 0
+	for x := size; x > 0; x >>= 1 {
+		i++
+	}
+	h.buckets[i]++
+	h.count++
+	if size > h.max {
+		h.max = size
+	}
+}
+
+// sizeUpperBound returns the largest size counted in bucket i.
+func sizeUpperBound(i int) int64 {
+	return 1<<uint(i) - 1
+}
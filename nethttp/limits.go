@@ -0,0 +1,158 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// truncatedSuffix marks truncated tag values.
+const truncatedSuffix = "..."
+
+// builtinTags are not counted against the custom tag limit.
+var builtinTags = map[string]bool{
+	"component":          true,
+	"span.kind":          true,
+	"error":              true,
+	"http.method":        true,
+	"http.url":           true,
+	"http.status_code":   true,
+	"http.request_size":  true,
+	"http.response_size": true,
+}
+
+type tagLimits struct {
+	maxValueLength int
+	maxCustomTags  int
+}
+
+// MWTagLimits returns a MWOption that protects the tracing backend from
+// unbounded tag values and cardinality: string tag values longer than
+// maxValueLength bytes are truncated on a character boundary, and tags
+// beyond maxCustomTags distinct keys, not counting the default tags,
+// are dropped. The number of dropped tags is recorded in the
+// tags.dropped tag. A zero limit disables the corresponding check.
+//
+// The span given to observers, hooks and handlers is then a wrapper of
+// the span created by the tracer.
+func MWTagLimits(maxValueLength, maxCustomTags int) MWOption {
+	return func(options *mwOptions) {
+		options.tagLimits = &tagLimits{maxValueLength: maxValueLength, maxCustomTags: maxCustomTags}
+	}
+}
+
+// ClientTagLimits returns a ClientOption that applies the limits of
+// MWTagLimits to the client-side spans.
+func ClientTagLimits(maxValueLength, maxCustomTags int) ClientOption {
+	return func(options *clientOptions) {
+		options.tagLimits = &tagLimits{maxValueLength: maxValueLength, maxCustomTags: maxCustomTags}
+	}
+}
+
+// MWPathNormalizer returns a MWOption that passes the URL path through
+// f before it is recorded in the http.url tag, e.g. CollapseIDs to keep
+// the number of distinct values low.
+func MWPathNormalizer(f func(path string) string) MWOption {
+	return func(options *mwOptions) {
+		options.pathNormalizer = f
+	}
+}
+
+// ClientPathNormalizer returns a ClientOption that passes the URL path
+// through f before it is recorded in the http.url tag of client-side
+// spans.
+func ClientPathNormalizer(f func(path string) string) ClientOption {
+	return func(options *clientOptions) {
+		options.pathNormalizer = f
+	}
+}
+
+// CollapseIDs replaces the path segments looking like identifiers,
+// i.e. numbers, UUIDs and long hexadecimal strings, with "{id}", so
+// that "/users/42/orders/9b2b..." becomes "/users/{id}/orders/{id}".
+func CollapseIDs(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if isID(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	digits, hex := true, len(s) >= 16
+	for _, c := range s {
+		isDigit := c >= '0' && c <= '9'
+		digits = digits && isDigit
+		hex = hex && (isDigit || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' || c == '-')
+	}
+	return digits || hex
+}
+
+// normalizeURL returns u with its path passed through f.
+func normalizeURL(u *url.URL, f func(path string) string) *url.URL {
+	normalized := *u
+	normalized.Path = f(u.Path)
+	normalized.RawPath = ""
+	return &normalized
+}
+
+// limitSpan enforces tag limits.
+type limitSpan struct {
+	opentracing.Span
+	limits *tagLimits
+
+	mu      sync.Mutex
+	keys    map[string]bool
+	dropped int
+}
+
+func newLimitSpan(sp opentracing.Span, limits *tagLimits) *limitSpan {
+	return &limitSpan{Span: sp, limits: limits, keys: make(map[string]bool)}
+}
+
+func (s *limitSpan) SetTag(key string, value interface{}) opentracing.Span {
+	if max := s.limits.maxCustomTags; max > 0 && !builtinTags[key] {
+		s.mu.Lock()
+		if !s.keys[key] && len(s.keys) >= max {
+			s.dropped++
+			s.mu.Unlock()
+			return s
+		}
+		s.keys[key] = true
+		s.mu.Unlock()
+	}
+	if str, ok := value.(string); ok && s.limits.maxValueLength > 0 && len(str) > s.limits.maxValueLength {
+		n := s.limits.maxValueLength
+		for n > 0 && !utf8.RuneStart(str[n]) {
+			n--
+		}
+		value = str[:n] + truncatedSuffix
+	}
+	s.Span.SetTag(key, value)
+	return s
+}
+
+func (s *limitSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *limitSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	if dropped > 0 {
+		s.Span.SetTag("tags.dropped", dropped)
+	}
+	s.Span.FinishWithOptions(opts)
+}
@@ -0,0 +1,95 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// MetricsLabels identifies the requests a metric is recorded for.
+type MetricsLabels struct {
+	// Kind is "server" for requests served by Middleware and "client"
+	// for requests sent by Transport.
+	Kind string
+	// Operation is the operation name of the request's span.
+	Operation string
+	// Method is the HTTP method of the request.
+	Method string
+}
+
+// MetricsRecorder records RED metrics for every request, so that
+// metrics and spans come from one instrumentation point. Its shape maps
+// directly to Prometheus histograms and counters labeled with the
+// fields of MetricsLabels. Implementations must be safe for concurrent
+// use.
+type MetricsRecorder interface {
+	// ObserveLatency records the duration of a request: until the
+	// handler returns on the server side, until the response headers
+	// are received on the client side.
+	ObserveLatency(l MetricsLabels, d time.Duration)
+	// IncStatus counts a request by status code. The status is 0 when
+	// no response was written or received.
+	IncStatus(l MetricsLabels, status int)
+	// ObserveSize records the number of body bytes of a request and its
+	// response.
+	ObserveSize(l MetricsLabels, requestBytes, responseBytes int64)
+}
+
+// MWMetricsRecorder returns a MWOption that records the metrics of
+// every request with m, including the requests filtered out or not
+// sampled, which have no span.
+func MWMetricsRecorder(m MetricsRecorder) MWOption {
+	return func(options *mwOptions) {
+		options.metrics = m
+	}
+}
+
+// ClientMetricsRecorder returns a ClientOption that records the metrics
+// of every request sent by Transport with m. The response size is
+// recorded when the response body is closed.
+func ClientMetricsRecorder(m MetricsRecorder) ClientOption {
+	return func(options *clientOptions) {
+		options.metrics = m
+	}
+}
+
+func recordServerMetrics(m MetricsRecorder, r *http.Request, opName string, sct *statusCodeTracker, requestBytes int64, d time.Duration) {
+	l := MetricsLabels{Kind: "server", Operation: opName, Method: r.Method}
+	m.ObserveLatency(l, d)
+	m.IncStatus(l, sct.status)
+	m.ObserveSize(l, requestBytes, sct.size)
+}
+
+// requestBodySize returns the size of the body of r as announced by
+// its Content-Length, or 0 if unknown.
+func requestBodySize(r *http.Request) int64 {
+	if r.ContentLength > 0 {
+		return r.ContentLength
+	}
+	return 0
+}
+
+// metricsBody records the response size of a client request once its
+// body is closed.
+type metricsBody struct {
+	io.ReadCloser
+	m            MetricsRecorder
+	l            MetricsLabels
+	requestBytes int64
+	n            int64
+}
+
+func (b *metricsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *metricsBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.m.ObserveSize(b.l, b.requestBytes, b.n)
+	return err
+}
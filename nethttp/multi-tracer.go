@@ -0,0 +1,236 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// MultiTracer returns a tracer duplicating every span to all of
+// tracers, e.g. to report to both the current and the new backend
+// during a migration. It can be given to Middleware, Transport and any
+// other OpenTracing instrumentation.
+//
+// Backends are isolated from each other: a span context is injected and
+// extracted with every tracer, and only fails to be if it fails with all
+// of them, so that a backend unable to continue a trace starts a new one
+// without breaking the trace of the others. Tracers injecting the same
+// headers overwrite each other, the last one winning.
+//
+// The trace and span IDs of the spans, as returned by
+// TraceIDFromContext, are those of the first tracer exposing them.
+func MultiTracer(tracers ...opentracing.Tracer) opentracing.Tracer {
+	return &multiTracer{tracers: append([]opentracing.Tracer(nil), tracers...)}
+}
+
+type multiTracer struct {
+	tracers []opentracing.Tracer
+}
+
+// StartSpan implements opentracing.Tracer.
+func (t *multiTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	sso := opentracing.StartSpanOptions{}
+	for _, o := range opts {
+		o.Apply(&sso)
+	}
+	s := &multiSpan{t: t, spans: make([]opentracing.Span, len(t.tracers))}
+	for i, tr := range t.tracers {
+		options := make([]opentracing.StartSpanOption, 0, len(sso.References)+2)
+		for _, ref := range sso.References {
+			sc := ref.ReferencedContext
+			if msc, ok := sc.(*multiSpanContext); ok {
+				sc = msc.contexts[i]
+			}
+			if sc != nil {
+				options = append(options, opentracing.SpanReference{Type: ref.Type, ReferencedContext: sc})
+			}
+		}
+		if !sso.StartTime.IsZero() {
+			options = append(options, opentracing.StartTime(sso.StartTime))
+		}
+		if len(sso.Tags) > 0 {
+			options = append(options, opentracing.Tags(sso.Tags))
+		}
+		s.spans[i] = tr.StartSpan(operationName, options...)
+	}
+	return s
+}
+
+// Inject implements opentracing.Tracer.
+func (t *multiTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	var err error
+	injected := false
+	for i, tr := range t.tracers {
+		c := sc
+		if msc, ok := sc.(*multiSpanContext); ok {
+			if c = msc.contexts[i]; c == nil {
+				continue
+			}
+		}
+		if e := tr.Inject(c, format, carrier); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		injected = true
+	}
+	if injected || err == nil {
+		return nil
+	}
+	return err
+}
+
+// Extract implements opentracing.Tracer.
+func (t *multiTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	msc := &multiSpanContext{contexts: make([]opentracing.SpanContext, len(t.tracers))}
+	err := opentracing.ErrSpanContextNotFound
+	extracted := false
+	for i, tr := range t.tracers {
+		sc, e := tr.Extract(format, carrier)
+		if e != nil || sc == nil {
+			if e != nil && e != opentracing.ErrSpanContextNotFound {
+				err = e
+			}
+			continue
+		}
+		msc.contexts[i] = sc
+		extracted = true
+	}
+	if !extracted {
+		return nil, err
+	}
+	return msc, nil
+}
+
+// multiSpanContext holds the span contexts of a multiSpan, indexed as
+// the tracers of the multiTracer. Contexts are nil for the tracers which
+// could not extract them.
+type multiSpanContext struct {
+	contexts []opentracing.SpanContext
+}
+
+// ForeachBaggageItem implements opentracing.SpanContext, iterating over
+// the baggage of the first context, since all of them carry the same.
+func (c *multiSpanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for _, sc := range c.contexts {
+		if sc != nil {
+			sc.ForeachBaggageItem(handler)
+			return
+		}
+	}
+}
+
+// TraceID returns the trace ID of the first context exposing it, see
+// traceIDOf.
+func (c *multiSpanContext) TraceID() string {
+	return c.firstID("TraceID")
+}
+
+// SpanID returns the span ID of the first context exposing it.
+func (c *multiSpanContext) SpanID() string {
+	return c.firstID("SpanID")
+}
+
+func (c *multiSpanContext) firstID(name string) string {
+	for _, sc := range c.contexts {
+		if id := idOf(sc, name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// multiSpan duplicates every call to the spans it holds.
+type multiSpan struct {
+	t     *multiTracer
+	spans []opentracing.Span
+}
+
+func (s *multiSpan) Finish() {
+	for _, sp := range s.spans {
+		sp.Finish()
+	}
+}
+
+func (s *multiSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	for _, sp := range s.spans {
+		sp.FinishWithOptions(opts)
+	}
+}
+
+func (s *multiSpan) Context() opentracing.SpanContext {
+	msc := &multiSpanContext{contexts: make([]opentracing.SpanContext, len(s.spans))}
+	for i, sp := range s.spans {
+		msc.contexts[i] = sp.Context()
+	}
+	return msc
+}
+
+func (s *multiSpan) SetOperationName(operationName string) opentracing.Span {
+	for _, sp := range s.spans {
+		sp.SetOperationName(operationName)
+	}
+	return s
+}
+
+func (s *multiSpan) SetTag(key string, value interface{}) opentracing.Span {
+	for _, sp := range s.spans {
+		sp.SetTag(key, value)
+	}
+	return s
+}
+
+func (s *multiSpan) LogFields(fields ...log.Field) {
+	for _, sp := range s.spans {
+		sp.LogFields(fields...)
+	}
+}
+
+func (s *multiSpan) LogKV(alternatingKeyValues ...interface{}) {
+	for _, sp := range s.spans {
+		sp.LogKV(alternatingKeyValues...)
+	}
+}
+
+func (s *multiSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	for _, sp := range s.spans {
+		sp.SetBaggageItem(restrictedKey, value)
+	}
+	return s
+}
+
+func (s *multiSpan) BaggageItem(restrictedKey string) string {
+	for _, sp := range s.spans {
+		if v := sp.BaggageItem(restrictedKey); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Tracer returns the multiTracer, so that the spans started from this
+// one are duplicated as well.
+func (s *multiSpan) Tracer() opentracing.Tracer {
+	return s.t
+}
+
+func (s *multiSpan) LogEvent(event string) {
+	for _, sp := range s.spans {
+		sp.LogEvent(event)
+	}
+}
+
+func (s *multiSpan) LogEventWithPayload(event string, payload interface{}) {
+	for _, sp := range s.spans {
+		sp.LogEventWithPayload(event, payload)
+	}
+}
+
+func (s *multiSpan) Log(data opentracing.LogData) {
+	for _, sp := range s.spans {
+		sp.Log(data)
+	}
+}
@@ -0,0 +1,76 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Render modes of PageInfo.
+const (
+	// RenderModeSSR is a page rendered by the server for the request.
+	RenderModeSSR = "ssr"
+	// RenderModeCache is a page served from a cache of rendered pages.
+	RenderModeCache = "cache"
+	// RenderModeCSR is a shell rendered by the browser.
+	RenderModeCSR = "csr"
+)
+
+// PageInfo describes the page rendered by an HTML handler.
+type PageInfo struct {
+	// Name identifies the page, e.g. "product-detail", independently of
+	// the parameters of its URL.
+	Name string
+	// RenderMode is how the page was rendered, e.g. RenderModeSSR.
+	RenderMode string
+	// Fragments is the number of fragments, such as partial templates
+	// or components, the page is made of, and CachedFragments the
+	// number of them served from a cache.
+	Fragments       int
+	CachedFragments int
+}
+
+// AnnotatePage tags the server-side span of the request being served with
+// ctx with the metadata of the page rendered: page.name,
+// page.render_mode, page.fragments and page.cached_fragments, so that
+// pages are traced the same way by every service. Empty fields are not
+// tagged. It does nothing if the request is not traced.
+//
+// Example:
+//
+//	nethttp.AnnotatePage(r.Context(), nethttp.PageInfo{
+//		Name:       "product-detail",
+//		RenderMode: nethttp.RenderModeSSR,
+//		Fragments:  len(components),
+//	})
+func AnnotatePage(ctx context.Context, page PageInfo) {
+	sp := serverSpanFromContext(ctx)
+	if sp == nil {
+		return
+	}
+	if page.Name != "" {
+		sp.SetTag("page.name", page.Name)
+	}
+	if page.RenderMode != "" {
+		sp.SetTag("page.render_mode", page.RenderMode)
+	}
+	if page.Fragments > 0 {
+		sp.SetTag("page.fragments", page.Fragments)
+	}
+	if page.CachedFragments > 0 {
+		sp.SetTag("page.cached_fragments", page.CachedFragments)
+	}
+}
+
+// serverSpanFromContext returns the server-side span of the request being
+// served with ctx: the span in ctx, unless it is the child span of
+// MWHandlerSpan.
+func serverSpanFromContext(ctx context.Context) opentracing.Span {
+	if sp, ok := ctx.Value(keyServerSpan).(opentracing.Span); ok {
+		return sp
+	}
+	return opentracing.SpanFromContext(ctx)
+}
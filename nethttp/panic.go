@@ -0,0 +1,73 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// PanicAction tells the Middleware what to do once a handler panic has
+// been recorded.
+type PanicAction int
+
+const (
+	// PanicRepanic panics again with the recovered value once the span
+	// is finished, leaving the panic to net/http or an outer handler.
+	PanicRepanic PanicAction = iota
+	// PanicRespond500 swallows the panic and responds with 500 Internal
+	// Server Error if the handler has not written the headers yet.
+	PanicRespond500
+)
+
+// MWPanicHandler returns a MWOption that recovers panics of the handler.
+// The span is tagged panic=true and error=true and gets an error log
+// carrying the recovered value (error.object), its type name
+// (error.kind), its message and the stack trace. The message is the
+// result of Error for values implementing error and of fmt.Sprint
+// otherwise. f is then called with the span, the request, the recovered
+// value as it was passed to panic and the stack, so that it can switch
+// on the type of v to choose how the panic is dealt with.
+//
+// Without this option panics are not recovered: the span is finished
+// with the error tag and the panic goes on unwinding.
+//
+// Panics with http.ErrAbortHandler, with which handlers such as
+// httputil.ReverseProxy deliberately abort a response, are not handler
+// crashes: f is not called for them, and the span is tagged
+// http.aborted=true instead of panic=true before the panic goes on
+// unwinding, so that net/http aborts the response as usual.
+func MWPanicHandler(f func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction) MWOption {
+	return func(options *mwOptions) {
+		options.panicHandler = f
+	}
+}
+
+// handlePanic records the panic v on sp and returns the action chosen
+// by the panic handler. The error tag is only set if autoError is true.
+func handlePanic(sp opentracing.Span, sct *statusCodeTracker, r *http.Request, v interface{}, f func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction, autoError bool) PanicAction {
+	stack := debug.Stack()
+	sp.SetTag("panic", true)
+	if autoError {
+		ext.Error.Set(sp, true)
+	}
+	logErrorValue(sp, v, stack)
+	action := f(sp, r, v, stack)
+	if action == PanicRespond500 && !sct.wroteheader {
+		http.Error(sct, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	return action
+}
+
+// panicMessage returns the message describing the panic value v.
+func panicMessage(v interface{}) string {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,176 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+type peerOptions struct {
+	trusted []*net.IPNet
+}
+
+// MWPeerTags returns a MWOption that tags the server-side span with the
+// address of the peer the request came from: peer.address for its IP
+// and peer.port for its port.
+//
+// If trustedProxies is not empty, the span is also tagged http.client_ip
+// with the address of the client behind the proxies, as reported by the
+// Forwarded header, or by X-Forwarded-For if there is none. The
+// addresses are read from the nearest hop to the farthest one, as long
+// as they are those of a trusted proxy: the client is the first address
+// that is not, or the farthest one if all are trusted. The headers are
+// ignored if the peer is not a trusted proxy itself, since anyone can
+// set them. Hops that are not an IP address, such as obfuscated
+// identifiers, end the search at the hop before.
+//
+// trustedProxies are IP addresses or CIDR ranges, e.g. "10.0.0.0/8".
+// MWPeerTags panics if one of them is invalid.
+func MWPeerTags(trustedProxies ...string) MWOption {
+	o := &peerOptions{}
+	for _, s := range trustedProxies {
+		o.trusted = append(o.trusted, parseTrustedProxy(s))
+	}
+	return func(options *mwOptions) {
+		options.peer = o
+	}
+}
+
+func parseTrustedProxy(s string) *net.IPNet {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			panic("nethttp: invalid trusted proxy " + strconv.Quote(s))
+		}
+		return network
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		panic("nethttp: invalid trusted proxy " + strconv.Quote(s))
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+func (o *peerOptions) tag(sp opentracing.Span, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		if r.RemoteAddr != "" {
+			ext.PeerAddress.Set(sp, r.RemoteAddr)
+		}
+		return
+	}
+	ext.PeerAddress.Set(sp, host)
+	if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+		ext.PeerPort.Set(sp, uint16(p))
+	}
+	if len(o.trusted) > 0 {
+		if ip := net.ParseIP(host); ip != nil {
+			sp.SetTag("http.client_ip", o.clientIP(ip, r.Header).String())
+		}
+	}
+}
+
+func (o *peerOptions) isTrusted(ip net.IP) bool {
+	for _, network := range o.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client, given the IP of the peer and
+// the headers of the request.
+func (o *peerOptions) clientIP(peer net.IP, h http.Header) net.IP {
+	hops := forwardedHops(h)
+	client := peer
+	for i := len(hops) - 1; i >= 0 && o.isTrusted(client); i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+	}
+	return client
+}
+
+// forwardedHops returns the addresses of the clients and proxies listed
+// by the Forwarded header, or by X-Forwarded-For if there is none, from
+// the farthest to the nearest hop.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	if values := h["Forwarded"]; len(values) > 0 {
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				hops = append(hops, forwardedFor(element))
+			}
+		}
+		return hops
+	}
+	for _, v := range h["X-Forwarded-For"] {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the address of the for parameter of a Forwarded
+// element, without port, or "" if there is none.
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(pair[:i]), "for") {
+			continue
+		}
+		v := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+		if strings.HasPrefix(v, "[") {
+			// [IPv6] or [IPv6]:port
+			if end := strings.IndexByte(v, ']'); end > 0 {
+				return v[1:end]
+			}
+			return v
+		}
+		if host, _, err := net.SplitHostPort(v); err == nil {
+			return host
+		}
+		return v
+	}
+	return ""
+}
+
+// tagPeerAddr tags sp with the remote address of a client connection:
+// peer.address and peer.port for TCP, the socket path for Unix sockets,
+// or the address as formatted by the connection otherwise, as for
+// connections returned by a custom DialContext. transport is set to the
+// network of the address, e.g. "tcp" or "unix".
+func tagPeerAddr(sp opentracing.Span, addr net.Addr) {
+	if addr == nil {
+		return
+	}
+	sp.SetTag("transport", addr.Network())
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ext.PeerAddress.Set(sp, a.IP.String())
+		ext.PeerPort.Set(sp, uint16(a.Port))
+	case *net.UnixAddr:
+		if a.Name != "" {
+			ext.PeerAddress.Set(sp, a.Name)
+		}
+	default:
+		if s := addr.String(); s != "" {
+			ext.PeerAddress.Set(sp, s)
+		}
+	}
+}
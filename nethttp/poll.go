@@ -0,0 +1,176 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// ErrPollDone can be returned by a Poll handler to stop polling without
+// reporting an error.
+var ErrPollDone = errors.New("nethttp: poll done")
+
+// PollOptions controls the behavior of Poll.
+type PollOptions struct {
+	// Tracer used to create the spans. Defaults to the tracer of the
+	// span in the context given to Poll, or opentracing.GlobalTracer().
+	Tracer opentracing.Tracer
+	// OperationName of the parent span covering the whole loop.
+	// Defaults to "HTTP Poll".
+	OperationName string
+	// MinBackoff and MaxBackoff bound the exponential backoff applied
+	// after a failed iteration. They default to 1s and 1m.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxIterations stops the loop after the given number of requests.
+	// Zero means no limit.
+	MaxIterations int
+	// ClientOptions are passed to TraceRequest for every iteration.
+	ClientOptions []ClientOption
+}
+
+// Poll repeatedly issues req with client until ctx is done, the handler
+// returns an error or MaxIterations is reached. All iterations are
+// traced as children of a single parent span, and each iteration span
+// is tagged with its index and the backoff that preceded it.
+//
+// Transport errors and responses with status 429 or 5xx are retried
+// with exponential backoff; any other response is passed to handler.
+// The response body is closed after handler returns. If handler returns
+// ErrPollDone, Poll returns nil. A request with a body must have
+// GetBody set, as http.NewRequest does for in-memory bodies, for the
+// body to be sent again; Poll returns an error otherwise, unless
+// MaxIterations is 1. Cancellation of ctx is not recorded as an error.
+//
+// The client must use Transport for the iteration spans to be recorded.
+func Poll(ctx context.Context, client *http.Client, req *http.Request, handler func(*http.Response) error, opts PollOptions) error {
+	if req.Body != nil && req.GetBody == nil && opts.MaxIterations != 1 {
+		return errors.New("nethttp: cannot poll a request whose body cannot be sent again, GetBody is nil")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	tr := opts.Tracer
+	if tr == nil {
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			tr = parent.Tracer()
+		} else {
+			tr = opentracing.GlobalTracer()
+		}
+	}
+	operationName := opts.OperationName
+	if operationName == "" {
+		operationName = "HTTP Poll"
+	}
+	minBackoff, maxBackoff := opts.MinBackoff, opts.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = time.Minute
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+	}
+
+	sp, ctx := opentracing.StartSpanFromContextWithTracer(ctx, tr, operationName)
+	defer sp.Finish()
+
+	var (
+		iteration int
+		backoff   time.Duration
+		err       error
+	)
+	for opts.MaxIterations == 0 || iteration < opts.MaxIterations {
+		iteration++
+		var retry bool
+		retry, err = pollOnce(ctx, tr, client, req, handler, iteration, backoff, opts.ClientOptions)
+		if err != nil {
+			break
+		}
+		if !retry {
+			backoff = 0
+			continue
+		}
+		if iteration == opts.MaxIterations {
+			// no attempt left to wait for
+			break
+		}
+		if backoff == 0 {
+			backoff = minBackoff
+		} else if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			err = ctx.Err()
+		case <-t.C:
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	sp.SetTag("poll.iterations", iteration)
+	if err == ErrPollDone {
+		return nil
+	}
+	if err == context.Canceled {
+		sp.LogFields(log.String("event", "canceled"))
+	} else if err != nil {
+		ext.Error.Set(sp, true)
+		sp.LogFields(log.String("event", "error"), log.Error(err))
+	}
+	return err
+}
+
+func pollOnce(ctx context.Context, tr opentracing.Tracer, client *http.Client, req *http.Request, handler func(*http.Response) error, iteration int, backoff time.Duration, options []ClientOption) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	r := req.WithContext(ctx)
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return false, err
+		}
+		r.Body = body
+	}
+
+	r, ht := TraceRequest(tr, r, options...)
+	defer ht.Finish()
+
+	resp, err := client.Do(r)
+	if root := ht.Span(); root != nil {
+		root.SetTag("poll.iteration", iteration)
+		root.SetTag("poll.backoff_ms", int64(backoff/time.Millisecond))
+		if err != nil {
+			root.LogFields(log.String("event", "error"), log.Error(err))
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return true, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return true, nil
+	}
+	return false, handler(resp)
+}
@@ -0,0 +1,26 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+)
+
+// MWSkipPreflight returns a MWOption that turns on or off skipping the
+// span of CORS preflight requests, OPTIONS requests with an
+// Access-Control-Request-Method header, which otherwise double the
+// spans of the endpoints called from browsers. As for requests not
+// sampled by MWSpanSampler, the span context of a preflight request is
+// still extracted and carried by the request context, and its metrics
+// are still recorded by MWMetricsRecorder, with the OPTIONS method.
+func MWSkipPreflight(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.skipPreflight = enabled
+	}
+}
+
+// isPreflight reports whether r is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
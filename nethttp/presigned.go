@@ -0,0 +1,79 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// presignedParams are the query parameters carrying credentials in
+// presigned object store URLs (AWS SigV4 and SigV2, Google Cloud Storage).
+var presignedParams = []string{
+	"X-Amz-Algorithm",
+	"X-Amz-Credential",
+	"X-Amz-Date",
+	"X-Amz-Expires",
+	"X-Amz-Security-Token",
+	"X-Amz-Signature",
+	"X-Amz-SignedHeaders",
+	"AWSAccessKeyId",
+	"Signature",
+	"Expires",
+	"X-Goog-Algorithm",
+	"X-Goog-Credential",
+	"X-Goog-Date",
+	"X-Goog-Expires",
+	"X-Goog-Signature",
+	"X-Goog-SignedHeaders",
+	"GoogleAccessId",
+}
+
+// isPresignedURL reports whether u carries a presigned URL signature.
+func isPresignedURL(u *url.URL) bool {
+	q := u.Query()
+	return q.Get("X-Amz-Signature") != "" ||
+		q.Get("X-Goog-Signature") != "" ||
+		(q.Get("Signature") != "" && (q.Get("AWSAccessKeyId") != "" || q.Get("GoogleAccessId") != ""))
+}
+
+// tagPresignedURL sets the http.url tag of sp to u stripped from its
+// credentials, and tags the bucket and object key separately.
+func tagPresignedURL(sp opentracing.Span, u *url.URL) {
+	q := u.Query()
+	for _, p := range presignedParams {
+		q.Del(p)
+	}
+	stripped := *u
+	stripped.User = nil
+	stripped.RawQuery = q.Encode()
+	sp.SetTag("http.url", stripped.String())
+
+	bucket, key := objectStoreLocation(u)
+	if bucket != "" {
+		sp.SetTag("object_store.bucket", bucket)
+	}
+	if key != "" {
+		sp.SetTag("object_store.key", key)
+	}
+}
+
+// objectStoreLocation splits u into bucket and object key, handling
+// both virtual-hosted ("bucket.s3.amazonaws.com/key") and path style
+// ("s3.amazonaws.com/bucket/key") addressing.
+func objectStoreLocation(u *url.URL) (bucket, key string) {
+	host := u.Host
+	path := strings.TrimPrefix(u.Path, "/")
+	for _, marker := range []string{".s3.", ".s3-", ".storage.googleapis.com"} {
+		if i := strings.Index(host, marker); i > 0 {
+			return host[:i], path
+		}
+	}
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
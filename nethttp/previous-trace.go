@@ -0,0 +1,57 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// PreviousTraceIDHeader is the default request header carrying the
+// trace ID of the previous request of a client, see
+// MWPreviousTraceLink.
+const PreviousTraceIDHeader = "X-Previous-Trace-Id"
+
+// maxTraceIDLength bounds the length of trace IDs read from headers.
+const maxTraceIDLength = 128
+
+// MWPreviousTraceLink returns a MWOption that links the trace of a
+// request to the trace of the client's previous request, as given by
+// the header name (PreviousTraceIDHeader if empty), so that
+// multi-request user flows such as polling can be stitched together.
+// The span is tagged previous_trace.id with the header value, provided
+// it looks like a trace ID: at most 128 letters, digits and dashes.
+//
+// Clients typically echo the trace ID returned by MWResponseTraceHeader
+// in their next request.
+func MWPreviousTraceLink(name string) MWOption {
+	if name == "" {
+		name = PreviousTraceIDHeader
+	}
+	return func(options *mwOptions) {
+		options.previousTraceHeader = name
+	}
+}
+
+func tagPreviousTrace(sp opentracing.Span, r *http.Request, name string) {
+	id := r.Header.Get(name)
+	if id == "" || !isTraceID(id) {
+		return
+	}
+	sp.SetTag("previous_trace.id", id)
+}
+
+// isTraceID reports whether s looks like a trace ID.
+func isTraceID(s string) bool {
+	if len(s) > maxTraceIDLength {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
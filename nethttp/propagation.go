@@ -0,0 +1,257 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Injector writes a span context into the headers of an outgoing
+// request. The tracer that created the span is given so that custom
+// formats can build on its native encoding.
+type Injector interface {
+	Inject(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error
+}
+
+// Extractor reads a span context from the headers of an incoming
+// request.
+type Extractor interface {
+	Extract(tr opentracing.Tracer, h http.Header) (opentracing.SpanContext, error)
+}
+
+// InjectorFunc adapts an ordinary function to the Injector interface.
+type InjectorFunc func(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error
+
+// Inject calls f(tr, sc, h).
+func (f InjectorFunc) Inject(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error {
+	return f(tr, sc, h)
+}
+
+// ExtractorFunc adapts an ordinary function to the Extractor interface.
+type ExtractorFunc func(tr opentracing.Tracer, h http.Header) (opentracing.SpanContext, error)
+
+// Extract calls f(tr, h).
+func (f ExtractorFunc) Extract(tr opentracing.Tracer, h http.Header) (opentracing.SpanContext, error) {
+	return f(tr, h)
+}
+
+// Names of the built-in propagators. PropagatorTraceContextHeaders and
+// PropagatorB3Headers are header filters, not codecs: they neither parse
+// nor encode their format, but restrict the tracer's
+// opentracing.HTTPHeaders format to the headers of the format, so the
+// tracer must speak it, e.g. a tracer configured for B3 propagation.
+// They give precedence to one format over another when a request
+// carries several. A custom codec can be registered with
+// RegisterPropagator.
+const (
+	// PropagatorNative is the tracer's opentracing.HTTPHeaders format.
+	PropagatorNative = "native"
+	// PropagatorTraceContextHeaders filters the W3C Trace Context
+	// headers, traceparent and tracestate.
+	PropagatorTraceContextHeaders = "tracecontext-headers"
+	// PropagatorB3Headers filters the Zipkin B3 headers, multi or single
+	// header.
+	PropagatorB3Headers = "b3-headers"
+)
+
+type propagator struct {
+	injector  Injector
+	extractor Extractor
+}
+
+var (
+	propagatorsMu sync.RWMutex
+	propagators   = map[string]propagator{
+		PropagatorNative:              headerFilterPropagator(nil),
+		PropagatorTraceContextHeaders: headerFilterPropagator([]string{"Traceparent", "Tracestate"}),
+		PropagatorB3Headers: headerFilterPropagator([]string{
+			"B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled", "X-B3-Flags",
+		}),
+	}
+)
+
+// errNoHeadersInjected is returned by the injectors of header filters
+// when the tracer injects none of the headers they keep.
+var errNoHeadersInjected = errors.New("nethttp: tracer injected none of the filtered headers")
+
+// headerFilterPropagator returns a propagator using the tracer's
+// opentracing.HTTPHeaders format restricted to the given headers, or to
+// all headers if names is nil.
+func headerFilterPropagator(names []string) propagator {
+	filter := func(h http.Header) http.Header {
+		if names == nil {
+			return h
+		}
+		res := http.Header{}
+		for _, name := range names {
+			if vv, ok := h[name]; ok {
+				res[name] = vv
+			}
+		}
+		return res
+	}
+	return propagator{
+		injector: InjectorFunc(func(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error {
+			carrier := http.Header{}
+			if err := tr.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(carrier)); err != nil {
+				return err
+			}
+			filtered := filter(carrier)
+			if names != nil && len(filtered) == 0 {
+				return errNoHeadersInjected
+			}
+			for k, vv := range filtered {
+				h[k] = vv
+			}
+			return nil
+		}),
+		extractor: ExtractorFunc(func(tr opentracing.Tracer, h http.Header) (opentracing.SpanContext, error) {
+			h = filter(h)
+			if len(h) == 0 {
+				return nil, opentracing.ErrSpanContextNotFound
+			}
+			return tr.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+		}),
+	}
+}
+
+// RegisterPropagator registers a custom header format under name, so
+// that it can be referenced by MWPropagator on the server side and by
+// ClientPropagator on the client side. Registering a name twice
+// replaces the previous propagator. Either injector or extractor may be
+// nil if the format is only used in one direction.
+func RegisterPropagator(name string, injector Injector, extractor Extractor) {
+	propagatorsMu.Lock()
+	defer propagatorsMu.Unlock()
+	propagators[name] = propagator{injector: injector, extractor: extractor}
+}
+
+func lookupPropagator(name string) (propagator, bool) {
+	propagatorsMu.RLock()
+	defer propagatorsMu.RUnlock()
+	p, ok := propagators[name]
+	return p, ok
+}
+
+// MWPropagator returns a MWOption that extracts the span context of
+// incoming requests with the propagator registered under name. If no
+// such propagator is registered when a request is served, the tracer's
+// opentracing.HTTPHeaders format is used.
+func MWPropagator(name string) MWOption {
+	return MWPropagators(name)
+}
+
+// MWPropagators returns a MWOption that tries the propagators registered
+// under names in order to extract the span context of incoming
+// requests, and uses the first span context found. This allows e.g.
+// preferring W3C Trace Context over B3 over the native format:
+//
+//	nethttp.MWPropagators(nethttp.PropagatorTraceContextHeaders, nethttp.PropagatorB3Headers, nethttp.PropagatorNative)
+//
+// Names that are not registered when a request is served are skipped.
+// If none is registered, the tracer's opentracing.HTTPHeaders format is
+// used.
+func MWPropagators(names ...string) MWOption {
+	return func(options *mwOptions) {
+		options.propagators = names
+	}
+}
+
+// MWExtractFunc returns a MWOption that extracts the span context of
+// incoming requests with f instead of the tracer's
+// opentracing.HTTPHeaders format or the propagators of MWPropagators,
+// e.g. for upstream systems sending it in non-standard headers or in
+// the query string:
+//
+//	nethttp.MWExtractFunc(func(r *http.Request, tr opentracing.Tracer) (opentracing.SpanContext, error) {
+//		carrier := opentracing.TextMapCarrier{}
+//		for k, v := range r.URL.Query() {
+//			carrier[k] = v[0]
+//		}
+//		return tr.Extract(opentracing.TextMap, carrier)
+//	})
+//
+// The request is traced as a new trace if f returns an error.
+func MWExtractFunc(f func(r *http.Request, tr opentracing.Tracer) (opentracing.SpanContext, error)) MWOption {
+	return func(options *mwOptions) {
+		options.extractFunc = f
+	}
+}
+
+// MWExtractErrorFunc returns a MWOption that calls f with the error of
+// extracting the span context of a request, e.g. to count malformed
+// trace headers sent by upstream systems. It is not called with
+// opentracing.ErrSpanContextNotFound, which only means that the request
+// is not part of a trace.
+func MWExtractErrorFunc(f func(r *http.Request, err error)) MWOption {
+	return func(options *mwOptions) {
+		options.extractErrorFunc = f
+	}
+}
+
+// ClientPropagator returns a ClientOption that injects the span context
+// of outgoing requests with the propagator registered under name. If no
+// such propagator is registered when a request is sent, the tracer's
+// opentracing.HTTPHeaders format is used.
+func ClientPropagator(name string) ClientOption {
+	return ClientPropagators(name)
+}
+
+// ClientPropagators returns a ClientOption that injects the span context
+// of outgoing requests with every propagator registered under names,
+// mirroring MWPropagators, so that services expecting different formats
+// can all continue the trace. Names that are not registered when a
+// request is sent are skipped. If none is registered, or none injects
+// the span context, e.g. because the tracer does not speak the format of
+// the header filters, the tracer's opentracing.HTTPHeaders format is
+// used so that the trace still continues.
+func ClientPropagators(names ...string) ClientOption {
+	return func(options *clientOptions) {
+		options.propagators = names
+	}
+}
+
+func extractSpanContext(tr opentracing.Tracer, h http.Header, names []string) (opentracing.SpanContext, error) {
+	var registered bool
+	for _, name := range names {
+		p, ok := lookupPropagator(name)
+		if !ok || p.extractor == nil {
+			continue
+		}
+		registered = true
+		if sc, err := p.extractor.Extract(tr, h); err == nil && sc != nil {
+			return sc, nil
+		}
+	}
+	if registered {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	return tr.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+}
+
+func injectSpanContext(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header, names []string) error {
+	var injected bool
+	var firstErr error
+	for _, name := range names {
+		p, ok := lookupPropagator(name)
+		if !ok || p.injector == nil {
+			continue
+		}
+		if err := p.injector.Inject(tr, sc, h); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		injected = true
+	}
+	if injected {
+		return firstErr
+	}
+	return tr.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+}
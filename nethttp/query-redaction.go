@@ -0,0 +1,86 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/url"
+	"strings"
+)
+
+type queryRedaction struct {
+	names map[string]bool
+	// allow is true if names are the parameters left as they are rather
+	// than those redacted.
+	allow bool
+}
+
+// MWRedactQueryParams returns a MWOption that replaces the values of the
+// query parameters named names with RedactedValue in the http.url tag,
+// e.g. to keep tokens and API keys out of the traces:
+//
+//	nethttp.MWRedactQueryParams("token", "password", "api_key")
+//
+// Names are matched case-insensitively, and the other parameters, as
+// well as the order of all of them, are left as they are. The request
+// itself is not modified. MWRedactQueryParams replaces
+// MWAllowQueryParams, and applies before MWURLTagFunc, which is given
+// the redacted URL.
+func MWRedactQueryParams(names ...string) MWOption {
+	o := newQueryRedaction(names, false)
+	return func(options *mwOptions) {
+		options.queryRedaction = o
+	}
+}
+
+// MWAllowQueryParams returns a MWOption that replaces the values of all
+// the query parameters but those named names with RedactedValue in the
+// http.url tag, for services that would rather list the parameters that
+// are safe to record, see MWRedactQueryParams.
+func MWAllowQueryParams(names ...string) MWOption {
+	o := newQueryRedaction(names, true)
+	return func(options *mwOptions) {
+		options.queryRedaction = o
+	}
+}
+
+func newQueryRedaction(names []string, allow bool) *queryRedaction {
+	o := &queryRedaction{names: make(map[string]bool, len(names)), allow: allow}
+	for _, name := range names {
+		o.names[strings.ToLower(name)] = true
+	}
+	return o
+}
+
+// redact returns u with the values of the redacted parameters replaced,
+// or u itself if there are none.
+func (o *queryRedaction) redact(u *url.URL) *url.URL {
+	if u.RawQuery == "" {
+		return u
+	}
+	params := strings.Split(u.RawQuery, "&")
+	changed := false
+	for i, param := range params {
+		if param == "" {
+			continue
+		}
+		name := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			name = param[:j]
+		}
+		key, err := url.QueryUnescape(name)
+		if err != nil {
+			key = name
+		}
+		if o.names[strings.ToLower(key)] != o.allow {
+			params[i] = name + "=" + RedactedValue
+			changed = true
+		}
+	}
+	if !changed {
+		return u
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return &redacted
+}
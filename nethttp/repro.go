@@ -0,0 +1,65 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// ClientReproCommand returns a ClientOption that turns on or off logging
+// a curl command reproducing failed requests, i.e. requests returning an
+// error or a status code deemed an error by ClientErrorFunc. The command
+// is logged in the repro.command field of a repro event.
+//
+// The command is sanitized: credentials are removed from the URL,
+// sensitive headers (see DefaultHeaderRedactor) and the span context
+// headers are left out, and so is the body. It is meant for development
+// and staging environments.
+func ClientReproCommand(enabled bool) ClientOption {
+	return func(options *clientOptions) {
+		options.reproCommand = enabled
+	}
+}
+
+// reproCommand returns a sanitized curl command sending req.
+func reproCommand(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	if isPresignedURL(&u) {
+		q := u.Query()
+		for _, p := range presignedParams {
+			q.Del(p)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	cmd := []string{"curl", "-X", req.Method, shellQuote(u.String())}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if !sensitiveHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range req.Header[name] {
+			cmd = append(cmd, "-H", shellQuote(name+": "+v))
+		}
+	}
+	return strings.Join(cmd, " ")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func logReproCommand(sp opentracing.Span, cmd string) {
+	sp.LogFields(log.String("event", "repro"), log.String("repro.command", cmd))
+}
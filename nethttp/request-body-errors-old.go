@@ -0,0 +1,17 @@
+//go:build go1.7 && !go1.19
+// +build go1.7,!go1.19
+
+package nethttp
+
+// maxBytesErrorMapping recognizes the error of http.MaxBytesReader by
+// its message, as it has no type before Go 1.19.
+var maxBytesErrorMapping = BodyErrorMapping{
+	Match: func(err error) bool {
+		return err.Error() == "http: request body too large"
+	},
+	Kind: "too_large",
+}
+
+func errorIs(err, target error) bool {
+	return err == target
+}
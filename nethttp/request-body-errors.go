@@ -0,0 +1,28 @@
+//go:build go1.19
+// +build go1.19
+
+package nethttp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+var maxBytesErrorMapping = BodyErrorMapping{
+	Match: func(err error) bool {
+		var maxBytes *http.MaxBytesError
+		return errors.As(err, &maxBytes)
+	},
+	Kind: "too_large",
+	Tags: func(err error) opentracing.Tags {
+		var maxBytes *http.MaxBytesError
+		errors.As(err, &maxBytes)
+		return opentracing.Tags{"http.request.body_limit": maxBytes.Limit}
+	},
+}
+
+func errorIs(err, target error) bool {
+	return errors.Is(err, target)
+}
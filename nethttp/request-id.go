@@ -0,0 +1,110 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// maxRequestIDLength bounds the length of the incoming request IDs that
+// are reused.
+const maxRequestIDLength = 128
+
+type requestIDOptions struct {
+	header   string
+	generate func() string
+}
+
+// requestID is the request ID stored in the request context.
+type requestID struct {
+	header string
+	value  string
+}
+
+// MWRequestID returns a MWOption that gives every request an ID: the
+// value of the request header named header, "X-Request-Id" if empty, or
+// one returned by generate if the header is missing or invalid. Valid
+// IDs have at most 128 printable ASCII characters. If generate is nil,
+// IDs are 32 random hexadecimal digits.
+//
+// The ID is set on the request header, so that handlers and loggers
+// reading it see the same value, tagged http.request_id on the
+// server-side span, stored in the request context, see
+// RequestIDFromContext, and echoed on the response header right before
+// the headers are written, unless the handler set it. Requests
+// traced with that context by a Transport carry the ID too, unless they
+// set the header themselves. Requests served without span, e.g. not
+// sampled or filtered out, get an ID all the same.
+func MWRequestID(header string, generate func() string) MWOption {
+	if header == "" {
+		header = "X-Request-Id"
+	}
+	if generate == nil {
+		generate = randomRequestID
+	}
+	o := &requestIDOptions{header: http.CanonicalHeaderKey(header), generate: generate}
+	return func(options *mwOptions) {
+		options.requestID = o
+	}
+}
+
+// RequestIDFromContext returns the request ID given by MWRequestID to
+// the request with context ctx, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(keyRequestID).(*requestID); ok {
+		return id.value
+	}
+	return ""
+}
+
+// start sets the ID of r, tags sp with it, if not nil, and has sct echo
+// it on the response to w. It returns ctx carrying the ID.
+func (o *requestIDOptions) start(ctx context.Context, sp opentracing.Span, r *http.Request, w http.ResponseWriter, sct *statusCodeTracker) context.Context {
+	value := r.Header.Get(o.header)
+	if !validRequestID(value) {
+		value = o.generate()
+		r.Header.Set(o.header, value)
+	}
+	if sp != nil {
+		sp.SetTag("http.request_id", value)
+	}
+	sct.headerHooks = append(sct.headerHooks, func(int) {
+		if w.Header().Get(o.header) == "" {
+			w.Header().Set(o.header, value)
+		}
+	})
+	return context.WithValue(ctx, keyRequestID, &requestID{header: o.header, value: value})
+}
+
+// propagateRequestID sets the request ID of ctx on the outgoing header h,
+// unless it is already set.
+func propagateRequestID(ctx context.Context, h http.Header) {
+	id, ok := ctx.Value(keyRequestID).(*requestID)
+	if ok && h.Get(id.header) == "" {
+		h.Set(id.header, id.value)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func randomRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
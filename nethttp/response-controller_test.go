@@ -0,0 +1,63 @@
+//go:build go1.20
+// +build go1.20
+
+package nethttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestResponseController(t *testing.T) {
+	var deadlineErr, flushErr error
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		deadlineErr = rc.SetReadDeadline(time.Now().Add(time.Second))
+		w.Write([]byte("OK"))
+		flushErr = rc.Flush()
+	})
+
+	tr := &mocktracer.MockTracer{}
+	srv := httptest.NewServer(Middleware(tr, h))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if deadlineErr != nil {
+		t.Fatalf("SetReadDeadline failed: %v", deadlineErr)
+	}
+	if flushErr != nil {
+		t.Fatalf("Flush failed: %v", flushErr)
+	}
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, want := spans[0].Tag("http.response_size"), int64(2); got != want {
+		t.Fatalf("got %v http.response_size, expected %v", got, want)
+	}
+}
+
+func TestResponseControllerUnwrap(t *testing.T) {
+	w := httptest.NewRecorder()
+	sct := &statusCodeTracker{ResponseWriter: w}
+	unwrapper, ok := sct.wrappedResponseWriter().(interface {
+		Unwrap() http.ResponseWriter
+	})
+	if !ok {
+		t.Fatal("wrapped ResponseWriter does not implement Unwrap")
+	}
+	if unwrapper.Unwrap() != w {
+		t.Fatal("Unwrap does not return the original ResponseWriter")
+	}
+}
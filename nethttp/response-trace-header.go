@@ -0,0 +1,45 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+type responseTraceHeader struct {
+	name    string
+	traceID func(sp opentracing.Span) string
+}
+
+// MWResponseTraceHeader returns a MWOption that sets the response header
+// name to the trace identifier returned by f, right before the headers
+// are written, so that clients and support tooling can correlate
+// failed requests with their traces. If f is nil, the trace ID is read
+// from the span context of tracers exposing it, such as Jaeger, Zipkin
+// and mocktracer. The header is not set if the identifier is empty.
+//
+// Example:
+//
+//	nethttp.MWResponseTraceHeader("X-Trace-Id", nil)
+func MWResponseTraceHeader(name string, f func(sp opentracing.Span) string) MWOption {
+	if f == nil {
+		f = func(sp opentracing.Span) string {
+			return traceIDOf(sp.Context())
+		}
+	}
+	return func(options *mwOptions) {
+		options.responseTraceHeader = &responseTraceHeader{name: name, traceID: f}
+	}
+}
+
+// hook returns the header hook setting the header of w.
+func (h *responseTraceHeader) hook(sp opentracing.Span, w http.ResponseWriter) func(int) {
+	return func(int) {
+		if id := h.traceID(sp); id != "" {
+			w.Header().Set(h.name, id)
+		}
+	}
+}
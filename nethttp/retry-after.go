@@ -0,0 +1,69 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// MWRetryAfterPolicy returns a MWOption that advises clients when to
+// retry requests that are throttled or rejected: when the handler
+// responds with 429 Too Many Requests or 503 Service Unavailable without
+// a Retry-After header, f is called with the request and the status
+// code, and the header is set to the backoff it returns, rounded up to
+// the second. f returns 0 to not set the header.
+//
+// The advised backoff, whether it comes from f or from the handler, is
+// tagged http.retry_after_s on the server-side span. Requests served
+// without span, e.g. not sampled, are advised all the same.
+//
+// Example:
+//
+//	nethttp.MWRetryAfterPolicy(func(r *http.Request, status int) time.Duration {
+//		if status == http.StatusTooManyRequests {
+//			return limiter.Reset()
+//		}
+//		return 30 * time.Second
+//	})
+func MWRetryAfterPolicy(f func(r *http.Request, status int) time.Duration) MWOption {
+	return func(options *mwOptions) {
+		options.retryAfter = f
+	}
+}
+
+// adviseRetryAfter sets the Retry-After header of w with policy, if
+// the response is sent with status, and tags sp with it, if not nil.
+func adviseRetryAfter(sp opentracing.Span, w http.ResponseWriter, r *http.Request, status int, policy func(r *http.Request, status int) time.Duration) {
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return
+	}
+	if v := w.Header().Get("Retry-After"); v != "" {
+		if sp == nil {
+			return
+		}
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+			sp.SetTag("http.retry_after_s", seconds)
+		} else if t, err := http.ParseTime(v); err == nil {
+			sp.SetTag("http.retry_after_s", roundUpSeconds(t.Sub(time.Now())))
+		}
+		return
+	}
+	d := policy(r, status)
+	if d <= 0 {
+		return
+	}
+	seconds := roundUpSeconds(d)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	if sp != nil {
+		sp.SetTag("http.retry_after_s", seconds)
+	}
+}
+
+func roundUpSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
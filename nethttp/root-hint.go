@@ -0,0 +1,57 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// RootIDHintTag is the tag given to the tracer when starting a root span
+// with an identity hint, see MWRootIDHint.
+const RootIDHintTag = "trace.root_id_hint"
+
+// MWRootIDHint returns a MWOption that calls f for requests without a
+// span context and starts their server-side span, a root span, with a
+// RootIDHintTag tag set to the returned hint, e.g. the ID given to the
+// request by an external system:
+//
+//	nethttp.MWRootIDHint(func(r *http.Request) string {
+//		return r.Header.Get("X-Amzn-Trace-Id")
+//	})
+//
+// OpenTracing has no API to choose the IDs of a span, so the hint is
+// passed the only way every tracer understands: as a tag of the start
+// options. Tracers, or their ID generators, that look for the tag can
+// derive the trace ID from the hint deterministically; others record it
+// as any other tag, which still relates both IDs. No tag is set if f
+// returns an empty hint.
+func MWRootIDHint(f func(r *http.Request) string) MWOption {
+	return func(options *mwOptions) {
+		options.rootIDHint = f
+	}
+}
+
+// ClientRootIDHint returns a ClientOption that calls f for requests
+// whose context has no span and starts the root span of the request with
+// a RootIDHintTag tag set to the returned hint, see MWRootIDHint.
+func ClientRootIDHint(f func(r *http.Request) string) ClientOption {
+	return func(options *clientOptions) {
+		options.rootIDHint = f
+	}
+}
+
+// rootIDHint returns the start option passing the hint returned by f
+// for r, or nil if there is none.
+func rootIDHint(f func(r *http.Request) string, r *http.Request) opentracing.StartSpanOption {
+	if f == nil {
+		return nil
+	}
+	hint := f(r)
+	if hint == "" {
+		return nil
+	}
+	return opentracing.Tag{Key: RootIDHintTag, Value: hint}
+}
@@ -0,0 +1,142 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"strings"
+)
+
+// RouteOptions is a registry of MWOptions overriding the options of a
+// Middleware for some routes, so that a single Middleware can serve a
+// whole mux.
+//
+// Example:
+//
+//	routes := nethttp.NewRouteOptions().
+//		Add("/internal/*", nethttp.MWURLTagFunc(func(u *url.URL) string { return "" })).
+//		Add("/api/v2/*", nethttp.OperationNameFunc(v2OperationName))
+//	mw := nethttp.Middleware(tracer, mux, nethttp.MWRouteOptions(routes))
+type RouteOptions struct {
+	routes []routeOptions
+}
+
+type routeOptions struct {
+	pattern string
+	options []MWOption
+}
+
+type route struct {
+	pattern string
+	prefix  bool
+	// segments are the segments of pattern if it has wildcards, nil
+	// otherwise.
+	segments []string
+	// literal is the length of pattern without its wildcards, so that
+	// the most specific pattern wins.
+	literal int
+	opts    *mwOptions
+}
+
+// NewRouteOptions returns an empty RouteOptions.
+func NewRouteOptions() *RouteOptions {
+	return &RouteOptions{}
+}
+
+// Add registers options for the requests matching pattern and returns
+// ro. A pattern ending in "/" or "/*" matches every path under it, any
+// other pattern matches the path exactly. A segment of the form {name},
+// as in ServeMux and OpenAPI paths, matches any non-empty segment, e.g.
+// "/users/{id}/orders" matches "/users/42/orders". When several patterns
+// match, the one with the longest literal part is used, so that
+// "/users/me" wins over "/users/{id}".
+//
+// The options are applied on top of the options given to Middleware.
+func (ro *RouteOptions) Add(pattern string, options ...MWOption) *RouteOptions {
+	ro.routes = append(ro.routes, routeOptions{pattern: pattern, options: options})
+	return ro
+}
+
+// MWRouteOptions returns a MWOption that applies the options registered
+// in ro to the requests of their routes. ro must not be modified once
+// the Middleware is created.
+func MWRouteOptions(ro *RouteOptions) MWOption {
+	return func(options *mwOptions) {
+		options.routes = ro
+	}
+}
+
+// resolve builds the options of every route from the options given to
+// Middleware, base, and the route's own options.
+func (ro *RouteOptions) resolve(base []MWOption) []route {
+	routes := make([]route, 0, len(ro.routes))
+	for _, r := range ro.routes {
+		opts := newMWOptions(append(append([]MWOption(nil), base...), r.options...))
+		opts.routes = nil
+		pattern := strings.TrimSuffix(r.pattern, "*")
+		rt := route{
+			pattern: pattern,
+			prefix:  strings.HasSuffix(pattern, "/"),
+			literal: len(pattern),
+			opts:    opts,
+		}
+		if strings.Contains(pattern, "{") {
+			rt.segments = strings.Split(pattern, "/")
+			for _, seg := range rt.segments {
+				if isWildcardSegment(seg) {
+					rt.literal -= len(seg)
+				}
+			}
+		}
+		routes = append(routes, rt)
+	}
+	return routes
+}
+
+func isWildcardSegment(seg string) bool {
+	return len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}'
+}
+
+// matches reports whether path matches r.
+func (r *route) matches(path string) bool {
+	if r.segments == nil {
+		return r.pattern == path || (r.prefix && strings.HasPrefix(path, r.pattern))
+	}
+	segments := strings.Split(path, "/")
+	n := len(r.segments)
+	if r.prefix {
+		// the last segment of the pattern is empty and matches the
+		// rest of the path
+		if len(segments) < n {
+			return false
+		}
+		n--
+	} else if len(segments) != n {
+		return false
+	}
+	for i, seg := range r.segments[:n] {
+		if seg != segments[i] && !(isWildcardSegment(seg) && segments[i] != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRoute returns the options of the longest route matching path, or
+// nil if there is none.
+func matchRoute(routes []route, path string) *mwOptions {
+	var match *route
+	for i := range routes {
+		r := &routes[i]
+		if !r.matches(path) {
+			continue
+		}
+		if match == nil || r.literal > match.literal {
+			match = r
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	return match.opts
+}
@@ -0,0 +1,14 @@
+//go:build go1.7 && !go1.23
+// +build go1.7,!go1.23
+
+package nethttp
+
+import (
+	"net/http"
+)
+
+// requestPattern returns the ServeMux pattern that matched r, which is
+// only recorded by ServeMux since Go 1.23.
+func requestPattern(r *http.Request) string {
+	return ""
+}
@@ -0,0 +1,13 @@
+//go:build go1.23
+// +build go1.23
+
+package nethttp
+
+import (
+	"net/http"
+)
+
+// requestPattern returns the ServeMux pattern that matched r.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
@@ -0,0 +1,46 @@
+//go:build go1.23
+// +build go1.23
+
+package nethttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestOperationNameFromPatternOption(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		url     string
+		options []MWOption
+		opName  string
+	}{
+		{"/users/42", nil, "HTTP GET"},
+		{"/users/42", []MWOption{MWOperationNameFromPattern(true)}, "GET /users/{id}"},
+		{"/static/app.js", []MWOption{MWOperationNameFromPattern(true)}, "GET /static/"},
+		{"/unknown", []MWOption{MWOperationNameFromPattern(true)}, "HTTP GET"},
+		{"/users/42", []MWOption{MWOperationNameFromPattern(true), MWOperationPrefix("api.")}, "api.GET /users/{id}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.opName, func(t *testing.T) {
+			tr := &mocktracer.MockTracer{}
+			mw := Middleware(tr, mux, tt.options...)
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.url, nil))
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got, want := spans[0].OperationName, tt.opName; got != want {
+				t.Fatalf("got %s operation name, expected %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+//go:build go1.7 && !go1.16
+// +build go1.7,!go1.16
+
+package nethttp
+
+import (
+	"runtime"
+	"time"
+)
+
+// readAllocs returns the cumulative number of bytes and objects
+// allocated on the heap. runtime.ReadMemStats stops the world, which is
+// acceptable for the sampled diagnostics using it.
+func readAllocs() (bytes, objects uint64) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.TotalAlloc, m.Mallocs
+}
+
+// latencySampler reports the longest GC pause since the previous
+// sample. Scheduler latencies require runtime/metrics (Go 1.17).
+type latencySampler struct {
+	numGC uint32
+}
+
+func newLatencySampler() *latencySampler {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &latencySampler{numGC: m.NumGC}
+}
+
+func (s *latencySampler) sample() (sched, gc time.Duration) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	// only the last len(m.PauseNs) pauses are kept
+	n := s.numGC
+	if m.NumGC-n > uint32(len(m.PauseNs)) {
+		n = m.NumGC - uint32(len(m.PauseNs))
+	}
+	for ; n < m.NumGC; n++ {
+		if pause := time.Duration(m.PauseNs[n%uint32(len(m.PauseNs))]); pause > gc {
+			gc = pause
+		}
+	}
+	s.numGC = m.NumGC
+	return 0, gc
+}
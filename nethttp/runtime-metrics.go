@@ -0,0 +1,88 @@
+//go:build go1.16
+// +build go1.16
+
+package nethttp
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+const (
+	schedLatenciesMetric = "/sched/latencies:seconds"
+	gcPausesMetric       = "/gc/pauses:seconds"
+)
+
+// readAllocs returns the cumulative number of bytes and objects
+// allocated on the heap, using runtime/metrics which does not stop the
+// world.
+func readAllocs() (bytes, objects uint64) {
+	samples := []metrics.Sample{
+		{Name: "/gc/heap/allocs:bytes"},
+		{Name: "/gc/heap/allocs:objects"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		bytes = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		objects = samples[1].Value.Uint64()
+	}
+	return bytes, objects
+}
+
+// latencySampler computes the 99th percentile of the scheduler latency
+// and GC pause histograms over the time elapsed between two samples.
+type latencySampler struct {
+	prev map[string][]uint64
+}
+
+func newLatencySampler() *latencySampler {
+	return &latencySampler{prev: make(map[string][]uint64)}
+}
+
+func (s *latencySampler) sample() (sched, gc time.Duration) {
+	samples := []metrics.Sample{
+		{Name: schedLatenciesMetric},
+		{Name: gcPausesMetric},
+	}
+	metrics.Read(samples)
+	return s.p99(samples[0]), s.p99(samples[1])
+}
+
+func (s *latencySampler) p99(sample metrics.Sample) time.Duration {
+	if sample.Value.Kind() != metrics.KindFloat64Histogram {
+		return 0
+	}
+	h := sample.Value.Float64Histogram()
+	prev := s.prev[sample.Name]
+	s.prev[sample.Name] = append([]uint64(nil), h.Counts...)
+
+	var total uint64
+	delta := make([]uint64, len(h.Counts))
+	for i, c := range h.Counts {
+		if len(prev) == len(h.Counts) {
+			c -= prev[i]
+		}
+		delta[i] = c
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(float64(total) * 0.99))
+	var seen uint64
+	for i, c := range delta {
+		seen += c
+		if seen < rank {
+			continue
+		}
+		upper := h.Buckets[i+1]
+		if math.IsInf(upper, 1) {
+			upper = h.Buckets[i]
+		}
+		return time.Duration(upper * float64(time.Second))
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// RuntimePressure periodically samples the scheduler latency and the GC
+// pauses of the process, and reports whether the runtime is saturated.
+type RuntimePressure struct {
+	schedThreshold time.Duration
+	gcThreshold    time.Duration
+	high           int32
+	stop           chan struct{}
+	stopOnce       sync.Once
+}
+
+// NewRuntimePressure starts sampling the runtime every interval. The
+// runtime is considered under high pressure while the 99th percentile
+// of the scheduler latency observed during the last interval exceeds
+// schedThreshold, or the 99th percentile of the GC pauses exceeds
+// gcThreshold. A zero threshold disables the corresponding check.
+//
+// Scheduler latencies are only available with Go 1.17 and later.
+// Stop must be called to release the sampling goroutine.
+func NewRuntimePressure(interval, schedThreshold, gcThreshold time.Duration) *RuntimePressure {
+	p := &RuntimePressure{
+		schedThreshold: schedThreshold,
+		gcThreshold:    gcThreshold,
+		stop:           make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *RuntimePressure) run(interval time.Duration) {
+	s := newLatencySampler()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			p.update(s.sample())
+		}
+	}
+}
+
+func (p *RuntimePressure) update(sched, gc time.Duration) {
+	var high int32
+	if (p.schedThreshold > 0 && sched > p.schedThreshold) || (p.gcThreshold > 0 && gc > p.gcThreshold) {
+		high = 1
+	}
+	atomic.StoreInt32(&p.high, high)
+}
+
+// High reports whether the runtime was under high pressure during the
+// last sampling interval.
+func (p *RuntimePressure) High() bool {
+	return atomic.LoadInt32(&p.high) == 1
+}
+
+// Stop stops sampling the runtime.
+func (p *RuntimePressure) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// MWRuntimePressure returns a MWOption that tags every server-side span
+// with runtime.gomaxprocs, and with runtime.pressure=high when the span
+// starts while p reports high pressure, so that latency outliers caused
+// by a saturated process can be told apart in traces.
+func MWRuntimePressure(p *RuntimePressure) MWOption {
+	return func(options *mwOptions) {
+		options.pressure = p
+	}
+}
+
+func (p *RuntimePressure) tag(sp opentracing.Span) {
+	sp.SetTag("runtime.gomaxprocs", runtime.GOMAXPROCS(0))
+	if p.High() {
+		sp.SetTag("runtime.pressure", "high")
+	}
+}
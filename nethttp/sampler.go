@@ -0,0 +1,112 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// SpanSampler decides whether a span is created for a request.
+type SpanSampler interface {
+	Sample(r *http.Request) bool
+}
+
+// SpanSamplerFunc adapts an ordinary function to the SpanSampler
+// interface.
+type SpanSamplerFunc func(r *http.Request) bool
+
+// Sample calls f(r).
+func (f SpanSamplerFunc) Sample(r *http.Request) bool {
+	return f(r)
+}
+
+// ProbabilisticSampler returns a SpanSampler that samples the given
+// fraction of requests, e.g. 0.01 for 1%.
+func ProbabilisticSampler(fraction float64) SpanSampler {
+	return probabilisticSampler(fraction)
+}
+
+type probabilisticSampler float64
+
+func (s probabilisticSampler) Sample(*http.Request) bool {
+	return sampled(float64(s))
+}
+
+// RateLimitingSampler returns a SpanSampler that samples at most
+// spansPerSecond requests per second, with bursts of up to one second
+// worth of requests.
+func RateLimitingSampler(spansPerSecond float64) SpanSampler {
+	burst := spansPerSecond
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   spansPerSecond,
+		burst:  burst,
+		tokens: burst,
+		now:    time.Now,
+	}
+}
+
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) Sample(*http.Request) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// MWSpanSampler returns a MWOption that only creates spans for the
+// requests sampled by s, so that the middleware can run on very hot
+// endpoints without overwhelming the tracer backend. Requests forced to
+// VerbosityDebug by MWTracingDecision are always sampled.
+//
+// The span context extracted from a request that is not sampled is
+// still propagated: the request context carries a span that does not
+// record anything but whose Context is the extracted one, so that
+// outgoing requests traced with TraceRequest continue the trace.
+func MWSpanSampler(s SpanSampler) MWOption {
+	return func(options *mwOptions) {
+		options.sampler = s
+	}
+}
+
+// propagationSpan is a span that records nothing and only carries the
+// span context it was created from.
+type propagationSpan struct {
+	opentracing.Span
+	sc opentracing.SpanContext
+}
+
+func newPropagationSpan(sc opentracing.SpanContext) opentracing.Span {
+	return &propagationSpan{Span: opentracing.NoopTracer{}.StartSpan(""), sc: sc}
+}
+
+func (s *propagationSpan) Context() opentracing.SpanContext {
+	return s.sc
+}
@@ -0,0 +1,73 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// SegmentMode tells how Segment records the segments of a request.
+type SegmentMode int
+
+const (
+	// SegmentLogs records every segment as a log on the server span.
+	SegmentLogs SegmentMode = iota
+	// SegmentSpans records every segment as a child span of the server
+	// span.
+	SegmentSpans
+)
+
+// MWSegments returns a MWOption that sets how the segments recorded by
+// Segment within handlers are recorded. The default is SegmentLogs.
+func MWSegments(mode SegmentMode) MWOption {
+	return func(options *mwOptions) {
+		options.segmentMode = mode
+	}
+}
+
+// Segment starts a named segment of the request being served with ctx,
+// such as auth, validation or render, and returns the function ending
+// it. Depending on MWSegments it is recorded as a segment log carrying
+// its duration or as a child span.
+//
+// Example:
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		end := nethttp.Segment(r.Context(), "auth")
+//		authenticate(r)
+//		end()
+//
+//		defer nethttp.Segment(r.Context(), "render")()
+//		...
+//	}
+//
+// Segment does nothing when ctx carries no span or the request was not
+// sampled.
+func Segment(ctx context.Context, name string) func() {
+	sp := opentracing.SpanFromContext(ctx)
+	if sp == nil {
+		return noopSegmentEnd
+	}
+	if _, ok := sp.(*propagationSpan); ok || !isSampled(sp) {
+		return noopSegmentEnd
+	}
+	start := time.Now()
+	if mode, _ := ctx.Value(keySegmentMode).(SegmentMode); mode == SegmentSpans {
+		child := sp.Tracer().StartSpan(name, opentracing.ChildOf(sp.Context()), opentracing.StartTime(start))
+		return child.Finish
+	}
+	return func() {
+		sp.LogFields(
+			log.String("event", "segment"),
+			log.String("segment", name),
+			log.Int64("segment.duration_us", int64(time.Since(start)/time.Microsecond)),
+		)
+	}
+}
+
+func noopSegmentEnd() {}
@@ -4,11 +4,17 @@ package nethttp
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 )
 
 type mwOptions struct {
@@ -20,6 +26,73 @@ type mwOptions struct {
 	spanOnFinish  func(ctx context.Context, span opentracing.Span, r *http.Request) context.Context
 	urlTagFunc    func(u *url.URL) string
 	componentName string
+	decision      *decisionCache
+	anomalies     *anomalyOptions
+	headerSizes   bool
+	allocSampling float64
+	leaks         *leakOptions
+	pressure      *RuntimePressure
+	propagators   []string
+	requestSize   bool
+
+	opNameDecorators    []func(name string, r *http.Request) string
+	panicHandler        func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction
+	errorFunc           func(status int, r *http.Request) bool
+	tagsTemplate        tagsTemplate
+	slo                 *sloOptions
+	patternNames        bool
+	requestHeaders      []string
+	responseHeaders     []string
+	headerRedactor      func(name, value string) string
+	tagAliases          map[string]string
+	baggageKeys         []string
+	sampler             SpanSampler
+	bodyErrors          []BodyErrorMapping
+	spanContextFilter   func(r *http.Request, sc opentracing.SpanContext) bool
+	segmentMode         SegmentMode
+	routes              *RouteOptions
+	keepHijackedSpans   bool
+	timingDetail        bool
+	hijackIdleTimeout   time.Duration
+	hijackMaxLifetime   time.Duration
+	responseTraceHeader *responseTraceHeader
+	defaultTagNames     map[string]string
+	metrics             MetricsRecorder
+	csrf                CSRFValidator
+	slowRequest         *slowRequestOptions
+	spanLifetime        *spanLifetimeOptions
+	extractFunc         func(r *http.Request, tr opentracing.Tracer) (opentracing.SpanContext, error)
+	extractErrorFunc    func(r *http.Request, err error)
+	debugTrace          func(value string) bool
+	previousTraceHeader string
+	tagLimits           *tagLimits
+	pathNormalizer      func(path string) string
+	latencies           *LatencySketches
+	disableAutoError    bool
+	peer                *peerOptions
+	edgeStart           *edgeStartOptions
+	routeNameFunc       func(r *http.Request) string
+	spanOnWriteHeader   func(span opentracing.Span, r *http.Request, status int, t time.Time)
+	bodyHash            *bodyHashOptions
+	accessLog           func(r *http.Request, sp opentracing.Span, opName, route string, status int, size int64, elapsed time.Duration)
+	spanReference       func(r *http.Request, parent opentracing.SpanContext) opentracing.StartSpanOption
+	costAccounting      bool
+	retryAfter          func(r *http.Request, status int) time.Duration
+	handlerSpan         bool
+	spanAfterFinish     func(ctx context.Context, span opentracing.Span, r *http.Request)
+	tracerFunc          func(r *http.Request) opentracing.Tracer
+	htmlTraceMeta       *htmlTraceMeta
+	standardTags        bool
+	rootIDHint          func(r *http.Request) string
+	requestID           *requestIDOptions
+	implicitStatusOK    bool
+	queryRedaction      *queryRedaction
+	errorLogs           bool
+	clockSkew           *clockSkewOptions
+	skipPreflight       bool
+	tagFilter           func(key string, value interface{}) bool
+	asyncFinisher       *AsyncFinisher
+	defaultOptions      []MWOption
 }
 
 // MWOption controls the behavior of the Middleware.
@@ -33,6 +106,71 @@ func OperationNameFunc(f func(r *http.Request) string) MWOption {
 	}
 }
 
+// MWOperationNameFromPattern returns a MWOption that turns on or off
+// naming the server-side spans after the http.ServeMux pattern that
+// matched the request, e.g. "GET /users/{id}". The name is assigned once
+// the handler has returned, since the pattern is only known after
+// routing. Requests that matched no pattern keep the name given by
+// OperationNameFunc, so that the names have a low cardinality.
+// Operation name decorators are applied to the pattern names as well.
+//
+// Patterns are recorded in http.Request.Pattern since Go 1.23 only.
+func MWOperationNameFromPattern(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.patternNames = enabled
+	}
+}
+
+// MWRouteNameFunc returns a MWOption that uses given function f to get
+// the route that matched the request, e.g. "/users/{id}", once the
+// handler has returned. The route is tagged http.route and the span is
+// renamed after it, prefixed with the method unless the route has one,
+// as MWOperationNameFromPattern does. Requests for which f returns ""
+// keep their name.
+//
+// f is the integration point of third-party routers, which record the
+// route in the request context while routing. With chi, whose
+// middlewares run once the route context is set:
+//
+//	r.Use(func(next http.Handler) http.Handler {
+//		return nethttp.Middleware(tracer, next, nethttp.MWRouteNameFunc(func(r *http.Request) string {
+//			return chi.RouteContext(r.Context()).RoutePattern()
+//		}))
+//	})
+//
+// and with gorilla/mux, using mux.CurrentRoute(r).GetPathTemplate() in
+// a middleware registered with Router.Use.
+func MWRouteNameFunc(f func(r *http.Request) string) MWOption {
+	return func(options *mwOptions) {
+		options.routeNameFunc = f
+	}
+}
+
+// MWOperationNameDecorator returns a MWOption that rewrites the
+// operation name produced by OperationNameFunc (or the default one)
+// with f. Decorators are applied in the order the options are given.
+func MWOperationNameDecorator(f func(name string, r *http.Request) string) MWOption {
+	return func(options *mwOptions) {
+		options.opNameDecorators = append(options.opNameDecorators, f)
+	}
+}
+
+// MWOperationPrefix returns a MWOption that prepends prefix to the
+// operation name of every server-side span.
+func MWOperationPrefix(prefix string) MWOption {
+	return MWOperationNameDecorator(func(name string, r *http.Request) string {
+		return prefix + name
+	})
+}
+
+// MWOperationSuffix returns a MWOption that appends suffix to the
+// operation name of every server-side span.
+func MWOperationSuffix(suffix string) MWOption {
+	return MWOperationNameDecorator(func(name string, r *http.Request) string {
+		return name + suffix
+	})
+}
+
 // MWComponentName returns a MWOption that sets the component name
 // for the server-side span.
 func MWComponentName(componentName string) MWOption {
@@ -50,6 +188,166 @@ func MWSpanFilter(f func(r *http.Request) bool) MWOption {
 	}
 }
 
+// MWSpanContextFilter returns a MWOption that filters requests from
+// creating a span based on the request and the span context extracted
+// from it, which is nil if the request carries none. This allows e.g.
+// tracing only the requests whose upstream already started a trace.
+// Span won't be created if it returns false, but the extracted span
+// context is still propagated to the handler as with MWSpanSampler.
+func MWSpanContextFilter(f func(r *http.Request, sc opentracing.SpanContext) bool) MWOption {
+	return func(options *mwOptions) {
+		options.spanContextFilter = f
+	}
+}
+
+// MWHeaderSizeTags returns a MWOption that turns on or off the
+// http.request_header_bytes and http.request_header_count tags, which
+// record the approximate size and the number of the inbound headers.
+func MWHeaderSizeTags(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.headerSizes = enabled
+	}
+}
+
+// MWRequestSizeTag returns a MWOption that turns on or off the
+// http.request_size tag, which records the number of request body bytes
+// actually read by the handler. Unlike the Content-Length header it is
+// also reliable for chunked requests. A failure while reading the body
+// is recorded to the span, see MWBodyErrorMappings.
+func MWRequestSizeTag(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.requestSize = enabled
+	}
+}
+
+// MWErrorFunc returns a MWOption that uses given function f to decide
+// whether the server-side span is tagged as an error, given the status
+// code of the response. The status is 0 if the handler wrote nothing,
+// see MWImplicitStatusOK. By default, status codes >= 500 and 0 are errors.
+func MWErrorFunc(f func(status int, r *http.Request) bool) MWOption {
+	return func(options *mwOptions) {
+		options.errorFunc = f
+	}
+}
+
+// MWImplicitStatusOK returns a MWOption that, if enabled, records status
+// 200 for handlers that return without writing anything, as net/http
+// sends once they returned, so that the span is tagged with the status
+// the client receives and header hooks, such as MWResponseTraceHeader,
+// run. The header is not written by the middleware, so that the
+// middlewares wrapping it may still write another status. By default,
+// the status of such requests is 0 and, with the default MWErrorFunc,
+// they are errors. Handlers that panicked or hijacked the connection
+// are not affected.
+func MWImplicitStatusOK(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.implicitStatusOK = enabled
+	}
+}
+
+// MWDisableAutoError returns a MWOption that stops the middleware from
+// ever setting the error tag itself, for backends that derive errors
+// from the raw http.status_code. Span observers and hooks can still set
+// it. MWErrorFunc keeps deciding which requests fail their SLO, see
+// MWSLOClasses.
+func MWDisableAutoError() MWOption {
+	return func(options *mwOptions) {
+		options.disableAutoError = true
+	}
+}
+
+// MWSpanOnWriteHeader returns a MWOption that calls f when the response
+// headers are about to be sent, with the status code and the current
+// time: when the handler calls WriteHeader, or implicitly on the first
+// write or flush. It is called once per request, and not at all if the
+// handler writes nothing. f can be used to record the processing time
+// apart from the time spent streaming the body, or to set tags or
+// response headers before anything is sent.
+func MWSpanOnWriteHeader(f func(span opentracing.Span, r *http.Request, status int, t time.Time)) MWOption {
+	return func(options *mwOptions) {
+		options.spanOnWriteHeader = f
+	}
+}
+
+// MWSpanReference returns a MWOption that uses given function f to
+// choose how the server-side span references the span context extracted
+// from the request, parent, which is nil if there is none. By default,
+// the span is a child of parent, but requests that do not block their
+// caller, such as webhook deliveries or callbacks of a queue consumer,
+// are better represented with a FollowsFrom reference. If f returns nil,
+// the default reference is used.
+//
+// Example:
+//
+//	nethttp.MWSpanReference(func(r *http.Request, parent opentracing.SpanContext) opentracing.StartSpanOption {
+//		if strings.HasPrefix(r.URL.Path, "/webhooks/") {
+//			return opentracing.FollowsFrom(parent)
+//		}
+//		return nil
+//	})
+func MWSpanReference(f func(r *http.Request, parent opentracing.SpanContext) opentracing.StartSpanOption) MWOption {
+	return func(options *mwOptions) {
+		options.spanReference = f
+	}
+}
+
+// handlerSpanName is the operation name of the spans created by
+// MWHandlerSpan.
+const handlerSpanName = "http.handler"
+
+// MWHandlerSpan returns a MWOption that turns on or off tracing the
+// execution of the handler with a child span of the server-side span,
+// named "http.handler". The server-side span covers the work of the
+// middleware as well, from extracting the span context to writing the
+// response, so that its overhead and the time spent in the handler can
+// be told apart. The handler is given the child span in the request
+// context.
+func MWHandlerSpan(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.handlerSpan = enabled
+	}
+}
+
+// MWStandardTags returns a MWOption that tags the server-side span with
+// metadata of the request: http.user_agent, http.host, http.content_type
+// and http.flavor, the version of the protocol, e.g. "1.1" or "2.0".
+// Tags whose value would be empty are not set.
+func MWStandardTags() MWOption {
+	return func(options *mwOptions) {
+		options.standardTags = true
+	}
+}
+
+// MWSpanAfterFinish returns a MWOption that calls f right after the
+// server-side span is finished, before the response is completed. It is
+// meant for short-lived processes, such as functions or CLIs serving a
+// webhook, which may exit before the tracer reports the span on its
+// own: f can flush the tracer synchronously. Since f delays the end of
+// every request, it should be used with care by long-running servers.
+// The spans of hijacked connections kept open, see MWKeepHijackedSpans,
+// are finished, and f called, when the connection is closed.
+//
+// Example:
+//
+//	nethttp.MWSpanAfterFinish(func(ctx context.Context, span opentracing.Span, r *http.Request) {
+//		reporter.Flush()
+//	})
+func MWSpanAfterFinish(f func(ctx context.Context, span opentracing.Span, r *http.Request)) MWOption {
+	return func(options *mwOptions) {
+		options.spanAfterFinish = f
+	}
+}
+
+// MWTracerFunc returns a MWOption that uses given function f to pick the
+// tracer of every request, e.g. the tracer of the tenant it is sent to,
+// instead of the tracer given to Middleware. That tracer is used when f
+// returns nil.
+func MWTracerFunc(f func(r *http.Request) opentracing.Tracer) MWOption {
+	return func(options *mwOptions) {
+		options.tracerFunc = f
+	}
+}
+
 // MWSpanObserver returns a MWOption that observe the span
 // for the server-side span.
 func MWSpanObserver(f func(span opentracing.Span, r *http.Request)) MWOption {
@@ -74,12 +372,52 @@ func MWSpanOnFinish(f func(ctx context.Context, span opentracing.Span, r *http.R
 	}
 }
 
+// operationName returns the decorated operation name of the span for r.
+func (o *mwOptions) operationName(r *http.Request) string {
+	return o.decorateOperationName(o.opNameFunc(r), r)
+}
+
+func (o *mwOptions) decorateOperationName(name string, r *http.Request) string {
+	for _, decorate := range o.opNameDecorators {
+		name = decorate(name, r)
+	}
+	return name
+}
+
+// tagStandard sets the tags of MWStandardTags on sp.
+func tagStandard(sp opentracing.Span, r *http.Request) {
+	if ua := r.UserAgent(); ua != "" {
+		sp.SetTag("http.user_agent", ua)
+	}
+	if r.Host != "" {
+		sp.SetTag("http.host", r.Host)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		sp.SetTag("http.content_type", ct)
+	}
+	if r.ProtoMajor > 0 {
+		sp.SetTag("http.flavor", strconv.Itoa(r.ProtoMajor)+"."+strconv.Itoa(r.ProtoMinor))
+	}
+}
+
+// patternOperationName returns the operation name for a ServeMux
+// pattern, prefixed with the method unless the pattern has one.
+func patternOperationName(method, pattern string) string {
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 && !strings.Contains(pattern[:i], "/") {
+		return pattern
+	}
+	return method + " " + pattern
+}
+
 func noopObserver(span opentracing.Span, r *http.Request) {}
 func noopHook(ctx context.Context, span opentracing.Span, r *http.Request) context.Context {return ctx}
 
 // MWURLTagFunc returns a MWOption that uses given function f
 // to set the span's http.url tag. Can be used to change the default
 // http.url tag, eg to redact sensitive information.
+//
+// By default, the tag is set to the URL of the request, unless the span
+// is known not to be sampled, which saves formatting the URL.
 func MWURLTagFunc(f func(u *url.URL) string) MWOption {
 	return func(options *mwOptions) {
 		options.urlTagFunc = f
@@ -95,6 +433,14 @@ func MWURLTagFunc(f func(u *url.URL) string) MWOption {
 // Example:
 // 	 http.ListenAndServe("localhost:80", nethttp.Middleware(tracer, http.DefaultServeMux))
 //
+// If tr is the opentracing.NoopTracer, or a tracer marked as recording
+// nothing with an IsNoop method returning true, requests are served
+// untraced, as requests filtered out by MWSpanFilter are, unless
+// MWTracerFunc is given. Unless options that do not depend on tracing,
+// such as MWMetricsRecorder or MWRequestID, are given too, the
+// ResponseWriter is not even wrapped, so that the middleware costs next
+// to nothing when tracing is disabled.
+//
 // The options allow fine tuning the behavior of the middleware.
 //
 // Example:
@@ -109,39 +455,249 @@ func MWURLTagFunc(f func(u *url.URL) string) MWOption {
 //		}),
 //   )
 func Middleware(tr opentracing.Tracer, h http.Handler, options ...MWOption) http.Handler {
-	return MiddlewareFunc(tr, h.ServeHTTP, options...)
+	fn, opts := middlewareFunc(tr, h.ServeHTTP, options)
+	return &tracedHandler{
+		HandlerFunc: fn,
+		tr:          tr,
+		options:     options,
+		opts:        opts,
+	}
 }
 
-// MiddlewareFunc wraps an http.HandlerFunc and traces incoming requests.
-// It behaves identically to the Middleware function above.
+// NewTracingMiddleware returns a function wrapping handlers with
+// Middleware, using tr and options, which is the signature expected by
+// middleware stacks such as alice or chi's Use:
 //
-// Example:
-//   http.ListenAndServe("localhost:80", nethttp.MiddlewareFunc(tracer, MyHandler))
-func MiddlewareFunc(tr opentracing.Tracer, h http.HandlerFunc, options ...MWOption) http.HandlerFunc {
-	opts := mwOptions{
+//	r := chi.NewRouter()
+//	r.Use(nethttp.NewTracingMiddleware(tracer, nethttp.MWComponentName("shop")))
+//
+// See NewMiddleware to check the options first.
+func NewTracingMiddleware(tr opentracing.Tracer, options ...MWOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return Middleware(tr, h, options...)
+	}
+}
+
+// tracedHandler is the handler returned by Middleware, which keeps its
+// options, as given and as resolved when it was created, for
+// ConfigHandler.
+type tracedHandler struct {
+	http.HandlerFunc
+	tr      opentracing.Tracer
+	options []MWOption
+	opts    *mwOptions
+}
+
+// traceAll is the default span filter, tracing every request.
+func traceAll(r *http.Request) bool { return true }
+
+// newMWOptions returns the default options with the options set by
+// SetDefaultMWOptions, then the given options, applied.
+func newMWOptions(options []MWOption) *mwOptions {
+	opts := &mwOptions{
 		opNameFunc: func(r *http.Request) string {
 			return "HTTP " + r.Method
 		},
-		spanFilter:   func(r *http.Request) bool { return true },
+		spanFilter:   traceAll,
 		spanObserver: noopObserver,
 		spanOnStart:  noopHook,
 		spanOnFinish: noopHook,
-		urlTagFunc: func(u *url.URL) string {
-			return u.String()
+		errorFunc: func(status int, r *http.Request) bool {
+			return status >= http.StatusInternalServerError || status == 0
 		},
+		headerRedactor: DefaultHeaderRedactor,
+	}
+	opts.defaultOptions = getDefaultMWOptions()
+	for _, opt := range opts.defaultOptions {
+		opt(opts)
 	}
 	for _, opt := range options {
-		opt(&opts)
+		opt(opts)
+	}
+	return opts
+}
+
+// MiddlewareFunc wraps an http.HandlerFunc and traces incoming requests.
+// It behaves identically to the Middleware function above.
+//
+// Example:
+//   http.ListenAndServe("localhost:80", nethttp.MiddlewareFunc(tracer, MyHandler))
+func MiddlewareFunc(tr opentracing.Tracer, h http.HandlerFunc, options ...MWOption) http.HandlerFunc {
+	fn, _ := middlewareFunc(tr, h, options)
+	return fn
+}
+
+// middlewareFunc returns MiddlewareFunc(tr, h, options...) and the
+// options it resolved.
+func middlewareFunc(tr opentracing.Tracer, h http.HandlerFunc, options []MWOption) (http.HandlerFunc, *mwOptions) {
+	opts := newMWOptions(options)
+	var routes []route
+	if opts.routes != nil {
+		routes = opts.routes.resolve(options)
+	}
+	if opts.tracerFunc == nil && isNoopTracer(tr) {
+		if routes == nil && !opts.untracedTracking() {
+			return h, opts
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			opts := opts
+			if o := matchRoute(routes, r.URL.Path); o != nil {
+				opts = o
+			}
+			serveUntraced(opts, h, w, r)
+		}, opts
 	}
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		opts := opts
+		if o := matchRoute(routes, r.URL.Path); o != nil {
+			opts = o
+		}
 		if !opts.spanFilter(r) {
-			h(w, r)
+			serveUntraced(opts, h, w, r)
+			return
+		}
+		verbosity := VerbosityDefault
+		debug := opts.debugTrace != nil && isDebugTrace(r, opts.debugTrace)
+		if debug {
+			verbosity = VerbosityDebug
+		} else if opts.decision != nil {
+			verbosity = opts.decision.verbosity(r)
+		}
+		if verbosity == VerbosityOff {
+			serveUntraced(opts, h, w, r)
 			return
 		}
-		spanCtx, _ := tr.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
-		sp := tr.StartSpan(opts.opNameFunc(r), ext.RPCServerOption(spanCtx))
-		ext.HTTPMethod.Set(sp, r.Method)
-		ext.HTTPUrl.Set(sp, opts.urlTagFunc(r.URL))
+		start := time.Now()
+		tr := tr
+		if opts.tracerFunc != nil {
+			if t := opts.tracerFunc(r); t != nil {
+				tr = t
+			}
+		}
+		var spanCtx opentracing.SpanContext
+		var err error
+		if opts.extractFunc != nil {
+			spanCtx, err = opts.extractFunc(r, tr)
+		} else {
+			spanCtx, err = extractSpanContext(tr, r.Header, opts.propagators)
+		}
+		if err != nil {
+			if opts.extractErrorFunc != nil && err != opentracing.ErrSpanContextNotFound {
+				opts.extractErrorFunc(r, err)
+			}
+			spanCtx = nil
+		}
+		if (opts.skipPreflight && isPreflight(r)) ||
+			(opts.spanContextFilter != nil && !opts.spanContextFilter(r, spanCtx)) ||
+			(opts.sampler != nil && verbosity != VerbosityDebug && !opts.sampler.Sample(r)) {
+			if spanCtx != nil {
+				r = r.WithContext(opentracing.ContextWithSpan(r.Context(), newPropagationSpan(spanCtx)))
+			}
+			serveUntraced(opts, h, w, r)
+			return
+		}
+		opName := opts.operationName(r)
+		spanStart := start
+		var edge time.Time
+		var fromEdge bool
+		if opts.edgeStart != nil {
+			if edge, fromEdge = edgeStart(r, start); fromEdge && opts.edgeStart.startSpan {
+				spanStart = edge
+			}
+		}
+		_, renameKind := opts.defaultTagNames[string(ext.SpanKind)]
+		var ref opentracing.StartSpanOption
+		if opts.spanReference != nil {
+			ref = opts.spanReference(r, spanCtx)
+		}
+		// span.kind is set as a tag rather than with RPCServerOption when
+		// it must go through MWDefaultTagNames or MWTagFilter
+		setKind := renameKind || ref != nil || opts.tagFilter != nil
+		if ref == nil {
+			if setKind {
+				ref = opentracing.ChildOf(spanCtx)
+			} else {
+				ref = ext.RPCServerOption(spanCtx)
+			}
+		}
+		var sp opentracing.Span
+		var hint opentracing.StartSpanOption
+		if spanCtx == nil {
+			hint = rootIDHint(opts.rootIDHint, r)
+		}
+		if opts.tagFilter != nil {
+			hint = filterStartOption(hint, opts.tagFilter)
+		}
+		if hint != nil {
+			sp = tr.StartSpan(opName, ref, opentracing.StartTime(spanStart), hint)
+		} else {
+			sp = tr.StartSpan(opName, ref, opentracing.StartTime(spanStart))
+		}
+		// the filter wraps the span first, so that it sees every tag,
+		// including the aliases of MWDualTagNames
+		if opts.tagFilter != nil {
+			sp = newFilterSpan(sp, opts.tagFilter)
+		}
+		if len(opts.tagAliases) > 0 {
+			sp = &aliasSpan{Span: sp, aliases: opts.tagAliases}
+		}
+		if opts.tagLimits != nil {
+			sp = newLimitSpan(sp, opts.tagLimits)
+		}
+		if opts.spanLifetime != nil {
+			sp = opts.spanLifetime.watch(sp, r)
+		}
+		if setKind {
+			opts.setDefaultTag(sp, string(ext.SpanKind), ext.SpanKindRPCServerEnum)
+		}
+		if verbosity == VerbosityDebug {
+			ext.SamplingPriority.Set(sp, 1)
+			sp.SetTag("trace.verbosity", "debug")
+		}
+		opts.setDefaultTag(sp, string(ext.HTTPMethod), r.Method)
+		u := r.URL
+		if opts.pathNormalizer != nil {
+			u = normalizeURL(u, opts.pathNormalizer)
+		}
+		if opts.queryRedaction != nil {
+			u = opts.queryRedaction.redact(u)
+		}
+		if opts.urlTagFunc != nil {
+			opts.setDefaultTag(sp, string(ext.HTTPUrl), opts.urlTagFunc(u))
+		} else if isSampled(sp) {
+			opts.setDefaultTag(sp, string(ext.HTTPUrl), u.String())
+		}
+		if opts.peer != nil {
+			opts.peer.tag(sp, r)
+		}
+		if opts.standardTags {
+			tagStandard(sp, r)
+		}
+		if fromEdge {
+			sp.SetTag("queue.edge_duration_us", int64(start.Sub(edge)/time.Microsecond))
+		}
+		if opts.clockSkew != nil {
+			opts.clockSkew.tag(sp, r, start)
+		}
+		tagConnSequence(r.Context(), sp)
+		if opts.pressure != nil {
+			opts.pressure.tag(sp)
+		}
+		if opts.headerSizes {
+			size, count := headerSize(r.Header)
+			sp.SetTag("http.request_header_bytes", size)
+			sp.SetTag("http.request_header_count", count)
+		}
+		tagHeaders(sp, "http.request.header.", r.Header, opts.requestHeaders, opts.headerRedactor)
+		opts.tagsTemplate.apply(sp, r)
+		if opts.previousTraceHeader != "" {
+			tagPreviousTrace(sp, r, opts.previousTraceHeader)
+		}
+		if opts.csrf != nil && !isSafeMethod(r.Method) {
+			present, valid := opts.csrf(r)
+			sp.SetTag("csrf.present", present)
+			sp.SetTag("csrf.valid", valid)
+		}
 		opts.spanObserver(sp, r)
 		ctx := r.Context()
 		ctx = opts.spanOnStart(ctx, sp, r)
@@ -151,21 +707,340 @@ func MiddlewareFunc(tr opentracing.Tracer, h http.HandlerFunc, options ...MWOpti
 		if componentName == "" {
 			componentName = defaultComponentName
 		}
-		ext.Component.Set(sp, componentName)
+		opts.setDefaultTag(sp, string(ext.Component), componentName)
 
-		sct := &statusCodeTracker{ResponseWriter: w}
-		r = r.WithContext(opentracing.ContextWithSpan(r.Context(), sp))
+		sct := newStatusCodeTracker(w)
+		sct.sp = sp
+		if opts.responseTraceHeader != nil {
+			sct.headerHooks = append(sct.headerHooks, opts.responseTraceHeader.hook(sp, w))
+		}
+		if len(opts.responseHeaders) > 0 {
+			sct.headerHooks = append(sct.headerHooks, func(int) {
+				tagHeaders(sp, "http.response.header.", w.Header(), opts.responseHeaders, opts.headerRedactor)
+			})
+		}
+		if opts.retryAfter != nil {
+			sct.headerHooks = append(sct.headerHooks, func(status int) {
+				adviseRetryAfter(sp, w, r, status, opts.retryAfter)
+			})
+		}
+		if opts.htmlTraceMeta != nil {
+			sct.headerHooks = append(sct.headerHooks, opts.htmlTraceMeta.hook(sp, w, sct))
+		}
+		if opts.spanOnWriteHeader != nil {
+			sct.headerHooks = append(sct.headerHooks, func(status int) {
+				opts.spanOnWriteHeader(sp, r, status, time.Now())
+			})
+		}
+		// the request is copied once, with all the values it carries
+		reqCtx := opentracing.ContextWithSpan(r.Context(), sp)
+		if debug {
+			reqCtx = context.WithValue(reqCtx, keyDebugTrace, true)
+		}
+		if opts.requestID != nil {
+			reqCtx = opts.requestID.start(reqCtx, sp, r, w, sct)
+		}
+		if len(opts.baggageKeys) > 0 {
+			reqCtx = contextWithBaggage(reqCtx, sp, opts.baggageKeys)
+		}
+		if opts.segmentMode != SegmentLogs {
+			reqCtx = context.WithValue(reqCtx, keySegmentMode, opts.segmentMode)
+		}
+		var reqCosts *costs
+		if opts.costAccounting {
+			reqCtx, reqCosts = contextWithCosts(reqCtx)
+		}
+		r = r.WithContext(reqCtx)
+		var body *bodyTracker
+		if (opts.requestSize || len(opts.bodyErrors) > 0 || debug) && r.Body != nil {
+			body = &bodyTracker{ReadCloser: r.Body, sp: sp, mappings: opts.bodyErrors, autoError: !opts.disableAutoError}
+			if debug {
+				body.captureLimit = maxDebugBodyBytes
+			}
+			r.Body = body
+		}
+		if opts.bodyHash != nil {
+			sct.bodyHash = opts.bodyHash.newHash()
+		}
+		var tagTiming func()
+		if opts.timingDetail {
+			tagTiming = trackTiming(sp, sct, start)
+		}
+		var hijack *hijackFinisher
+		if opts.keepHijackedSpans {
+			var afterFinish func()
+			if opts.spanAfterFinish != nil {
+				afterFinish = func() { opts.spanAfterFinish(ctx, sp, r) }
+			}
+			hijack = newHijackFinisher(sp, afterFinish)
+			sct.hijackHook = func(conn net.Conn) net.Conn {
+				return newHijackedConn(conn, sp, hijack.done, opts.hijackIdleTimeout, opts.hijackMaxLifetime)
+			}
+		}
 
 		defer func() {
-			ext.HTTPStatusCode.Set(sp, uint16(sct.status))
-			if sct.status >= http.StatusInternalServerError || !sct.wroteheader {
+			route := requestPattern(r)
+			if opts.patternNames && route != "" {
+				opName = opts.decorateOperationName(patternOperationName(r.Method, route), r)
+				sp.SetOperationName(opName)
+			}
+			if opts.routeNameFunc != nil {
+				if name := opts.routeNameFunc(r); name != "" {
+					route = name
+					sp.SetTag("http.route", route)
+					opName = opts.decorateOperationName(patternOperationName(r.Method, route), r)
+					sp.SetOperationName(opName)
+				}
+			}
+			// panics are recovered to be told apart, and panic again once
+			// the span is finished unless the panic handler swallows them
+			var repanic interface{}
+			recovered := recover()
+			if recovered != nil {
+				switch {
+				case isAbortHandler(recovered):
+					sp.SetTag("http.aborted", true)
+					repanic = recovered
+				case opts.panicHandler != nil:
+					if handlePanic(sp, sct, r, recovered, opts.panicHandler, !opts.disableAutoError) == PanicRepanic {
+						repanic = recovered
+					}
+				default:
+					if opts.errorLogs {
+						logPanic(sp, recovered)
+					}
+					repanic = recovered
+				}
+			}
+			tagCancellation(sp, r)
+			if sct.hijacked {
+				sp.SetTag("http.hijacked", true)
+				if sct.status == 0 && isUpgrade(r) {
+					sct.status = http.StatusSwitchingProtocols
+				}
+			}
+			sct.flushHTML()
+			opts.setDefaultTag(sp, string(ext.HTTPStatusCode), uint16(sct.status))
+			sp.SetTag("http.response_size", sct.size)
+			if sct.superfluous > 0 {
+				sp.SetTag("http.superfluous_write_header", sct.superfluous)
+				sp.SetTag("http.superfluous_status_code", uint16(sct.superfluousStatus))
+			}
+			if sct.bodyHash != nil && !sct.hijacked {
+				sp.SetTag("http.response.body_hash", opts.bodyHash.tag(sct.bodyHash))
+			}
+			if tagTiming != nil {
+				tagTiming()
+			}
+			if body != nil && r.Body != io.ReadCloser(body) {
+				body.recordReplacedError(r.Body)
+			}
+			if opts.requestSize && body != nil {
+				sp.SetTag("http.request_size", body.n)
+			}
+			if debug && body != nil {
+				logDebugBody(sp, body)
+			}
+			if reqCosts != nil {
+				reqCosts.tag(sp)
+			}
+			isError := !(sct.hijacked && sct.status == 0) && opts.errorFunc(sct.status, r)
+			if isError && !opts.disableAutoError {
 				ext.Error.Set(sp, true)
+				if opts.errorLogs && recovered == nil {
+					logStatusError(sp, sct.status)
+				}
+			}
+			if opts.slo != nil {
+				opts.slo.tag(sp, opName, isError || repanic != nil, time.Since(start))
+			}
+			if opts.metrics != nil {
+				requestBytes := requestBodySize(r)
+				if body != nil {
+					requestBytes = body.n
+				}
+				recordServerMetrics(opts.metrics, r, opName, sct, requestBytes, time.Since(start))
+			}
+			if opts.latencies != nil {
+				opts.latencies.observe(opName, time.Since(start), sct.size)
+			}
+			if opts.accessLog != nil {
+				opts.accessLog(r, sp, opName, route, sct.status, sct.size, time.Since(start))
 			}
 			opts.spanOnFinish(ctx, sp, r)
-			sp.Finish()
+			if hijack != nil && sct.hijacked {
+				hijack.done()
+			} else if opts.asyncFinisher != nil {
+				var afterFinish func()
+				if opts.spanAfterFinish != nil {
+					afterFinish = func() { opts.spanAfterFinish(ctx, sp, r) }
+				}
+				opts.asyncFinisher.finish(sp, afterFinish)
+			} else {
+				sp.Finish()
+				if opts.spanAfterFinish != nil {
+					opts.spanAfterFinish(ctx, sp, r)
+				}
+			}
+			sct.release()
+			if repanic != nil {
+				panic(repanic)
+			}
 		}()
 
+		if opts.anomalies != nil {
+			if found := protocolAnomalies(r, opts.anomalies.maxHeaderBytes); len(found) > 0 {
+				sp.SetTag("http.anomaly", true)
+				sp.SetTag("http.anomalies", strings.Join(found, ","))
+				if opts.anomalies.reject {
+					http.Error(sct, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		if opts.slowRequest != nil {
+			defer opts.slowRequest.watch(sp, r)()
+		}
+		if sampled(opts.allocSampling) {
+			defer trackAllocations(sp)()
+		}
+		if opts.leaks != nil && sampled(opts.leaks.fraction) {
+			defer trackGoroutines(sp, opts.leaks.dumpStacks)()
+		}
+
+		if opts.handlerSpan {
+			hsp := tr.StartSpan(handlerSpanName, opentracing.ChildOf(sp.Context()))
+			ctx := context.WithValue(r.Context(), keyServerSpan, sp)
+			r = r.WithContext(opentracing.ContextWithSpan(ctx, hsp))
+			defer hsp.Finish()
+		}
 		h(sct.wrappedResponseWriter(), r)
+		if opts.implicitStatusOK {
+			sct.recordImplicitStatus()
+		}
+	}
+	return http.HandlerFunc(fn), opts
+}
+
+// serveUntraced serves r with h without span, giving it an ID, advising
+// when to retry and recording its metrics, latency and access log if
+// enabled.
+func serveUntraced(opts *mwOptions, h http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	if !opts.untracedTracking() {
+		h(w, r)
+		return
+	}
+	opName := opts.operationName(r)
+	start := time.Now()
+	sct := newStatusCodeTracker(w)
+	defer sct.release()
+	sp := opentracing.SpanFromContext(r.Context())
+	if opts.requestID != nil {
+		r = r.WithContext(opts.requestID.start(r.Context(), sp, r, w, sct))
+	}
+	if opts.retryAfter != nil {
+		sct.headerHooks = append(sct.headerHooks, func(status int) {
+			adviseRetryAfter(sp, w, r, status, opts.retryAfter)
+		})
+	}
+	h(sct.wrappedResponseWriter(), r)
+	if opts.implicitStatusOK {
+		sct.recordImplicitStatus()
+	}
+	elapsed := time.Since(start)
+	if opts.metrics != nil {
+		recordServerMetrics(opts.metrics, r, opName, sct, requestBodySize(r), elapsed)
+	}
+	if opts.latencies != nil {
+		opts.latencies.observe(opName, elapsed, sct.size)
+	}
+	if opts.accessLog != nil {
+		route := requestPattern(r)
+		if opts.routeNameFunc != nil {
+			if name := opts.routeNameFunc(r); name != "" {
+				route = name
+			}
+		}
+		opts.accessLog(r, sp, opName, route, sct.status, sct.size, elapsed)
+	}
+}
+
+// untracedTracking reports whether requests served without span still
+// need to be tracked, for options whose behavior does not depend on
+// tracing.
+func (o *mwOptions) untracedTracking() bool {
+	return o.metrics != nil || o.latencies != nil || o.accessLog != nil ||
+		o.requestID != nil || o.retryAfter != nil || o.implicitStatusOK
+}
+
+// isNoopTracer reports whether tr records nothing: the NoopTracer, or a
+// tracer with an IsNoop method returning true.
+func isNoopTracer(tr opentracing.Tracer) bool {
+	switch t := tr.(type) {
+	case opentracing.NoopTracer, *opentracing.NoopTracer:
+		return true
+	case interface{ IsNoop() bool }:
+		return t.IsNoop()
+	}
+	return false
+}
+
+// isSampled reports whether sp is recorded, as far as can be told: spans
+// of the NoopTracer are not, and neither are those whose context reports
+// it with an IsSampled method, as Jaeger's does. Other spans are assumed
+// to be.
+func isSampled(sp opentracing.Span) bool {
+	if _, ok := sp.Tracer().(opentracing.NoopTracer); ok {
+		return false
+	}
+	if sc, ok := sp.Context().(interface{ IsSampled() bool }); ok {
+		return sc.IsSampled()
+	}
+	return true
+}
+
+// bodyTracker counts the bytes read from a request body and records the
+// first read error to the span.
+type bodyTracker struct {
+	io.ReadCloser
+	sp       opentracing.Span
+	n        int64
+	err      error
+	mappings []BodyErrorMapping
+	// autoError is true if matching mappings may tag the span as an
+	// error.
+	autoError bool
+
+	// captureLimit is the number of bytes to keep in captured.
+	captureLimit int
+	captured     []byte
+}
+
+func (b *bodyTracker) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.captureLimit - len(b.captured); room > 0 {
+		if room > n {
+			room = n
+		}
+		b.captured = append(b.captured, p[:room]...)
+	}
+	b.n += int64(n)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.recordError(err)
+	}
+	return n, err
+}
+
+func (b *bodyTracker) recordError(err error) {
+	b.err = err
+	if recordBodyError(b.sp, err, b.mappings, b.autoError) {
+		return
 	}
-	return http.HandlerFunc(fn)
+	b.sp.LogFields(
+		log.String("event", "error"),
+		log.String("message", "request body read failed"),
+		log.Int64("http.request_size", b.n),
+		log.Error(err),
+	)
 }
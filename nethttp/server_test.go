@@ -1,15 +1,37 @@
 package nethttp
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 	"github.com/opentracing/opentracing-go/mocktracer"
 )
 
@@ -27,6 +49,8 @@ func TestOperationNameOption(t *testing.T) {
 	}{
 		{nil, "HTTP GET"},
 		{[]MWOption{OperationNameFunc(fn)}, "HTTP GET: /root"},
+		{[]MWOption{MWOperationPrefix("api.")}, "api.HTTP GET"},
+		{[]MWOption{OperationNameFunc(fn), MWOperationPrefix("api."), MWOperationSuffix(" (v2)")}, "api.HTTP GET: /root (v2)"},
 	}
 
 	for _, tt := range tests {
@@ -54,6 +78,236 @@ func TestOperationNameOption(t *testing.T) {
 	}
 }
 
+func TestMWOperationPerHost(t *testing.T) {
+	names := map[string]string{
+		"shop.example.com":    "shop",
+		"*.admin.example.com": "admin",
+		"*.example.com":       "example",
+	}
+	tests := []struct {
+		host   string
+		opName string
+	}{
+		{"shop.example.com", "shop HTTP GET"},
+		{"SHOP.example.com.:8080", "shop HTTP GET"},
+		{"eu.admin.example.com", "admin HTTP GET"},
+		{"blog.example.com", "example HTTP GET"},
+		{"example.com", "HTTP GET"},
+		{"[::1]:8080", "HTTP GET"},
+	}
+	for _, tt := range tests {
+		tr := &mocktracer.MockTracer{}
+		mw := Middleware(tr, http.NotFoundHandler(), MWOperationPerHost(names))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = tt.host
+		mw.ServeHTTP(httptest.NewRecorder(), r)
+		if got := tr.FinishedSpans()[0].OperationName; got != tt.opName {
+			t.Fatalf("got operation name %q for host %q, expected %q", got, tt.host, tt.opName)
+		}
+	}
+
+	names["*"] = "other"
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.NotFoundHandler(), MWOperationPerHost(names))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "localhost:8080"
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	if got, want := tr.FinishedSpans()[0].OperationName, "other HTTP GET"; got != want {
+		t.Fatalf("got operation name %q, expected %q", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for an invalid host")
+		}
+	}()
+	MWOperationPerHost(map[string]string{"shop.*": "shop"})
+}
+
+func TestMWStandardTags(t *testing.T) {
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.NotFoundHandler(), MWStandardTags())
+	r := httptest.NewRequest("POST", "http://shop.example.com/cart", strings.NewReader("{}"))
+	r.Header.Set("User-Agent", "test/1.0")
+	r.Header.Set("Content-Type", "application/json")
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	sp := tr.FinishedSpans()[0]
+	expected := map[string]interface{}{
+		"http.user_agent":   "test/1.0",
+		"http.host":         "shop.example.com",
+		"http.content_type": "application/json",
+		"http.flavor":       "1.1",
+	}
+	for name, want := range expected {
+		if got := sp.Tag(name); got != want {
+			t.Fatalf("got %s tag %v, expected %v", name, got, want)
+		}
+	}
+
+	tr.Reset()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Del("User-Agent")
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	for _, name := range []string{"http.user_agent", "http.content_type"} {
+		if got := tr.FinishedSpans()[0].Tag(name); got != nil {
+			t.Fatalf("got %s tag %v, expected none", name, got)
+		}
+	}
+}
+
+func TestMWRootIDHint(t *testing.T) {
+	tr := mocktracer.New()
+	mw := Middleware(tr, http.NotFoundHandler(), MWRootIDHint(func(r *http.Request) string {
+		return r.Header.Get("X-Request-Id")
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	if got, want := tr.FinishedSpans()[0].Tag(RootIDHintTag), "abc"; got != want {
+		t.Fatalf("got hint %v, expected %v", got, want)
+	}
+
+	tr.Reset()
+	r = httptest.NewRequest("GET", "/", nil)
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	if got := tr.FinishedSpans()[0].Tag(RootIDHintTag); got != nil {
+		t.Fatalf("got hint %v for an empty hint, expected none", got)
+	}
+
+	tr.Reset()
+	parent := tr.StartSpan("parent")
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	if got := tr.FinishedSpans()[0].Tag(RootIDHintTag); got != nil {
+		t.Fatalf("got hint %v for a child span, expected none", got)
+	}
+}
+
+func TestMWRequestID(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Request-Id", r.Header.Get("X-Request-Id"))
+	}))
+	defer backend.Close()
+
+	tr := mocktracer.New()
+	var fromContext, fromHeader, fromBackend string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		fromContext = RequestIDFromContext(r.Context())
+		fromHeader = r.Header.Get("X-Request-Id")
+		req, _ := http.NewRequest("GET", backend.URL, nil)
+		req, ht := TraceRequest(tr, req.WithContext(r.Context()), ClientTrace(false))
+		resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		ht.Finish()
+		fromBackend = resp.Header.Get("X-Backend-Request-Id")
+		io.WriteString(w, "ok")
+	}
+	generate := func() string { return "generated" }
+	srv := httptest.NewServer(MiddlewareFunc(tr, h, MWRequestID("", generate)))
+	defer srv.Close()
+
+	tests := []struct {
+		incoming string
+		expected string
+	}{
+		{"abc-123", "abc-123"},
+		{"", "generated"},
+		{strings.Repeat("x", 129), "generated"},
+		{"caf\xc3\xa9", "generated"},
+	}
+	for _, tt := range tests {
+		tr.Reset()
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-Id", tt.incoming)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		for name, got := range map[string]string{
+			"context":  fromContext,
+			"header":   fromHeader,
+			"backend":  fromBackend,
+			"response": resp.Header.Get("X-Request-Id"),
+		} {
+			if got != tt.expected {
+				t.Fatalf("got %s request ID %q for %q, expected %q", name, got, tt.incoming, tt.expected)
+			}
+		}
+		spans := tr.FinishedSpans()
+		if got := spans[len(spans)-1].Tag("http.request_id"); got != tt.expected {
+			t.Fatalf("got http.request_id tag %v, expected %q", got, tt.expected)
+		}
+	}
+
+	if id := randomRequestID(); len(id) != 32 || id == randomRequestID() {
+		t.Fatalf("got random request ID %q", id)
+	}
+	if got := RequestIDFromContext(context.Background()); got != "" {
+		t.Fatalf("got request ID %q without one", got)
+	}
+}
+
+func TestMWRequestIDUntraced(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Request-Id", r.Header.Get("X-Request-Id"))
+	}))
+	defer backend.Close()
+
+	generate := func() string { return "generated" }
+	tests := []struct {
+		name    string
+		tr      opentracing.Tracer
+		options []MWOption
+	}{
+		{"not sampled", mocktracer.New(), []MWOption{MWSpanSampler(ProbabilisticSampler(0))}},
+		{"filtered out", mocktracer.New(), []MWOption{MWSpanFilter(func(r *http.Request) bool { return false })}},
+		{"noop tracer", opentracing.NoopTracer{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromContext, fromBackend string
+			h := func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestIDFromContext(r.Context())
+				req, _ := http.NewRequest("GET", backend.URL, nil)
+				req, ht := TraceRequest(tt.tr, req.WithContext(r.Context()), ClientTrace(false))
+				resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+				ht.Finish()
+				fromBackend = resp.Header.Get("X-Backend-Request-Id")
+				io.WriteString(w, "ok")
+			}
+			rec := httptest.NewRecorder()
+			MiddlewareFunc(tt.tr, h, append(tt.options, MWRequestID("", generate))...).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			for name, got := range map[string]string{
+				"context":  fromContext,
+				"backend":  fromBackend,
+				"response": rec.Header().Get("X-Request-Id"),
+			} {
+				if got != "generated" {
+					t.Fatalf("got %s request ID %q, expected generated", name, got)
+				}
+			}
+		})
+	}
+}
+
 func TestSpanObserverOption(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/root", func(w http.ResponseWriter, r *http.Request) {})
@@ -113,7 +367,7 @@ func TestSpanObserverOption(t *testing.T) {
 				t.Fatalf("got %s operation name, expected %s", got, want)
 			}
 
-			defaultLength := 5
+			defaultLength := 6
 			if len(spans[0].Tags()) != len(testCase.Tags)+defaultLength {
 				t.Fatalf("got tag length %d, expected %d", len(spans[0].Tags()), len(testCase.Tags))
 			}
@@ -177,6 +431,76 @@ func TestSpanFilterOption(t *testing.T) {
 	}
 }
 
+func TestSpanFilterHelpers(t *testing.T) {
+	request := func(method, path, userAgent string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("User-Agent", userAgent)
+		return r
+	}
+	skipHealth := SkipPaths("/healthz", "/metrics")
+	skipProbes := SkipUserAgentPrefixes("kube-probe", "ELB-HealthChecker")
+	skipOptions := SkipMethods("OPTIONS")
+
+	tests := []struct {
+		name     string
+		filter   func(r *http.Request) bool
+		request  *http.Request
+		expected bool
+	}{
+		{"skipped path", skipHealth, request("GET", "/metrics", ""), false},
+		{"path prefix", skipHealth, request("GET", "/metrics/x", ""), true},
+		{"skipped user agent", skipProbes, request("GET", "/", "kube-probe/1.12"), false},
+		{"other user agent", skipProbes, request("GET", "/", "PostmanRuntime/7.3.0"), true},
+		{"skipped method", skipOptions, request("OPTIONS", "/", ""), false},
+		{"other method", skipOptions, request("GET", "/", ""), true},
+		{"all traced", AllFilters(skipHealth, skipOptions), request("GET", "/", ""), true},
+		{"all skipped by one", AllFilters(skipHealth, skipOptions), request("OPTIONS", "/", ""), false},
+		{"all empty", AllFilters(), request("GET", "/", ""), true},
+		{"any traced by one", AnyFilter(skipHealth, skipProbes), request("GET", "/healthz", "curl/8.0"), true},
+		{"any skipped", AnyFilter(skipHealth, skipProbes), request("GET", "/healthz", "kube-probe/1.12"), false},
+		{"any empty", AnyFilter(), request("GET", "/", ""), false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter(tt.request); got != tt.expected {
+			t.Fatalf("%s: got %t, expected %t", tt.name, got, tt.expected)
+		}
+	}
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.NotFoundHandler(), MWSpanFilter(AllFilters(skipHealth, skipProbes)))
+	mw.ServeHTTP(httptest.NewRecorder(), request("GET", "/healthz", ""))
+	mw.ServeHTTP(httptest.NewRecorder(), request("GET", "/", "kube-probe/1.12"))
+	mw.ServeHTTP(httptest.NewRecorder(), request("GET", "/", ""))
+	if got, want := len(tr.FinishedSpans()), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+}
+
+func TestSpanContextFilterOption(t *testing.T) {
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+	traced := httptest.NewRequest("GET", "/", nil)
+	if err := tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(traced.Header)); err != nil {
+		t.Fatal(err)
+	}
+	untraced := httptest.NewRequest("GET", "/", nil)
+
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		MWSpanContextFilter(func(r *http.Request, sc opentracing.SpanContext) bool {
+			return sc != nil
+		}))
+	mw.ServeHTTP(httptest.NewRecorder(), untraced)
+	mw.ServeHTTP(httptest.NewRecorder(), traced)
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, want := spans[0].ParentID, parent.Context().(mocktracer.MockSpanContext).SpanID; got != want {
+		t.Fatalf("got parent %d, expected %d", got, want)
+	}
+}
+
 func TestURLTagOption(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/root", func(w http.ResponseWriter, r *http.Request) {})
@@ -267,6 +591,51 @@ func TestSpanError(t *testing.T) {
 	}
 }
 
+func TestErrorFuncOption(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, status := range []int{200, 404, 499, 500, 501} {
+		status := status
+		mux.HandleFunc(fmt.Sprintf("/%d", status), func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+	}
+	errorFn := func(status int, r *http.Request) bool {
+		return status == 499 || (status >= 500 && status != http.StatusNotImplemented)
+	}
+
+	tests := []struct {
+		url     string
+		isError bool
+	}{
+		{"/200", false},
+		{"/404", false},
+		{"/499", true},
+		{"/500", true},
+		{"/501", false},
+	}
+
+	for _, tt := range tests {
+		testCase := tt
+		t.Run(testCase.url, func(t *testing.T) {
+			tr := &mocktracer.MockTracer{}
+			srv := httptest.NewServer(Middleware(tr, mux, MWErrorFunc(errorFn)))
+			defer srv.Close()
+
+			if _, err := http.Get(srv.URL + testCase.url); err != nil {
+				t.Fatalf("server returned error: %v", err)
+			}
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			actualErr, _ := spans[0].Tag(string(ext.Error)).(bool)
+			if actualErr != testCase.isError {
+				t.Fatalf("got span error %v, expected %v", actualErr, testCase.isError)
+			}
+		})
+	}
+}
+
 func BenchmarkStatusCodeTrackingOverhead(b *testing.B) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/root", func(w http.ResponseWriter, r *http.Request) {})
@@ -289,6 +658,171 @@ func BenchmarkStatusCodeTrackingOverhead(b *testing.B) {
 	})
 }
 
+func benchmarkMiddleware(b *testing.B, tr opentracing.Tracer) {
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	r := httptest.NewRequest("GET", "/root?q=1", nil)
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mw.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkMiddlewareNoopTracer(b *testing.B) {
+	benchmarkMiddleware(b, opentracing.NoopTracer{})
+}
+
+func BenchmarkMiddlewareMockTracer(b *testing.B) {
+	tr := mocktracer.New()
+	benchmarkMiddleware(b, tr)
+}
+
+// BenchmarkHandler is the baseline of BenchmarkMiddlewareNoopTracer.
+func BenchmarkHandler(b *testing.B) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	r := httptest.NewRequest("GET", "/root?q=1", nil)
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(w, r)
+	}
+}
+
+type markedNoopTracer struct {
+	opentracing.NoopTracer
+}
+
+func (markedNoopTracer) IsNoop() bool { return true }
+
+func TestMiddlewareNoopTracerFastPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		tr      opentracing.Tracer
+		options []MWOption
+		wrapped bool
+	}{
+		{"noop", opentracing.NoopTracer{}, nil, false},
+		{"marked noop", markedNoopTracer{}, nil, false},
+		{"noop with latencies", opentracing.NoopTracer{}, []MWOption{MWLatencySketches(NewLatencySketches(time.Minute, 10))}, true},
+		{"noop with request ID", opentracing.NoopTracer{}, []MWOption{MWRequestID("", nil)}, true},
+		{"noop with implicit status", opentracing.NoopTracer{}, []MWOption{MWImplicitStatusOK(true)}, true},
+		{"mock", mocktracer.New(), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			var got http.ResponseWriter
+			mw := Middleware(tt.tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = w
+			}), tt.options...)
+			mw.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if wrapped := got != http.ResponseWriter(rec); wrapped != tt.wrapped {
+				t.Fatalf("got wrapped ResponseWriter %v, expected %v", wrapped, tt.wrapped)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRecyclesTrackers(t *testing.T) {
+	mw := Middleware(mocktracer.New(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), MWTimingDetail(true))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusTeapot {
+			t.Fatalf("got status %d, expected %d", w.Code, http.StatusTeapot)
+		}
+	}
+
+	sct := newStatusCodeTracker(httptest.NewRecorder())
+	sct.headerHooks = append(sct.headerHooks, func(int) {})
+	sct.WriteHeader(http.StatusTeapot)
+	sct.release()
+	sct = newStatusCodeTracker(httptest.NewRecorder())
+	defer sct.release()
+	if sct.status != 0 || sct.wroteheader || len(sct.headerHooks) != 0 {
+		t.Fatalf("got a tracker in state %+v, expected a reset one", sct)
+	}
+}
+
+func TestMiddlewareURLTagUnsampled(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampled bool
+		options []MWOption
+		url     interface{}
+	}{
+		{"sampled", true, nil, "/path?q=1"},
+		{"unsampled", false, nil, nil},
+		{"unsampled custom", false, []MWOption{MWURLTagFunc(func(u *url.URL) string { return u.Path })}, "/path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &samplingTracer{MockTracer: mocktracer.New(), sampled: tt.sampled}
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			}), tt.options...)
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path?q=1", nil))
+
+			spans := tr.FinishedSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, expected 1", len(spans))
+			}
+			if got := spans[0].Tag(string(ext.HTTPUrl)); got != tt.url {
+				t.Fatalf("got http.url %v, expected %v", got, tt.url)
+			}
+		})
+	}
+}
+
+// samplingTracer is a mocktracer whose span contexts report whether
+// they are sampled.
+type samplingTracer struct {
+	*mocktracer.MockTracer
+	sampled bool
+}
+
+type samplingSpan struct {
+	opentracing.Span
+	sampled bool
+}
+
+type samplingSpanContext struct {
+	opentracing.SpanContext
+	sampled bool
+}
+
+func (t *samplingTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	return &samplingSpan{Span: t.MockTracer.StartSpan(operationName, opts...), sampled: t.sampled}
+}
+
+// SetTag forces the sampling of s on a positive sampling.priority, as
+// tracers do.
+func (s *samplingSpan) SetTag(key string, value interface{}) opentracing.Span {
+	if p, ok := value.(uint16); ok && key == string(ext.SamplingPriority) && p > 0 {
+		s.sampled = true
+	}
+	s.Span.SetTag(key, value)
+	return s
+}
+
+func (s *samplingSpan) Context() opentracing.SpanContext {
+	return samplingSpanContext{SpanContext: s.Span.Context(), sampled: s.sampled}
+}
+
+func (c samplingSpanContext) IsSampled() bool {
+	return c.sampled
+}
+
 func TestMiddlewareHandlerPanic(t *testing.T) {
 	tests := []struct {
 		handler func(w http.ResponseWriter, r *http.Request)
@@ -355,3 +889,3320 @@ func TestMiddlewareHandlerPanic(t *testing.T) {
 		})
 	}
 }
+
+func TestUploadHandler(t *testing.T) {
+	payload := strings.Repeat("<html><body>upload</body></html>", 100)
+	var received int
+	upload := UploadHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received = len(b)
+	}), UploadProgressBytes(1024))
+
+	tr := &mocktracer.MockTracer{}
+	srv := httptest.NewServer(Middleware(tr, upload))
+	defer srv.Close()
+
+	_, err := http.Post(srv.URL, "application/octet-stream", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("server returned error: %v", err)
+	}
+	if got, want := received, len(payload); got != want {
+		t.Fatalf("handler read %d bytes, expected %d", got, want)
+	}
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, want := spans[0].Tag("upload.bytes"), int64(len(payload)); got != want {
+		t.Fatalf("got %v upload.bytes, expected %v", got, want)
+	}
+	if got, want := spans[0].Tag("upload.sniffed_type"), "text/html; charset=utf-8"; got != want {
+		t.Fatalf("got %v upload.sniffed_type, expected %v", got, want)
+	}
+	if len(spans[0].Logs()) == 0 {
+		t.Fatal("expected upload progress logs")
+	}
+}
+
+func TestTracingDecisionOption(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	var calls int
+	decide := func(key string) (Verbosity, error) {
+		calls++
+		switch key {
+		case "/off":
+			return VerbosityOff, nil
+		case "/debug":
+			return VerbosityDebug, nil
+		}
+		return VerbosityOff, errors.New("authorizer unavailable")
+	}
+	keyFunc := func(r *http.Request) string { return r.URL.Path }
+
+	tests := []struct {
+		url       string
+		spans     int
+		verbosity interface{}
+	}{
+		{"/off", 0, nil},
+		{"/debug", 1, "debug"},
+		{"/debug", 1, "debug"},
+		{"/unavailable", 1, nil},
+	}
+
+	tr := &mocktracer.MockTracer{}
+	srv := httptest.NewServer(Middleware(tr, mux, MWTracingDecision(keyFunc, decide, time.Minute)))
+	defer srv.Close()
+
+	for _, tt := range tests {
+		tr.Reset()
+		if _, err := http.Get(srv.URL + tt.url); err != nil {
+			t.Fatalf("server returned error: %v", err)
+		}
+		spans := tr.FinishedSpans()
+		if got, want := len(spans), tt.spans; got != want {
+			t.Fatalf("%s: got %d spans, expected %d", tt.url, got, want)
+		}
+		if len(spans) == 1 && spans[0].Tag("trace.verbosity") != tt.verbosity {
+			t.Fatalf("%s: got %v verbosity, expected %v", tt.url, spans[0].Tag("trace.verbosity"), tt.verbosity)
+		}
+	}
+	if got, want := calls, 3; got != want {
+		t.Fatalf("got %d decisions, expected %d", got, want)
+	}
+}
+
+func TestDecisionCache(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	release := make(chan struct{})
+	c := newDecisionCache(func(r *http.Request) string { return r.URL.Path }, func(key string) (Verbosity, error) {
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		if key == "/slow" {
+			<-release
+		}
+		return VerbosityDebug, nil
+	}, time.Minute)
+	c.maxEntries = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := c.verbosity(httptest.NewRequest("GET", "/slow", nil)); got != VerbosityDebug {
+				t.Errorf("got verbosity %v, expected %v", got, VerbosityDebug)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got, want := calls["/slow"], 1; got != want {
+		t.Fatalf("got %d concurrent decisions of a key, expected %d", got, want)
+	}
+
+	// /b evicts /slow, then /c evicts /b, the least recently used, while
+	// /a is kept
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/a", "/slow"} {
+		c.verbosity(httptest.NewRequest("GET", path, nil))
+	}
+	expected := map[string]int{"/slow": 2, "/a": 1, "/b": 1, "/c": 1}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("got decisions %v, expected %v", calls, expected)
+	}
+}
+
+func TestTracedServerShutdown(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+	})
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	defer close(release)
+
+	tr := &mocktracer.MockTracer{}
+	srv := NewTracedServer(&http.Server{Handler: mux}, tr)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	for _, path := range []string{"/fast", "/stuck"} {
+		go http.Get("http://" + l.Addr().String() + path)
+	}
+	<-started
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	var shutdown *mocktracer.MockSpan
+	for _, span := range tr.FinishedSpans() {
+		if span.OperationName == "HTTP Server Shutdown" {
+			shutdown = span
+		}
+	}
+	if shutdown == nil {
+		t.Fatal("cannot find shutdown span")
+	}
+	if got, want := shutdown.Tag("shutdown.drained"), 1; got != want {
+		t.Fatalf("got %v drained requests, expected %v", got, want)
+	}
+	if got, want := shutdown.Tag("shutdown.forced"), 1; got != want {
+		t.Fatalf("got %v forced requests, expected %v", got, want)
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	tr := &mocktracer.MockTracer{}
+	var hooked []ServerErrorKind
+	errLog := NewServerErrorLog(
+		ServerErrorLogSpans(tr),
+		ServerErrorLogOutput(stdlog.New(ioutil.Discard, "", 0)),
+		ServerErrorLogHook(func(kind ServerErrorKind, msg string) {
+			hooked = append(hooked, kind)
+		}),
+	)
+
+	logger := errLog.Logger()
+	logger.Printf("http: TLS handshake error from 10.0.0.1:51234: EOF")
+	logger.Printf("http: TLS handshake error from 10.0.0.2:51235: tls: first record does not look like a TLS handshake")
+	logger.Printf("http: Accept error: accept tcp [::]:80: accept4: too many open files; retrying in 5ms")
+
+	if got, want := errLog.Count(ServerErrorTLSHandshake), int64(2); got != want {
+		t.Fatalf("got %d TLS handshake errors, expected %d", got, want)
+	}
+	if got, want := errLog.Count(ServerErrorAccept), int64(1); got != want {
+		t.Fatalf("got %d accept errors, expected %d", got, want)
+	}
+	if got, want := len(hooked), 3; got != want {
+		t.Fatalf("got %d hook calls, expected %d", got, want)
+	}
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 3; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags("server.error.kind", "tls_handshake", "peer.address", "10.0.0.1:51234", string(ext.Error), true)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestProtocolAnomaliesOption(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		reject    bool
+		anomalies interface{}
+		status    int
+	}{
+		{"clean", "Content-Length: 0\r\n", true, nil, http.StatusOK},
+		{"normalized conflict", "Content-Length: 3\r\nTransfer-Encoding: chunked\r\n", true, nil, http.StatusOK},
+		{"oversized", "Content-Length: 0\r\nCookie: " + strings.Repeat("a", 100) + "\r\n", false, "oversized_headers", http.StatusOK},
+		{"oversized rejected", "Content-Length: 0\r\nCookie: " + strings.Repeat("a", 100) + "\r\n", true, "oversized_headers", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &mocktracer.MockTracer{}
+			srv := httptest.NewServer(Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MWProtocolAnomalies(64, tt.reject)))
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			body := ""
+			if strings.Contains(tt.header, "chunked") {
+				body = "0\r\n\r\n"
+			}
+			fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: example.com\r\n%sConnection: close\r\n\r\n%s", tt.header, body)
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("got status %d, expected %d", resp.StatusCode, tt.status)
+			}
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got := spans[0].Tag("http.anomalies"); got != tt.anomalies {
+				t.Fatalf("got %v anomalies, expected %v", got, tt.anomalies)
+			}
+		})
+	}
+}
+
+func TestHeaderSizeTagsOption(t *testing.T) {
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MWHeaderSizeTags(true))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header = http.Header{"Cookie": {"a=1", "b=2"}, "Accept": {"*/*"}}
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags("http.request_header_bytes", 39, "http.request_header_count", 3)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestAllocationSamplingOption(t *testing.T) {
+	var sink []byte
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sink = make([]byte, 1<<20)
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWAllocationSampling(1))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	_ = sink
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, ok := spans[0].Tag("runtime.alloc_bytes").(uint64); !ok || got < 1<<20 {
+		t.Fatalf("got %v runtime.alloc_bytes, expected at least %d", spans[0].Tag("runtime.alloc_bytes"), 1<<20)
+	}
+}
+
+func TestGoroutineLeakDetectionOption(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		go func() { <-release }()
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWGoroutineLeakDetection(1, true))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, want := spans[0].Tag("runtime.goroutine_leak"), true; got != want {
+		t.Fatalf("got %v runtime.goroutine_leak, expected %v", got, want)
+	}
+	if got, want := len(spans[0].Logs()), 1; got != want {
+		t.Fatalf("got %d logs, expected %d", got, want)
+	}
+}
+
+func TestRuntimePressureOption(t *testing.T) {
+	p := NewRuntimePressure(time.Hour, time.Millisecond, 0)
+	defer p.Stop()
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MWRuntimePressure(p))
+	for _, sched := range []time.Duration{0, 10 * time.Millisecond} {
+		p.update(sched, 0)
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got := spans[0].Tag("runtime.pressure"); got != nil {
+		t.Fatalf("got %v runtime.pressure, expected none", got)
+	}
+	if got, want := spans[1].Tag("runtime.pressure"), "high"; got != want {
+		t.Fatalf("got %v runtime.pressure, expected %v", got, want)
+	}
+	if got, want := spans[1].Tag("runtime.gomaxprocs"), runtime.GOMAXPROCS(0); got != want {
+		t.Fatalf("got %v runtime.gomaxprocs, expected %v", got, want)
+	}
+}
+
+func TestPropagatorOption(t *testing.T) {
+	const prefix = "X-Custom-"
+	RegisterPropagator("prefixed",
+		InjectorFunc(func(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error {
+			carrier := opentracing.TextMapCarrier{}
+			if err := tr.Inject(sc, opentracing.TextMap, carrier); err != nil {
+				return err
+			}
+			for k, v := range carrier {
+				h.Set(prefix+k, v)
+			}
+			return nil
+		}),
+		ExtractorFunc(func(tr opentracing.Tracer, h http.Header) (opentracing.SpanContext, error) {
+			carrier := opentracing.TextMapCarrier{}
+			for k := range h {
+				if strings.HasPrefix(k, prefix) {
+					carrier[strings.TrimPrefix(k, prefix)] = h.Get(k)
+				}
+			}
+			return tr.Extract(opentracing.TextMap, carrier)
+		}),
+	)
+
+	tr := mocktracer.New()
+	var prefixed bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k := range r.Header {
+			prefixed = prefixed || strings.HasPrefix(k, prefix)
+		}
+	})
+	srv := httptest.NewServer(Middleware(tr, h, MWPropagator("prefixed")))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(tr, req, ClientPropagator("prefixed"), ClientTrace(false))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+
+	if !prefixed {
+		t.Fatal("span context was not injected with the custom propagator")
+	}
+	var server, client *mocktracer.MockSpan
+	for _, span := range tr.FinishedSpans() {
+		switch span.Tag(string(ext.SpanKind)) {
+		case ext.SpanKindRPCServerEnum:
+			server = span
+		case ext.SpanKindRPCClientEnum:
+			client = span
+		}
+	}
+	if server == nil || client == nil {
+		t.Fatal("cannot find server and client spans")
+	}
+	if server.ParentID != client.SpanContext.SpanID {
+		t.Fatalf("got server parent %d, expected %d", server.ParentID, client.SpanContext.SpanID)
+	}
+}
+
+type allInterfacesWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (allInterfacesWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+func (allInterfacesWriter) CloseNotify() <-chan bool                     { return nil }
+func (allInterfacesWriter) Push(string, *http.PushOptions) error         { return nil }
+func (allInterfacesWriter) ReadFrom(io.Reader) (int64, error)            { return 0, nil }
+
+func TestWrappedResponseWriterInterfaces(t *testing.T) {
+	tests := []struct {
+		name   string
+		w      http.ResponseWriter
+		expect [5]bool
+	}{
+		{"none", struct{ http.ResponseWriter }{httptest.NewRecorder()}, [5]bool{}},
+		{"flusher", httptest.NewRecorder(), [5]bool{false, false, false, true, false}},
+		{"all", allInterfacesWriter{httptest.NewRecorder()}, [5]bool{true, true, true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := (&statusCodeTracker{ResponseWriter: tt.w}).wrappedResponseWriter()
+			_, hj := w.(http.Hijacker)
+			_, cn := w.(http.CloseNotifier)
+			_, pu := w.(http.Pusher)
+			_, fl := w.(http.Flusher)
+			_, rf := w.(io.ReaderFrom)
+			if got := [5]bool{hj, cn, pu, fl, rf}; got != tt.expect {
+				t.Fatalf("got interfaces %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestReaderFromStatusTracking(t *testing.T) {
+	var isReaderFrom bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, isReaderFrom = w.(io.ReaderFrom)
+		io.Copy(w, strings.NewReader("OK"))
+	})
+
+	tr := &mocktracer.MockTracer{}
+	srv := httptest.NewServer(Middleware(tr, h))
+	defer srv.Close()
+
+	if _, err := http.Get(srv.URL); err != nil {
+		t.Fatalf("server returned error: %v", err)
+	}
+	if !isReaderFrom {
+		t.Fatal("wrapped writer does not implement io.ReaderFrom")
+	}
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, want := spans[0].Tag(string(ext.HTTPStatusCode)), uint16(200); got != want {
+		t.Fatalf("got %v status code, expected %v", got, want)
+	}
+	if got := spans[0].Tag(string(ext.Error)); got != nil {
+		t.Fatalf("got %v error tag, expected none", got)
+	}
+}
+
+// readerFromRecorder is a ResponseRecorder counting the calls to its
+// ReadFrom, which net/http uses to send files with sendfile.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFromCalls int
+}
+
+func (w *readerFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	w.readFromCalls++
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func TestReaderFromDelegation(t *testing.T) {
+	f, err := ioutil.TempFile("", "nethttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	content := strings.Repeat("0123456789", 1000)
+	f.WriteString(content)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "blob", time.Time{}, f)
+	})
+	tests := []struct {
+		rangeHeader string
+		status      uint16
+		size        int64
+	}{
+		{"", 200, int64(len(content))},
+		{"bytes=100-199", 206, 100},
+	}
+	for _, tt := range tests {
+		tr := mocktracer.New()
+		w := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.rangeHeader != "" {
+			r.Header.Set("Range", tt.rangeHeader)
+		}
+		Middleware(tr, h).ServeHTTP(w, r)
+
+		if w.readFromCalls != 1 {
+			t.Fatalf("got %d calls to the ReadFrom of the original writer, expected 1", w.readFromCalls)
+		}
+		if got := int64(w.Body.Len()); got != tt.size {
+			t.Fatalf("got %d bytes sent, expected %d", got, tt.size)
+		}
+		sp := tr.FinishedSpans()[0]
+		if got := sp.Tag("http.status_code"); got != tt.status {
+			t.Fatalf("got status %v, expected %d", got, tt.status)
+		}
+		if got := sp.Tag("http.response_size"); got != tt.size {
+			t.Fatalf("got http.response_size %v, expected %d", got, tt.size)
+		}
+	}
+}
+
+func TestResponseSizeTag(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/string", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello world")
+	})
+	mux.HandleFunc("/copy", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, strings.NewReader(strings.Repeat("a", 100)))
+	})
+
+	tests := []struct {
+		url  string
+		size int64
+	}{
+		{"/write", 5},
+		{"/string", 11},
+		{"/copy", 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			tr := &mocktracer.MockTracer{}
+			srv := httptest.NewServer(Middleware(tr, mux))
+			defer srv.Close()
+
+			if _, err := http.Get(srv.URL + tt.url); err != nil {
+				t.Fatalf("server returned error: %v", err)
+			}
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got, want := spans[0].Tag("http.response_size"), tt.size; got != want {
+				t.Fatalf("got %v http.response_size, expected %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRequestSizeTagOption(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 4)
+		io.ReadFull(r.Body, buf)
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWRequestSizeTag(true))
+	r := httptest.NewRequest("POST", "/", strings.NewReader("hello world"))
+	r.ContentLength = -1
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got, want := spans[0].Tag("http.request_size"), int64(4); got != want {
+		t.Fatalf("got %v http.request_size, expected %v", got, want)
+	}
+}
+
+func TestBodyErrorMappingsOption(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	custom := BodyErrorMapping{
+		Match: func(err error) bool { return err == errQuota },
+		Kind:  "quota",
+		Error: true,
+	}
+
+	tests := []struct {
+		name         string
+		body         io.Reader
+		limit        int64
+		handlerLimit int64
+		kind         string
+		error        interface{}
+	}{
+		{"TooLarge", strings.NewReader("hello world"), 4, 0, "too_large", nil},
+		{"HandlerTooLarge", strings.NewReader("hello world"), 0, 4, "too_large", nil},
+		{"HandlerUnderLimit", strings.NewReader("hello"), 0, 8, "", nil},
+		{"Unmapped", iotest.TimeoutReader(strings.NewReader("hello")), 0, 0, "", nil},
+		{"Quota", io.MultiReader(strings.NewReader("he"), errReader{errQuota}), 0, 0, "quota", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.handlerLimit > 0 {
+					r.Body = http.MaxBytesReader(w, r.Body, tt.handlerLimit)
+				}
+				ioutil.ReadAll(r.Body)
+				w.Write([]byte("OK"))
+			})
+			tr := &mocktracer.MockTracer{}
+			mw := Middleware(tr, h, MWBodyErrorMappings(custom))
+			r := httptest.NewRequest("POST", "/", tt.body)
+			if tt.limit > 0 {
+				r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, tt.limit)
+			}
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if tt.handlerLimit > 0 && tt.kind == "" {
+				if got := spans[0].Tag("http.request.body_error"); got != nil {
+					t.Fatalf("got %v http.request.body_error, expected none", got)
+				}
+				return
+			}
+			if tt.kind == "" {
+				if got, want := len(spans[0].Logs()), 1; got != want {
+					t.Fatalf("got %d logs, expected %d", got, want)
+				}
+				return
+			}
+			if got, want := spans[0].Tag("http.request.body_error"), tt.kind; got != want {
+				t.Fatalf("got %v http.request.body_error, expected %v", got, want)
+			}
+			if got := spans[0].Tag(string(ext.Error)); got != tt.error {
+				t.Fatalf("got %v error, expected %v", got, tt.error)
+			}
+			if got, want := spans[0].Tag("http.request_size"), interface{}(nil); got != want {
+				t.Fatalf("got %v http.request_size, expected none", got)
+			}
+		})
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestPanicHandlerOption(t *testing.T) {
+	tests := []struct {
+		name   string
+		action PanicAction
+		status uint16
+	}{
+		{"Respond500", PanicRespond500, 500},
+		{"Repanic", PanicRepanic, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recovered interface{}
+			handler := func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction {
+				recovered = v
+				return tt.action
+			}
+			tr := &mocktracer.MockTracer{}
+			mw := MiddlewareFunc(tr, func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}, MWPanicHandler(handler))
+
+			w := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if v := recover(); (v != nil) != (tt.action == PanicRepanic) {
+						t.Fatalf("got panic %v, expected re-panic %v", v, tt.action == PanicRepanic)
+					}
+				}()
+				mw.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			}()
+
+			if recovered != "boom" {
+				t.Fatalf("got %v recovered value, expected boom", recovered)
+			}
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			expectedTags := makeTags("panic", true, string(ext.Error), true, string(ext.HTTPStatusCode), tt.status)
+			for k, expected := range expectedTags {
+				if got := spans[0].Tag(k); got != expected {
+					t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+				}
+			}
+			fields := spans[0].Logs()[0].Fields
+			if got, want := fields[len(fields)-1].Key, "stack"; got != want {
+				t.Fatalf("got %s log field, expected %s", got, want)
+			}
+		})
+	}
+}
+
+// errorLogFields returns the fields of the event=error log of sp by
+// key, or nil if there is none.
+func errorLogFields(sp *mocktracer.MockSpan) map[string]string {
+	for _, l := range sp.Logs() {
+		fields := make(map[string]string)
+		for _, f := range l.Fields {
+			fields[f.Key] = f.ValueString
+		}
+		if fields["event"] == "error" {
+			return fields
+		}
+	}
+	return nil
+}
+
+func TestMWErrorLogs(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []MWOption
+		handler  http.HandlerFunc
+		expected map[string]string
+	}{
+		{"status", []MWOption{MWErrorLogs(true)}, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, map[string]string{"event": "error", "error.kind": "HTTPStatus", "message": "503 Service Unavailable"}},
+		{"disabled", nil, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, nil},
+		{"not an error", []MWOption{MWErrorLogs(true)}, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, nil},
+		{"panic", []MWOption{MWErrorLogs(true)}, func(w http.ResponseWriter, r *http.Request) {
+			panic(errors.New("boom"))
+		}, map[string]string{"event": "error", "error.kind": "*errors.errorString", "message": "boom"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := MiddlewareFunc(tr, tt.handler, tt.options...)
+			func() {
+				defer func() { recover() }()
+				mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}()
+			fields := errorLogFields(tr.FinishedSpans()[0])
+			if (fields == nil) != (tt.expected == nil) {
+				t.Fatalf("got error log %v, expected %v", fields, tt.expected)
+			}
+			for k, want := range tt.expected {
+				if got := fields[k]; got != want {
+					t.Fatalf("got log field %s = %q, expected %q", k, got, want)
+				}
+			}
+			_, hasStack := fields["stack"]
+			if want := tt.name == "panic"; hasStack != want {
+				t.Fatalf("got stack %t, expected %t", hasStack, want)
+			}
+			if tt.name == "panic" && !strings.Contains(fields["stack"], "TestMWErrorLogs") {
+				t.Fatalf("got stack without the panicking frames:\n%s", fields["stack"])
+			}
+		})
+	}
+}
+
+type quotaError struct{ tenant string }
+
+func (e *quotaError) Error() string { return "quota exceeded for " + e.tenant }
+
+func TestPanicHandlerErrorValue(t *testing.T) {
+	handler := func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction {
+		if _, ok := v.(*quotaError); !ok {
+			t.Fatalf("got %T recovered value, expected *quotaError", v)
+		}
+		return PanicRespond500
+	}
+	tr := &mocktracer.MockTracer{}
+	mw := MiddlewareFunc(tr, func(w http.ResponseWriter, r *http.Request) {
+		panic(&quotaError{tenant: "acme"})
+	}, MWPanicHandler(handler))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	fields := make(map[string]string)
+	for _, field := range spans[0].Logs()[0].Fields {
+		fields[field.Key] = field.ValueString
+	}
+	if got, want := fields["error.kind"], "*nethttp.quotaError"; got != want {
+		t.Fatalf("got %q error.kind, expected %q", got, want)
+	}
+	if got, want := fields["message"], "quota exceeded for acme"; got != want {
+		t.Fatalf("got %q message, expected %q", got, want)
+	}
+}
+
+func TestTagsTemplateOption(t *testing.T) {
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MWTagsTemplate(map[string]string{
+		"tenant":   "{header:X-Tenant}",
+		"resource": "{method} /{path[0]}/{path[5]}",
+		"region":   "{query:region}@{host}",
+		"missing":  "{header:X-Missing}",
+		"unknown":  "{nope}",
+	}))
+	r := httptest.NewRequest("GET", "http://example.com/users/42?region=eu", nil)
+	r.Header.Set("X-Tenant", "acme")
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags(
+		"tenant", "acme",
+		"resource", "GET /users/",
+		"region", "eu@example.com",
+		"missing", nil,
+		"unknown", "{nope}",
+	)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestSLOClassesOption(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("OK"))
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	opNameFn := func(r *http.Request) string { return r.URL.Path }
+	classes := map[string]SLOClass{
+		"/ok":   {Name: "interactive", Latency: time.Second},
+		"/slow": {Name: "interactive", Latency: 10 * time.Millisecond},
+	}
+
+	tests := []struct {
+		url       string
+		class     interface{}
+		violated  interface{}
+		violation interface{}
+	}{
+		{"/ok", "interactive", false, nil},
+		{"/slow", "interactive", true, "latency"},
+		{"/error", "default", true, "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			tr := &mocktracer.MockTracer{}
+			mw := Middleware(tr, mux, OperationNameFunc(opNameFn), MWSLOClasses(classes, &SLOClass{Name: "default"}))
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.url, nil))
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			expectedTags := makeTags("slo.class", tt.class, "slo.violated", tt.violated, "slo.violation", tt.violation)
+			for k, expected := range expectedTags {
+				if got := spans[0].Tag(k); got != expected {
+					t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureHeadersOption(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Cache", "HIT")
+		w.Write([]byte("OK"))
+		w.Header().Set("X-Too-Late", "1")
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h,
+		MWCaptureRequestHeaders([]string{"x-tenant", "Authorization"}),
+		MWCaptureResponseHeaders([]string{"Set-Cookie", "X-Cache", "X-Too-Late"}),
+	)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	r.Header.Set("Authorization", "Bearer secret")
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags(
+		"http.request.header.x-tenant", "acme",
+		"http.request.header.authorization", RedactedValue,
+		"http.response.header.set-cookie", RedactedValue,
+		"http.response.header.x-cache", "HIT",
+		"http.response.header.x-too-late", nil,
+	)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestDualTagNamesOption(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWDualTagNames(TagGroupHTTP))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags(
+		"http.status_code", uint16(200),
+		"http.response.status_code", uint16(200),
+		"http.method", "GET",
+		"http.request.method", "GET",
+		"http.response.body.size", nil,
+	)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestBaggageToContextOption(t *testing.T) {
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+	parent.SetBaggageItem("tenant", "acme")
+	parent.SetBaggageItem("secret", "hidden")
+
+	var tenant string
+	var items map[string]string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ = BaggageItem(r.Context(), "tenant")
+		items = BaggageItems(r.Context())
+	})
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err != nil {
+		t.Fatal(err)
+	}
+	mw := Middleware(tr, h, MWBaggageToContext("tenant", "debug"))
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got, want := tenant, "acme"; got != want {
+		t.Fatalf("got tenant %q, expected %q", got, want)
+	}
+	if got, want := len(items), 1; got != want {
+		t.Fatalf("got %d baggage items %v, expected %d", got, items, want)
+	}
+	if _, ok := BaggageItem(context.Background(), "tenant"); ok {
+		t.Fatal("got baggage item from empty context")
+	}
+}
+
+func TestSpanSamplerOption(t *testing.T) {
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+
+	var propagated opentracing.SpanContext
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sp := opentracing.SpanFromContext(r.Context()); sp != nil {
+			propagated = sp.Context()
+		}
+	})
+	mw := Middleware(tr, h, MWSpanSampler(ProbabilisticSampler(0)))
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err != nil {
+		t.Fatal(err)
+	}
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got, want := len(tr.FinishedSpans()), 0; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	sc, ok := propagated.(mocktracer.MockSpanContext)
+	if !ok {
+		t.Fatalf("got %T span context, expected mocktracer.MockSpanContext", propagated)
+	}
+	if got, want := sc.SpanID, parent.Context().(mocktracer.MockSpanContext).SpanID; got != want {
+		t.Fatalf("got span ID %d, expected %d", got, want)
+	}
+}
+
+func TestRateLimitingSampler(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := RateLimitingSampler(2).(*tokenBucket)
+	s.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("GET", "/", nil)
+	var got []bool
+	for i := 0; i < 3; i++ {
+		got = append(got, s.Sample(r))
+	}
+	now = now.Add(500 * time.Millisecond)
+	got = append(got, s.Sample(r), s.Sample(r))
+
+	want := []bool{true, true, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, expected %v", got, want)
+		}
+	}
+}
+
+func TestSegment(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		end := Segment(r.Context(), "auth")
+		end()
+		Segment(r.Context(), "render")()
+	})
+
+	t.Run("Logs", func(t *testing.T) {
+		tr := &mocktracer.MockTracer{}
+		Middleware(tr, h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		spans := tr.FinishedSpans()
+		if got, want := len(spans), 1; got != want {
+			t.Fatalf("got %d spans, expected %d", got, want)
+		}
+		var segments []string
+		for _, l := range spans[0].Logs() {
+			for _, f := range l.Fields {
+				if f.Key == "segment" {
+					segments = append(segments, f.ValueString)
+				}
+			}
+		}
+		if got, want := strings.Join(segments, ","), "auth,render"; got != want {
+			t.Fatalf("got segments %s, expected %s", got, want)
+		}
+	})
+
+	t.Run("Spans", func(t *testing.T) {
+		tr := &mocktracer.MockTracer{}
+		Middleware(tr, h, MWSegments(SegmentSpans)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		spans := tr.FinishedSpans()
+		if got, want := len(spans), 3; got != want {
+			t.Fatalf("got %d spans, expected %d", got, want)
+		}
+		for i, name := range []string{"auth", "render"} {
+			if got := spans[i].OperationName; got != name {
+				t.Fatalf("got %s span, expected %s", got, name)
+			}
+			if got, want := spans[i].ParentID, spans[2].SpanContext.SpanID; got != want {
+				t.Fatalf("got parent %d, expected %d", got, want)
+			}
+		}
+	})
+
+	t.Run("NoSpan", func(t *testing.T) {
+		Segment(context.Background(), "auth")()
+	})
+
+	t.Run("NotSampled", func(t *testing.T) {
+		tr := &samplingTracer{MockTracer: mocktracer.New()}
+		sp := tr.StartSpan("request")
+		ctx := context.WithValue(opentracing.ContextWithSpan(context.Background(), sp), keySegmentMode, SegmentSpans)
+		Segment(ctx, "auth")()
+		if got := len(tr.FinishedSpans()); got != 0 {
+			t.Fatalf("got %d segment spans of an unsampled request, expected none", got)
+		}
+	})
+}
+
+func TestRouteOptions(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	routes := NewRouteOptions().
+		Add("/internal/*", MWURLTagFunc(func(u *url.URL) string { return "" })).
+		Add("/internal/debug/", MWComponentName("debug")).
+		Add("/api/v2/users", OperationNameFunc(func(r *http.Request) string { return "users" })).
+		Add("/api/v3/users/{id}", MWComponentName("user")).
+		Add("/api/v3/users/{id}/orders", MWComponentName("orders")).
+		Add("/api/v3/users/me", MWComponentName("me"))
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWComponentName("base"), MWRouteOptions(routes))
+	paths := []string{
+		"/", "/internal/health", "/internal/debug/vars", "/api/v2/users", "/api/v2/users/1",
+		"/api/v3/users/1", "/api/v3/users/1/orders", "/api/v3/users/me", "/api/v3/users//orders",
+	}
+	for _, path := range paths {
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), len(paths); got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	tests := []struct {
+		opName    string
+		url       string
+		component string
+	}{
+		{"HTTP GET", "/", "base"},
+		{"HTTP GET", "", "base"},
+		{"HTTP GET", "/internal/debug/vars", "debug"},
+		{"users", "/api/v2/users", "base"},
+		{"HTTP GET", "/api/v2/users/1", "base"},
+		{"HTTP GET", "/api/v3/users/1", "user"},
+		{"HTTP GET", "/api/v3/users/1/orders", "orders"},
+		{"HTTP GET", "/api/v3/users/me", "me"},
+		{"HTTP GET", "/api/v3/users//orders", "base"},
+	}
+	for i, tt := range tests {
+		if got := spans[i].OperationName; got != tt.opName {
+			t.Fatalf("span %d: got operation %s, expected %s", i, got, tt.opName)
+		}
+		if got := spans[i].Tag(string(ext.HTTPUrl)); got != tt.url {
+			t.Fatalf("span %d: got url %v, expected %s", i, got, tt.url)
+		}
+		if got := spans[i].Tag(string(ext.Component)); got != tt.component {
+			t.Fatalf("span %d: got component %v, expected %s", i, got, tt.component)
+		}
+	}
+}
+
+func TestHijackedSpans(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Keep=%v", keep), func(t *testing.T) {
+			returned := make(chan net.Conn, 1)
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, rw, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+				rw.Flush()
+				returned <- conn
+			})
+			tr := &mocktracer.MockTracer{}
+			srv := httptest.NewServer(Middleware(tr, h, MWKeepHijackedSpans(keep)))
+			defer srv.Close()
+
+			client, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+			resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("got status %d, expected 101", resp.StatusCode)
+			}
+
+			conn := <-returned
+			// Wait for the handler to return and the middleware to finish.
+			time.Sleep(10 * time.Millisecond)
+			if got, want := len(tr.FinishedSpans()), 1; keep && got != 0 || !keep && got != want {
+				t.Fatalf("got %d spans before closing the connection", got)
+			}
+			conn.Close()
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			expectedTags := makeTags("http.hijacked", true, string(ext.HTTPStatusCode), uint16(101), string(ext.Error), nil)
+			for k, expected := range expectedTags {
+				if got := spans[0].Tag(k); got != expected {
+					t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+				}
+			}
+		})
+	}
+}
+
+func TestTimingDetailOption(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(time.Millisecond)
+		w.Write([]byte("data: 2\n\n"))
+		w.(http.Flusher).Flush()
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWTimingDetail(true))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	first, _ := spans[0].Tag("http.time_to_first_byte_us").(int64)
+	last, _ := spans[0].Tag("http.time_to_last_byte_us").(int64)
+	if first < 1000 || last < first+1000 {
+		t.Fatalf("got first byte at %dus and last byte at %dus", first, last)
+	}
+	if got, want := spans[0].Tag("http.flush_count"), 2; got != want {
+		t.Fatalf("got %v http.flush_count, expected %v", got, want)
+	}
+}
+
+// registerPrefixedPropagator registers a propagator writing the TextMap
+// format of the tracer into headers starting with prefix.
+func registerPrefixedPropagator(name, prefix string) {
+	RegisterPropagator(name,
+		InjectorFunc(func(tr opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error {
+			carrier := opentracing.TextMapCarrier{}
+			if err := tr.Inject(sc, opentracing.TextMap, carrier); err != nil {
+				return err
+			}
+			for k, v := range carrier {
+				h.Set(prefix+k, v)
+			}
+			return nil
+		}),
+		ExtractorFunc(func(tr opentracing.Tracer, h http.Header) (opentracing.SpanContext, error) {
+			carrier := opentracing.TextMapCarrier{}
+			for k := range h {
+				if strings.HasPrefix(k, prefix) {
+					carrier[strings.TrimPrefix(k, prefix)] = h.Get(k)
+				}
+			}
+			return tr.Extract(opentracing.TextMap, carrier)
+		}),
+	)
+}
+
+func TestPropagatorsOption(t *testing.T) {
+	registerPrefixedPropagator("first", "X-First-")
+	registerPrefixedPropagator("second", "X-Second-")
+
+	tr := mocktracer.New()
+	first := tr.StartSpan("first")
+	second := tr.StartSpan("second")
+	inject := func(name string, sp opentracing.Span, h http.Header) {
+		if err := injectSpanContext(tr, sp.Context(), h, []string{name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options []MWOption
+		inject  func(h http.Header)
+		parent  opentracing.Span
+	}{
+		{"Precedence", []MWOption{MWPropagators("first", "second")}, func(h http.Header) {
+			inject("second", second, h)
+			inject("first", first, h)
+		}, first},
+		{"Fallback", []MWOption{MWPropagators("first", "second")}, func(h http.Header) {
+			inject("second", second, h)
+		}, second},
+		{"Native", []MWOption{MWPropagators(PropagatorTraceContextHeaders, PropagatorB3Headers, PropagatorNative)}, func(h http.Header) {
+			inject(PropagatorNative, second, h)
+		}, second},
+		{"Unregistered", []MWOption{MWPropagators("unknown")}, func(h http.Header) {
+			inject(PropagatorNative, first, h)
+		}, first},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr.Reset()
+			r := httptest.NewRequest("GET", "/", nil)
+			tt.inject(r.Header)
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), tt.options...)
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got, want := spans[0].ParentID, tt.parent.Context().(mocktracer.MockSpanContext).SpanID; got != want {
+				t.Fatalf("got parent %d, expected %d", got, want)
+			}
+		})
+	}
+}
+
+func TestMWExtractFunc(t *testing.T) {
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+	carrier := opentracing.TextMapCarrier{}
+	if err := tr.Inject(parent.Context(), opentracing.TextMap, carrier); err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{}
+	for k, v := range carrier {
+		query.Set(k, v)
+	}
+	fromQuery := func(r *http.Request, tr opentracing.Tracer) (opentracing.SpanContext, error) {
+		carrier := opentracing.TextMapCarrier{}
+		for k, v := range r.URL.Query() {
+			carrier[k] = v[0]
+		}
+		return tr.Extract(opentracing.TextMap, carrier)
+	}
+	errMalformed := errors.New("malformed")
+
+	tests := []struct {
+		name    string
+		extract func(r *http.Request, tr opentracing.Tracer) (opentracing.SpanContext, error)
+		url     string
+		parent  int
+		err     error
+	}{
+		{"query", fromQuery, "/?" + query.Encode(), parent.Context().(mocktracer.MockSpanContext).SpanID, nil},
+		{"not found", fromQuery, "/", 0, nil},
+		{"error", func(r *http.Request, tr opentracing.Tracer) (opentracing.SpanContext, error) {
+			return nil, errMalformed
+		}, "/", 0, errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr.Reset()
+			var extractErr error
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				MWExtractFunc(tt.extract),
+				MWExtractErrorFunc(func(r *http.Request, err error) {
+					extractErr = err
+				}))
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.url, nil))
+
+			if extractErr != tt.err {
+				t.Fatalf("got extract error %v, expected %v", extractErr, tt.err)
+			}
+			if got := tr.FinishedSpans()[0].ParentID; got != tt.parent {
+				t.Fatalf("got parent %d, expected %d", got, tt.parent)
+			}
+		})
+	}
+}
+
+func TestHijackedConnLimitsOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		idle     time.Duration
+		lifetime time.Duration
+		closedBy string
+	}{
+		{"IdleTimeout", 20 * time.Millisecond, 0, "idle_timeout"},
+		{"MaxLifetime", 0, 20 * time.Millisecond, "max_lifetime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				go ioutil.ReadAll(conn)
+			})
+			tr := &mocktracer.MockTracer{}
+			srv := httptest.NewServer(Middleware(tr, h, MWHijackedConnLimits(tt.idle, tt.lifetime)))
+			defer srv.Close()
+
+			client, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+
+			deadline := time.Now().Add(time.Second)
+			for len(tr.FinishedSpans()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got := spans[0].Tag("http.hijacked.closed_by"); got != tt.closedBy {
+				t.Fatalf("got %v http.hijacked.closed_by, expected %v", got, tt.closedBy)
+			}
+			if _, ok := spans[0].Tag("http.hijacked.lifetime_ms").(int64); !ok {
+				t.Fatal("cannot find http.hijacked.lifetime_ms tag")
+			}
+		})
+	}
+}
+
+func TestResponseTraceHeaderOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		traceID func(sp opentracing.Span) string
+		write   bool
+		want    func(sp *mocktracer.MockSpan) string
+	}{
+		{"Default", nil, true, func(sp *mocktracer.MockSpan) string {
+			return fmt.Sprint(sp.SpanContext.TraceID)
+		}},
+		{"Custom", func(sp opentracing.Span) string { return "custom" }, false, func(*mocktracer.MockSpan) string {
+			return "custom"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.write {
+					w.Write([]byte("OK"))
+				} else {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			tr := &mocktracer.MockTracer{}
+			w := httptest.NewRecorder()
+			Middleware(tr, h, MWResponseTraceHeader("X-Trace-Id", tt.traceID)).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got, want := w.Header().Get("X-Trace-Id"), tt.want(spans[0]); got != want {
+				t.Fatalf("got X-Trace-Id %q, expected %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDefaultTagNamesOption(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWDefaultTagNames(map[string]string{
+		"component": "",
+		"span.kind": "",
+		"http.url":  "http.target",
+	}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags(
+		"component", nil,
+		"span.kind", nil,
+		"http.url", nil,
+		"http.target", "/path",
+		"http.method", "GET",
+		"http.status_code", uint16(200),
+	)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+// metricsRecord is a MetricsRecorder recording the calls it gets.
+type metricsRecord struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *metricsRecord) record(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *metricsRecord) ObserveLatency(l MetricsLabels, d time.Duration) {
+	m.record("latency %s %s %s", l.Kind, l.Operation, l.Method)
+}
+
+func (m *metricsRecord) IncStatus(l MetricsLabels, status int) {
+	m.record("status %s %s %d", l.Kind, l.Operation, status)
+}
+
+func (m *metricsRecord) ObserveSize(l MetricsLabels, requestBytes, responseBytes int64) {
+	m.record("size %s %s %d %d", l.Kind, l.Operation, requestBytes, responseBytes)
+}
+
+func TestMetricsRecorderOption(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("OK"))
+	})
+
+	m := &metricsRecord{}
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWMetricsRecorder(m), MWSpanFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/untraced"
+	}))
+	for _, path := range []string{"/", "/untraced"} {
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, strings.NewReader("body")))
+	}
+
+	if got, want := len(tr.FinishedSpans()), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	want := []string{
+		"latency server HTTP POST POST", "status server HTTP POST 201", "size server HTTP POST 4 2",
+		"latency server HTTP POST POST", "status server HTTP POST 201", "size server HTTP POST 4 2",
+	}
+	if got := strings.Join(m.calls, "\n"); got != strings.Join(want, "\n") {
+		t.Fatalf("got calls\n%s\nexpected\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestMWSkipPreflight(t *testing.T) {
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+	var traceIDs []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := TraceIDFromContext(r.Context())
+		traceIDs = append(traceIDs, id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	m := &metricsRecord{}
+	mw := Middleware(tr, h, MWSkipPreflight(true), MWMetricsRecorder(m))
+	for _, method := range []string{"OPTIONS", "GET"} {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		mw.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	spans := tr.FinishedSpans()
+	if len(spans) != 1 || spans[0].Tag(string(ext.HTTPMethod)) != "GET" {
+		t.Fatalf("got spans %v, expected the GET request's only", spans)
+	}
+	traceID := strconv.Itoa(parent.Context().(mocktracer.MockSpanContext).TraceID)
+	if len(traceIDs) != 2 || traceIDs[0] != traceID || traceIDs[1] != traceID {
+		t.Fatalf("got trace IDs %v, expected %s twice", traceIDs, traceID)
+	}
+	if got, want := m.calls[1], "status server HTTP OPTIONS 204"; got != want {
+		t.Fatalf("got call %q, expected %q", got, want)
+	}
+}
+
+func TestBufferingTracer(t *testing.T) {
+	traces := make(chan []opentracing.Span, 2)
+	tr := NewBufferingTracer(mocktracer.New(), func(trace []opentracing.Span) {
+		traces <- trace
+	})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Segment(r.Context(), "render")()
+	})
+	srv := httptest.NewServer(Middleware(tr, h, MWSegments(SegmentSpans)))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(tr, req)
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := len(traces); got != 0 {
+		t.Fatalf("got %d traces before the root span is finished", got)
+	}
+	ht.Finish()
+
+	trace := <-traces
+	var names []string
+	for _, sp := range trace {
+		names = append(names, sp.(*mocktracer.MockSpan).OperationName)
+	}
+	if got, want := strings.Join(names, ","), "render,HTTP GET,HTTP GET,HTTP Client"; got != want {
+		t.Fatalf("got trace %s, expected %s", got, want)
+	}
+}
+
+// prefixedTracer injects and extracts the span contexts of a MockTracer
+// under prefixed headers, so that several of them can share requests.
+type prefixedTracer struct {
+	*mocktracer.MockTracer
+	prefix string
+}
+
+func (t *prefixedTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	c := opentracing.TextMapCarrier{}
+	if err := t.MockTracer.Inject(sc, opentracing.TextMap, c); err != nil {
+		return err
+	}
+	for k, v := range c {
+		carrier.(opentracing.HTTPHeadersCarrier).Set(t.prefix+k, v)
+	}
+	return nil
+}
+
+func (t *prefixedTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	c := opentracing.TextMapCarrier{}
+	carrier.(opentracing.HTTPHeadersCarrier).ForeachKey(func(k, v string) error {
+		if strings.HasPrefix(strings.ToLower(k), strings.ToLower(t.prefix)) {
+			c[k[len(t.prefix):]] = v
+		}
+		return nil
+	})
+	return t.MockTracer.Extract(opentracing.TextMap, c)
+}
+
+// failingTracer fails to inject and extract span contexts.
+type failingTracer struct {
+	opentracing.NoopTracer
+}
+
+func (failingTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	return errors.New("unavailable")
+}
+
+func (failingTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestMultiTracer(t *testing.T) {
+	backends := []*mocktracer.MockTracer{mocktracer.New(), mocktracer.New()}
+	tr := MultiTracer(
+		&prefixedTracer{backends[0], "X-First-"},
+		&prefixedTracer{backends[1], "X-Second-"},
+		failingTracer{},
+	)
+	var traceID string
+	srv := httptest.NewServer(Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID, _ = TraceIDFromContext(r.Context())
+	})))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ht := TraceRequest(tr, req, ClientTrace(false))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+
+	for i, backend := range backends {
+		var client, server *mocktracer.MockSpan
+		for _, sp := range backend.FinishedSpans() {
+			switch sp.Tag(string(ext.SpanKind)) {
+			case ext.SpanKindRPCClientEnum:
+				client = sp
+			case ext.SpanKindRPCServerEnum:
+				server = sp
+			}
+		}
+		if client == nil || server == nil {
+			t.Fatalf("got spans %v from backend %d, expected client and server spans", backend.FinishedSpans(), i)
+		}
+		if server.ParentID != client.SpanContext.SpanID || server.SpanContext.TraceID != client.SpanContext.TraceID {
+			t.Fatalf("got server span %v not child of client span %v in backend %d", server, client, i)
+		}
+		if i == 0 {
+			if want := strconv.Itoa(server.SpanContext.TraceID); traceID != want {
+				t.Fatalf("got trace ID %s, expected %s", traceID, want)
+			}
+		}
+	}
+}
+
+func TestCSRFObservationOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		cookie  string
+		header  string
+		present interface{}
+		valid   interface{}
+	}{
+		{"Safe", "GET", "", "", nil, nil},
+		{"Missing", "POST", "token", "", false, false},
+		{"Mismatch", "POST", "token", "other", true, false},
+		{"Match", "DELETE", "token", "token", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &mocktracer.MockTracer{}
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				MWCSRFObservation(DoubleSubmitCSRF("csrf", "X-CSRF-Token")))
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "csrf", Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set("X-CSRF-Token", tt.header)
+			}
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got := spans[0].Tag("csrf.present"); got != tt.present {
+				t.Fatalf("got %v csrf.present, expected %v", got, tt.present)
+			}
+			if got := spans[0].Tag("csrf.valid"); got != tt.valid {
+				t.Fatalf("got %v csrf.valid, expected %v", got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCancellationTags(t *testing.T) {
+	tests := []struct {
+		name         string
+		ctx          func() (context.Context, context.CancelFunc)
+		disconnected interface{}
+	}{
+		{"Canceled", func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, true},
+		{"DeadlineExceeded", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 0)
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cancel()
+				w.Write([]byte("OK"))
+			})
+			tr := &mocktracer.MockTracer{}
+			r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+			Middleware(tr, h).ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			expectedTags := makeTags("context.canceled", true, "client.disconnected", tt.disconnected)
+			for k, expected := range expectedTags {
+				if got := spans[0].Tag(k); got != expected {
+					t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+				}
+			}
+			if got, want := len(spans[0].Logs()), 1; got != want {
+				t.Fatalf("got %d logs, expected %d", got, want)
+			}
+		})
+	}
+
+	tr := &mocktracer.MockTracer{}
+	Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got := tr.FinishedSpans()[0].Tag("context.canceled"); got != nil {
+		t.Fatalf("got %v context.canceled, expected none", got)
+	}
+}
+
+func TestSlowRequestThresholdOption(t *testing.T) {
+	called := make(chan string, 1)
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWSlowRequestThreshold(10*time.Millisecond, func(sp opentracing.Span, r *http.Request) {
+		called <- r.URL.Path
+	}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	done := make(chan struct{})
+	go func() {
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+
+	if got, want := <-called, "/slow"; got != want {
+		t.Fatalf("got callback for %s, expected %s", got, want)
+	}
+	close(release)
+	<-done
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got := spans[0].Tag("http.slow"); got != nil {
+		t.Fatalf("got %v http.slow for the fast request, expected none", got)
+	}
+	if got := spans[1].Tag("http.slow"); got != true {
+		t.Fatalf("got %v http.slow for the slow request, expected true", got)
+	}
+
+	// a request completing while the callback runs is finished after it
+	started := make(chan struct{})
+	var returned int32
+	mw = Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-started
+	}), MWSlowRequestThreshold(time.Millisecond, func(sp opentracing.Span, r *http.Request) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&returned, 1)
+	}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if atomic.LoadInt32(&returned) != 1 {
+		t.Fatal("finished the span before the callback returned")
+	}
+}
+
+func TestMWMaxSpanLifetime(t *testing.T) {
+	called := make(chan string, 1)
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			<-release
+			opentracing.SpanFromContext(r.Context()).SetTag("late", true)
+		}
+	})
+
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, h, MWMaxSpanLifetime(10*time.Millisecond, func(sp opentracing.Span, r *http.Request) {
+		called <- r.URL.Path
+	}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	done := make(chan struct{})
+	go func() {
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream", nil))
+		close(done)
+	}()
+
+	if got, want := <-called, "/stream"; got != want {
+		t.Fatalf("got callback for %s, expected %s", got, want)
+	}
+	if got, want := len(tr.FinishedSpans()), 2; got != want {
+		t.Fatalf("got %d spans before the handler returned, expected %d", got, want)
+	}
+	close(release)
+	<-done
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	if got := spans[0].Tag("forced_finish"); got != nil {
+		t.Fatalf("got %v forced_finish for the fast request, expected none", got)
+	}
+	if got := spans[1].Tag("forced_finish"); got != true {
+		t.Fatalf("got %v forced_finish for the leaked request, expected true", got)
+	}
+	if got := spans[1].Tag("late"); got != nil {
+		t.Fatalf("got %v tag set after the forced finish, expected none", got)
+	}
+}
+
+func TestDebugTraceHeaderOption(t *testing.T) {
+	key := []byte("secret")
+	var debug bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		debug = isDebugContext(r.Context())
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		debug  bool
+	}{
+		{"Signed", SignDebugTrace(key, time.Now()), true},
+		{"Expired", SignDebugTrace(key, time.Now().Add(-time.Hour)), false},
+		{"WrongKey", SignDebugTrace([]byte("other"), time.Now()), false},
+		{"Malformed", "1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, h,
+				MWDebugTraceHeader(HMACDebugTraceVerifier(key, time.Minute)),
+				MWSpanSampler(ProbabilisticSampler(0)))
+			r := httptest.NewRequest("POST", "/", strings.NewReader("payload"))
+			r.Header.Set(DebugTraceHeader, tt.header)
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := tr.FinishedSpans()
+			if !tt.debug {
+				if len(spans) != 0 || debug {
+					t.Fatalf("got %d spans and debug %v, expected none", len(spans), debug)
+				}
+				return
+			}
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if !debug {
+				t.Fatal("request context is not escalated")
+			}
+			if got, want := spans[0].Tag("trace.verbosity"), "debug"; got != want {
+				t.Fatalf("got %v trace.verbosity, expected %v", got, want)
+			}
+			var body string
+			for _, l := range spans[0].Logs() {
+				for _, f := range l.Fields {
+					if f.Key == "http.request.body" {
+						body = f.ValueString
+					}
+				}
+			}
+			if got, want := body, "payload"; got != want {
+				t.Fatalf("got request body %q, expected %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDebugTraceForgedBaggage(t *testing.T) {
+	var debug bool
+	tr := mocktracer.New()
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debug = isDebugContext(r.Context())
+	}), MWDebugTraceHeader(HMACDebugTraceVerifier([]byte("secret"), time.Minute)))
+
+	parent := tr.StartSpan("parent")
+	parent.SetBaggageItem("trace.debug", "1")
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err != nil {
+		t.Fatal(err)
+	}
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	if debug {
+		t.Fatal("escalated by baggage sent by the caller")
+	}
+}
+
+func TestPreviousTraceLinkOption(t *testing.T) {
+	tests := []struct {
+		header string
+		want   interface{}
+	}{
+		{"", nil},
+		{"4bf92f3577b34da6a3ce929d0e0e4736", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"<script>", nil},
+		{strings.Repeat("a", 129), nil},
+	}
+
+	for _, tt := range tests {
+		tr := &mocktracer.MockTracer{}
+		mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MWPreviousTraceLink(""))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(PreviousTraceIDHeader, tt.header)
+		mw.ServeHTTP(httptest.NewRecorder(), r)
+
+		if got := tr.FinishedSpans()[0].Tag("previous_trace.id"); got != tt.want {
+			t.Fatalf("got %v previous_trace.id, expected %v", got, tt.want)
+		}
+	}
+}
+
+func TestTagLimitsOption(t *testing.T) {
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		MWTagLimits(5, 1),
+		MWPathNormalizer(CollapseIDs),
+		MWSpanObserver(func(sp opentracing.Span, r *http.Request) {
+			sp.SetTag("first", "a long value")
+			sp.SetTag("first", "short")
+			sp.SetTag("second", "dropped")
+		}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42/orders/123e4567-e89b-12d3-a456-426614174000", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 1; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	expectedTags := makeTags(
+		"first", "short",
+		"second", nil,
+		"http.url", "/user...",
+		"http.method", "GET",
+		"http.response_size", int64(0),
+		"tags.dropped", 1,
+	)
+	for k, expected := range expectedTags {
+		if got := spans[0].Tag(k); got != expected {
+			t.Fatalf("got %v, expected %v, for key %s", got, expected, k)
+		}
+	}
+}
+
+func TestTagLimitsMultiByte(t *testing.T) {
+	tr := &mocktracer.MockTracer{}
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		MWTagLimits(5, 0),
+		MWSpanObserver(func(sp opentracing.Span, r *http.Request) {
+			sp.SetTag("name", "ééé")
+		}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if got, want := tr.FinishedSpans()[0].Tag("name"), "éé..."; got != want {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+}
+
+func TestCollapseIDs(t *testing.T) {
+	tests := map[string]string{
+		"/":                                 "/",
+		"/users/42":                         "/users/{id}",
+		"/users/me/orders/9b2b6c1f0e3d4a5b": "/users/me/orders/{id}",
+		"/v2/feed":                          "/v2/feed",
+	}
+	for path, want := range tests {
+		if got := CollapseIDs(path); got != want {
+			t.Fatalf("got %s, expected %s", got, want)
+		}
+	}
+}
+
+func TestLatencySketches(t *testing.T) {
+	s := NewLatencySketches(0, 0)
+	for i := 1; i <= 1000; i++ {
+		s.observe("op", time.Duration(i)*time.Millisecond, int64(i))
+	}
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		expected := time.Duration(q*1000) * time.Millisecond
+		if expected == 0 {
+			expected = time.Millisecond
+		}
+		got, ok := s.Quantile("op", q)
+		if !ok {
+			t.Fatalf("got no quantile %v", q)
+		}
+		if diff := math.Abs(float64(got-expected)) / float64(expected); diff > 0.04 {
+			t.Fatalf("got quantile %v = %v, expected %v", q, got, expected)
+		}
+	}
+	if got := s.Count("op"); got != 1000 {
+		t.Fatalf("got count %d, expected 1000", got)
+	}
+	if _, ok := s.Quantile("other", 0.5); ok {
+		t.Fatal("got a quantile of an unknown operation")
+	}
+}
+
+func TestLatencySketchesLimits(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewLatencySketches(time.Minute, 1)
+	s.now = func() time.Time { return now }
+
+	s.observe("a", time.Second, 0)
+	s.observe("b", time.Second, 0)
+	if got := s.Operations(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("got operations %v, expected [a]", got)
+	}
+
+	now = now.Add(90 * time.Second)
+	s.observe("a", 3*time.Second, 0)
+	if got := s.Count("a"); got != 2 {
+		t.Fatalf("got count %d, expected the last two windows", got)
+	}
+	now = now.Add(150 * time.Second)
+	if got := s.Count("a"); got != 0 {
+		t.Fatalf("got count %d, expected old latencies to be dropped", got)
+	}
+	s.observe("a", 48*time.Hour*365, 0)
+	if got, _ := s.Quantile("a", 1); got <= 0 {
+		t.Fatalf("got quantile %v of a clamped latency", got)
+	}
+	s.Reset()
+	if got := s.Operations(); len(got) != 0 {
+		t.Fatalf("got operations %v after reset", got)
+	}
+
+	unbounded := NewLatencySketches(0, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < defaultMaxSketchOperations; j++ {
+				unbounded.observe(strconv.Itoa(i*defaultMaxSketchOperations+j), time.Second, 0)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got, want := len(unbounded.Operations()), defaultMaxSketchOperations; got != want {
+		t.Fatalf("got %d operations, expected the default bound %d", got, want)
+	}
+}
+
+func TestLatencySketchesSizes(t *testing.T) {
+	s := NewLatencySketches(0, 0)
+	for _, size := range []int64{0, 1, 100, 100, 1000, 5000} {
+		s.observe("op", time.Millisecond, size)
+	}
+	expected := []SizeBucket{{0, 1}, {1, 1}, {127, 2}, {1023, 1}, {8191, 1}}
+	if got := s.SizeBuckets("op"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got buckets %v, expected %v", got, expected)
+	}
+	tests := []struct {
+		q        float64
+		expected int64
+	}{
+		{0, 0},
+		{0.5, 127},
+		{0.8, 1023},
+		{1, 5000},
+	}
+	for _, tt := range tests {
+		if got, ok := s.SizeQuantile("op", tt.q); !ok || got != tt.expected {
+			t.Fatalf("got size quantile %v = %d, expected %d", tt.q, got, tt.expected)
+		}
+	}
+	if _, ok := s.SizeQuantile("other", 0.5); ok {
+		t.Fatal("got a size quantile of an unknown operation")
+	}
+	if got := s.SizeBuckets("other"); got != nil {
+		t.Fatalf("got buckets %v of an unknown operation", got)
+	}
+}
+
+func TestMiddlewareLatencySketches(t *testing.T) {
+	s := NewLatencySketches(0, 0)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mw := Middleware(mocktracer.New(), h, MWLatencySketches(s), MWSpanFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/health"
+	}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/health", nil))
+
+	if got := s.Operations(); !reflect.DeepEqual(got, []string{"HTTP GET", "HTTP POST"}) {
+		t.Fatalf("got operations %v", got)
+	}
+	if _, ok := s.Quantile("HTTP GET", 0.99); !ok {
+		t.Fatal("got no quantile")
+	}
+	if got, _ := s.SizeQuantile("HTTP GET", 0.99); got != 2 {
+		t.Fatalf("got size quantile %d, expected 2", got)
+	}
+}
+
+func TestQueryParamRedaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []MWOption
+		target   string
+		expected string
+	}{
+		{"deny", []MWOption{MWRedactQueryParams("token", "API_KEY")}, "/a?b=1&token=s3cr3t&api_key=k&c", "/a?b=1&token=[REDACTED]&api_key=[REDACTED]&c"},
+		{"escaped name", []MWOption{MWRedactQueryParams("api key")}, "/a?api+key=k&b=2", "/a?api+key=[REDACTED]&b=2"},
+		{"nothing to redact", []MWOption{MWRedactQueryParams("token")}, "/a?b=1", "/a?b=1"},
+		{"allow", []MWOption{MWAllowQueryParams("page")}, "/a?page=2&session=x&flag", "/a?page=2&session=[REDACTED]&flag=[REDACTED]"},
+		{"url tag func", []MWOption{MWRedactQueryParams("token"), MWURLTagFunc(func(u *url.URL) string { return u.RawQuery })}, "/a?token=x", "token=[REDACTED]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			var query string
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { query = r.URL.RawQuery })
+			r := httptest.NewRequest("GET", tt.target, nil)
+			Middleware(tr, h, tt.options...).ServeHTTP(httptest.NewRecorder(), r)
+			if got := tr.FinishedSpans()[0].Tag("http.url"); got != tt.expected {
+				t.Fatalf("got http.url %v, expected %q", got, tt.expected)
+			}
+			if want := r.URL.RawQuery; query != want || strings.Contains(query, RedactedValue) {
+				t.Fatalf("got request query %q, expected it unchanged", query)
+			}
+		})
+	}
+}
+
+func TestMWImplicitStatusOK(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []MWOption
+		handler http.HandlerFunc
+		status  uint16
+		error   interface{}
+		header  string
+	}{
+		{"strict", nil, func(w http.ResponseWriter, r *http.Request) {}, 0, true, ""},
+		{"implicit", []MWOption{MWImplicitStatusOK(true)}, func(w http.ResponseWriter, r *http.Request) {}, 200, nil, "1"},
+		{"explicit", []MWOption{MWImplicitStatusOK(true)}, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}, 202, nil, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			options := append(tt.options, MWResponseTraceHeader("X-Trace", func(opentracing.Span) string { return "1" }))
+			srv := httptest.NewServer(Middleware(tr, tt.handler, options...))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("X-Trace"); got != tt.header {
+				t.Fatalf("got header %q, expected %q", got, tt.header)
+			}
+			sp := tr.FinishedSpans()[0]
+			if got := sp.Tag("http.status_code"); got != tt.status {
+				t.Fatalf("got status %v, expected %d", got, tt.status)
+			}
+			if got := sp.Tag("error"); got != tt.error {
+				t.Fatalf("got error %v, expected %v", got, tt.error)
+			}
+		})
+	}
+
+	var status int
+	mw := Middleware(mocktracer.New(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		MWImplicitStatusOK(true), MWSpanFilter(func(r *http.Request) bool { return false }),
+		func(o *mwOptions) {
+			o.accessLog = func(r *http.Request, sp opentracing.Span, opName, route string, s int, size int64, elapsed time.Duration) {
+				status = s
+			}
+		})
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if status != http.StatusOK {
+		t.Fatalf("got untraced status %d, expected 200", status)
+	}
+
+	tr := mocktracer.New()
+	mw = Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), MWImplicitStatusOK(true))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw.ServeHTTP(w, r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusNoContent; got != want {
+		t.Fatalf("got status %d written by the outer handler, expected %d", got, want)
+	}
+	if got, want := tr.FinishedSpans()[0].Tag("http.status_code"), uint16(200); got != want {
+		t.Fatalf("got status %v, expected %v", got, want)
+	}
+}
+
+func TestSuperfluousWriteHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		status      uint16
+		superfluous interface{}
+		attempted   interface{}
+	}{
+		{"single", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}, 201, nil, nil},
+		{"after WriteHeader", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadGateway)
+		}, 201, 2, uint16(500)},
+		{"after Write", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("OK"))
+			w.WriteHeader(http.StatusNotFound)
+		}, 200, 1, uint16(404)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			Middleware(tr, tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			sp := tr.FinishedSpans()[0]
+			if got := sp.Tag("http.status_code"); got != tt.status {
+				t.Fatalf("got status %v, expected %d", got, tt.status)
+			}
+			if got := sp.Tag("error"); got != nil {
+				t.Fatalf("got error %v, expected none", got)
+			}
+			if got := sp.Tag("http.superfluous_write_header"); got != tt.superfluous {
+				t.Fatalf("got %v superfluous calls, expected %v", got, tt.superfluous)
+			}
+			if got := sp.Tag("http.superfluous_status_code"); got != tt.attempted {
+				t.Fatalf("got superfluous status %v, expected %v", got, tt.attempted)
+			}
+		})
+	}
+}
+
+func TestMWDisableAutoError(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	quota := BodyErrorMapping{
+		Match: func(err error) bool { return err == errQuota },
+		Kind:  "quota",
+		Error: true,
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    io.Reader
+		status  uint16
+		error   interface{}
+	}{
+		{
+			"status", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}, nil, 500, nil,
+		},
+		{
+			"panic", func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}, nil, 500, nil,
+		},
+		{
+			"body", func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusBadRequest)
+			}, errReader{errQuota}, 400, nil,
+		},
+		{
+			"observer", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}, nil, 500, true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, tt.handler,
+				MWDisableAutoError(),
+				MWBodyErrorMappings(quota),
+				MWPanicHandler(func(sp opentracing.Span, r *http.Request, v interface{}, stack []byte) PanicAction {
+					return PanicRespond500
+				}),
+				MWSpanOnFinish(func(ctx context.Context, sp opentracing.Span, r *http.Request) context.Context {
+					if tt.error != nil {
+						ext.Error.Set(sp, true)
+					}
+					return ctx
+				}),
+			)
+			body := tt.body
+			if body == nil {
+				body = strings.NewReader("")
+			}
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", body))
+
+			spans := tr.FinishedSpans()
+			if got, want := len(spans), 1; got != want {
+				t.Fatalf("got %d spans, expected %d", got, want)
+			}
+			if got := spans[0].Tag(string(ext.HTTPStatusCode)); got != tt.status {
+				t.Fatalf("got status %v, expected %v", got, tt.status)
+			}
+			if got := spans[0].Tag(string(ext.Error)); got != tt.error {
+				t.Fatalf("got %v error, expected %v", got, tt.error)
+			}
+		})
+	}
+}
+
+func TestMWPeerTags(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		headers    map[string]string
+		address    interface{}
+		port       interface{}
+		clientIP   interface{}
+	}{
+		{"no proxies", nil, "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.1", uint16(1234), nil},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.1", uint16(1234), "192.0.2.1"},
+		{"x-forwarded-for", []string{"10.0.0.0/8"}, "10.0.0.1:80", map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.1.1.1"}, "10.0.0.1", uint16(80), "198.51.100.1"},
+		{"all trusted", []string{"10.0.0.0/8", "198.51.100.1"}, "10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.2.2.2, 198.51.100.1"}, "10.0.0.1", uint16(80), "10.2.2.2"},
+		{"forwarded", []string{"10.0.0.1"}, "10.0.0.1:80", map[string]string{
+			"Forwarded":       `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`,
+			"X-Forwarded-For": "198.51.100.1",
+		}, "10.0.0.1", uint16(80), "2001:db8:cafe::17"},
+		{"obfuscated", []string{"10.0.0.0/8"}, "10.0.0.1:80", map[string]string{"Forwarded": "for=192.0.2.60, for=_hidden, for=10.3.3.3"}, "10.0.0.1", uint16(80), "10.3.3.3"},
+		{"no port", []string{"10.0.0.0/8"}, "pipe", nil, "pipe", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			}), MWPeerTags(tt.trusted...))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			sp := tr.FinishedSpans()[0]
+			expected := makeTags("peer.address", tt.address, "peer.port", tt.port, "http.client_ip", tt.clientIP)
+			for k, v := range expected {
+				if got := sp.Tag(k); got != v {
+					t.Fatalf("got %v, expected %v, for key %s", got, v, k)
+				}
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected an invalid trusted proxy to panic")
+		}
+	}()
+	MWPeerTags("10.0.0.0/33")
+}
+
+func TestMWEdgeStartTime(t *testing.T) {
+	now := time.Now()
+	queued := now.Add(-250 * time.Millisecond)
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		startSpan bool
+		start     bool
+	}{
+		{"seconds", "X-Request-Start", fmt.Sprintf("t=%d.%03d", queued.Unix(), queued.Nanosecond()/1e6), true, true},
+		{"milliseconds", "X-Queue-Start", strconv.FormatInt(queued.UnixNano()/1e6, 10), true, true},
+		{"microseconds", "X-Request-Start", strconv.FormatInt(queued.UnixNano()/1e3, 10), false, false},
+		{"future", "X-Request-Start", strconv.FormatInt(now.Add(time.Minute).Unix(), 10), true, false},
+		{"invalid", "X-Request-Start", "t=soon", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			}), MWEdgeStartTime(tt.startSpan))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(tt.header, tt.value)
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			sp := tr.FinishedSpans()[0]
+			duration, tagged := sp.Tag("queue.edge_duration_us").(int64)
+			if valid := tt.name != "future" && tt.name != "invalid"; tagged != valid {
+				t.Fatalf("got queue.edge_duration_us %v, expected it to be tagged: %v", sp.Tag("queue.edge_duration_us"), valid)
+			}
+			if tagged && (duration < 249000 || duration > 10000000) {
+				t.Fatalf("got queue.edge_duration_us %d, expected about 250ms", duration)
+			}
+			if got := !sp.StartTime.After(queued.Add(time.Millisecond)); got != tt.start {
+				t.Fatalf("got span start %v, expected the edge start time: %v", sp.StartTime, tt.start)
+			}
+		})
+	}
+}
+
+func TestMWClockSkew(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		value   string
+		skewed  interface{}
+		tagged  bool
+		minimum time.Duration
+	}{
+		{"milliseconds", sentAtValue(now.Add(-20 * time.Millisecond)), nil, true, 19 * time.Millisecond},
+		{"rfc3339", now.Add(-20 * time.Millisecond).Format(time.RFC3339Nano), nil, true, 19 * time.Millisecond},
+		{"future", sentAtValue(now.Add(time.Minute)), true, true, -time.Minute - time.Millisecond},
+		{"late", sentAtValue(now.Add(-10 * time.Second)), true, true, 10 * time.Second},
+		{"invalid", "soon", nil, false, 0},
+		{"missing", "", nil, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, http.NotFoundHandler(), MWClockSkew("", time.Second))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.value != "" {
+				r.Header.Set(DefaultSentAtHeader, tt.value)
+			}
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			sp := tr.FinishedSpans()[0]
+			delta, tagged := sp.Tag("clock.upstream_delta_us").(int64)
+			if tagged != tt.tagged {
+				t.Fatalf("got clock.upstream_delta_us %v, expected it to be tagged: %v", sp.Tag("clock.upstream_delta_us"), tt.tagged)
+			}
+			if tagged && time.Duration(delta)*time.Microsecond < tt.minimum {
+				t.Fatalf("got clock.upstream_delta_us %d, expected at least %v", delta, tt.minimum)
+			}
+			if got := sp.Tag("clock.skew_suspected"); got != tt.skewed {
+				t.Fatalf("got clock.skew_suspected %v, expected %v", got, tt.skewed)
+			}
+		})
+	}
+
+	// a traced client sends the header checked by the server
+	tr := mocktracer.New()
+	srv := httptest.NewServer(Middleware(tr, http.NotFoundHandler(), MWClockSkew("X-Sent", time.Second)))
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req, ht := TraceRequest(tr, req, ClientTrace(false), ClientSentAtHeader("X-Sent"))
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ht.Finish()
+	for _, sp := range tr.FinishedSpans() {
+		if sp.OperationName != "HTTP GET" || sp.Tag("span.kind") != ext.SpanKindRPCServerEnum {
+			continue
+		}
+		if _, ok := sp.Tag("clock.upstream_delta_us").(int64); !ok {
+			t.Fatal("got no clock.upstream_delta_us tag on the server span")
+		}
+		if got := sp.Tag("clock.skew_suspected"); got != nil {
+			t.Fatalf("got clock.skew_suspected %v between local clocks", got)
+		}
+		return
+	}
+	t.Fatal("got no server span")
+}
+
+func TestMWRouteNameFunc(t *testing.T) {
+	type routeKey struct{}
+	// router records the route in a value of the request context, as
+	// third-party routers do.
+	router := func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok && strings.HasPrefix(r.URL.Path, "/users/") {
+			*route = "/users/{id}"
+		}
+		w.Write([]byte("OK"))
+	}
+	tests := []struct {
+		path   string
+		opName string
+		route  interface{}
+	}{
+		{"/users/42", "GET /users/{id} (api)", "/users/{id}"},
+		{"/other", "HTTP GET (api)", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, http.HandlerFunc(router),
+				MWRouteNameFunc(func(r *http.Request) string {
+					return *r.Context().Value(routeKey{}).(*string)
+				}),
+				MWOperationNameDecorator(func(name string, r *http.Request) string {
+					return name + " (api)"
+				}),
+			)
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, new(string)))
+			mw.ServeHTTP(httptest.NewRecorder(), r)
+
+			sp := tr.FinishedSpans()[0]
+			if sp.OperationName != tt.opName {
+				t.Fatalf("got operation name %q, expected %q", sp.OperationName, tt.opName)
+			}
+			if got := sp.Tag("http.route"); got != tt.route {
+				t.Fatalf("got http.route %v, expected %v", got, tt.route)
+			}
+		})
+	}
+}
+
+func TestMWSpanOnWriteHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		calls   int
+	}{
+		{"explicit", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("OK"))
+		}, http.StatusCreated, 1},
+		{"implicit", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("O"))
+			w.Write([]byte("K"))
+		}, http.StatusOK, 1},
+		{"nothing", func(w http.ResponseWriter, r *http.Request) {}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, status int
+			var at time.Time
+			tr := mocktracer.New()
+			mw := Middleware(tr, tt.handler, MWSpanOnWriteHeader(func(sp opentracing.Span, r *http.Request, s int, t time.Time) {
+				calls++
+				status, at = s, t
+				sp.SetTag("processed", true)
+				if opentracing.SpanFromContext(r.Context()) == nil {
+					sp.SetTag("processed", false)
+				}
+			}))
+			w := httptest.NewRecorder()
+			before := time.Now()
+			mw.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			if calls != tt.calls {
+				t.Fatalf("got %d calls, expected %d", calls, tt.calls)
+			}
+			if calls == 0 {
+				return
+			}
+			if status != tt.status || w.Code != tt.status {
+				t.Fatalf("got status %d (%d sent), expected %d", status, w.Code, tt.status)
+			}
+			if at.Before(before) {
+				t.Fatalf("got time %v before the request", at)
+			}
+			if got := tr.FinishedSpans()[0].Tag("processed"); got != true {
+				t.Fatalf("got processed %v, expected true", got)
+			}
+		})
+	}
+}
+
+func TestMWResponseBodyHash(t *testing.T) {
+	body := "hello, world"
+	sum := sha256.Sum256([]byte(body))
+	crc := crc32.ChecksumIEEE([]byte(body))
+	tests := []struct {
+		name     string
+		option   MWOption
+		expected string
+	}{
+		{"sha256", MWResponseBodyHash("sha256", sha256.New), "sha256:" + hex.EncodeToString(sum[:])},
+		{"crc32", MWResponseBodyHash("crc32", func() hash.Hash { return crc32.NewIEEE() }), fmt.Sprintf("crc32:%08x", crc)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			srv := httptest.NewServer(Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body[:3]))
+				io.WriteString(w, body[3:6])
+				w.(io.ReaderFrom).ReadFrom(strings.NewReader(body[6:]))
+			}), tt.option))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(got) != body {
+				t.Fatalf("got body %q, expected %q", got, body)
+			}
+			spans := tr.FinishedSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, expected 1", len(spans))
+			}
+			if got := spans[0].Tag("http.response.body_hash"); got != tt.expected {
+				t.Fatalf("got http.response.body_hash %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// referenceTracer records the type of the references of the spans it
+// starts.
+type referenceTracer struct {
+	*mocktracer.MockTracer
+	refs []opentracing.SpanReferenceType
+}
+
+func (t *referenceTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var sso opentracing.StartSpanOptions
+	for _, o := range opts {
+		o.Apply(&sso)
+	}
+	for _, ref := range sso.References {
+		t.refs = append(t.refs, ref.Type)
+	}
+	return t.MockTracer.StartSpan(operationName, opts...)
+}
+
+func TestMWSpanReference(t *testing.T) {
+	tr := &referenceTracer{MockTracer: mocktracer.New()}
+	parent := tr.StartSpan("parent")
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}), MWSpanReference(func(r *http.Request, parent opentracing.SpanContext) opentracing.StartSpanOption {
+		if strings.HasPrefix(r.URL.Path, "/webhooks/") {
+			return opentracing.FollowsFrom(parent)
+		}
+		return nil
+	}))
+
+	tests := []struct {
+		path   string
+		parent bool
+		ref    opentracing.SpanReferenceType
+	}{
+		{"/webhooks/push", true, opentracing.FollowsFromRef},
+		{"/api", true, opentracing.ChildOfRef},
+		{"/webhooks/push", false, 0},
+	}
+	for _, tt := range tests {
+		tr.Reset()
+		tr.refs = nil
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.parent {
+			tr.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		}
+		mw.ServeHTTP(httptest.NewRecorder(), r)
+
+		sp := tr.FinishedSpans()[0]
+		if got := sp.Tag(string(ext.SpanKind)); got != ext.SpanKindRPCServerEnum {
+			t.Fatalf("got span.kind %v for %s, expected %v", got, tt.path, ext.SpanKindRPCServerEnum)
+		}
+		if !tt.parent {
+			if sp.ParentID != 0 || len(tr.refs) != 0 {
+				t.Fatalf("got parent %d for %s, expected none", sp.ParentID, tt.path)
+			}
+			continue
+		}
+		if sp.ParentID != parent.Context().(mocktracer.MockSpanContext).SpanID {
+			t.Fatalf("got parent %d for %s, expected the injected span", sp.ParentID, tt.path)
+		}
+		if len(tr.refs) != 1 || tr.refs[0] != tt.ref {
+			t.Fatalf("got references %v for %s, expected %v", tr.refs, tt.path, tt.ref)
+		}
+	}
+}
+
+func TestMWCostAccounting(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				AddCost(r.Context(), "db.rows_scanned", 10)
+			}()
+		}
+		wg.Wait()
+		AddCost(r.Context(), "cents", 0.5)
+		AddCost(r.Context(), "cents", 1)
+		if costs := Costs(r.Context()); costs != nil && costs["cents"] != 1.5 {
+			t.Errorf("got costs %v in the handler", costs)
+		}
+		w.Write([]byte("OK"))
+	})
+
+	for _, enabled := range []bool{true, false} {
+		tr := mocktracer.New()
+		Middleware(tr, handler, MWCostAccounting(enabled)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		sp := tr.FinishedSpans()[0]
+		expected := makeTags("cost.db.rows_scanned", float64(40), "cost.cents", 1.5)
+		if !enabled {
+			expected = makeTags("cost.db.rows_scanned", nil, "cost.cents", nil)
+		}
+		for k, v := range expected {
+			if got := sp.Tag(k); got != v {
+				t.Fatalf("got %s %v, expected %v", k, got, v)
+			}
+		}
+	}
+
+	// AddCost must not fail outside the middleware.
+	AddCost(context.Background(), "cents", 1)
+	if costs := Costs(context.Background()); costs != nil {
+		t.Fatalf("got costs %v, expected none", costs)
+	}
+}
+
+func TestMWRetryAfterPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   string
+		expected string
+		tag      interface{}
+	}{
+		{"throttled", http.StatusTooManyRequests, "", "2", int64(2)},
+		{"unavailable", http.StatusServiceUnavailable, "", "30", int64(30)},
+		{"set by handler", http.StatusTooManyRequests, "7", "7", int64(7)},
+		{"ok", http.StatusOK, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(tt.status)
+			}), MWRetryAfterPolicy(func(r *http.Request, status int) time.Duration {
+				if opentracing.SpanFromContext(r.Context()) == nil {
+					t.Error("got a request without span")
+				}
+				if status == http.StatusTooManyRequests {
+					return 1500 * time.Millisecond
+				}
+				return 30 * time.Second
+			}))
+			w := httptest.NewRecorder()
+			mw.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			if got := w.Header().Get("Retry-After"); got != tt.expected {
+				t.Fatalf("got Retry-After %q, expected %q", got, tt.expected)
+			}
+			if got := tr.FinishedSpans()[0].Tag("http.retry_after_s"); got != tt.tag {
+				t.Fatalf("got http.retry_after_s %v, expected %v", got, tt.tag)
+			}
+		})
+	}
+}
+
+func TestMWRetryAfterPolicyNotSampled(t *testing.T) {
+	tr := mocktracer.New()
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}), MWSpanSampler(ProbabilisticSampler(0)), MWRetryAfterPolicy(func(r *http.Request, status int) time.Duration {
+		return 2 * time.Second
+	}))
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if got, want := w.Header().Get("Retry-After"), "2"; got != want {
+		t.Fatalf("got Retry-After %q, expected %q", got, want)
+	}
+	if got := len(tr.FinishedSpans()); got != 0 {
+		t.Fatalf("got %d spans, expected none", got)
+	}
+}
+
+func TestAnnotatePage(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AnnotatePage(r.Context(), PageInfo{Name: "product-detail", RenderMode: RenderModeSSR, Fragments: 3})
+	})
+	for _, handlerSpan := range []bool{false, true} {
+		tr := mocktracer.New()
+		Middleware(tr, h, MWHandlerSpan(handlerSpan)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		var sp *mocktracer.MockSpan
+		for _, s := range tr.FinishedSpans() {
+			if s.OperationName == "HTTP GET" {
+				sp = s
+			}
+		}
+		expected := map[string]interface{}{
+			"page.name":             "product-detail",
+			"page.render_mode":      "ssr",
+			"page.fragments":        3,
+			"page.cached_fragments": nil,
+		}
+		for name, want := range expected {
+			if got := sp.Tag(name); got != want {
+				t.Fatalf("got %s tag %v with handler span %t, expected %v", name, got, handlerSpan, want)
+			}
+		}
+	}
+
+	// without a span, AnnotatePage does nothing
+	AnnotatePage(context.Background(), PageInfo{Name: "home"})
+}
+
+func TestMWHandlerSpan(t *testing.T) {
+	tr := mocktracer.New()
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := opentracing.SpanFromContext(r.Context())
+		sp.SetTag("in.handler", true)
+		w.Write([]byte("OK"))
+	}), MWHandlerSpan(true))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	handler, server := spans[0], spans[1]
+	if handler.OperationName != "http.handler" || server.OperationName != "HTTP GET" {
+		t.Fatalf("got spans %q and %q", handler.OperationName, server.OperationName)
+	}
+	if handler.ParentID != server.SpanContext.SpanID {
+		t.Fatalf("got handler span parent %d, expected %d", handler.ParentID, server.SpanContext.SpanID)
+	}
+	if handler.Tag("in.handler") != true || server.Tag("in.handler") != nil {
+		t.Fatal("expected the handler to be given the handler span")
+	}
+	if handler.StartTime.Before(server.StartTime) || handler.FinishTime.After(server.FinishTime) {
+		t.Fatal("expected the handler span to be within the server span")
+	}
+	if got := server.Tag(string(ext.HTTPStatusCode)); got != uint16(200) {
+		t.Fatalf("got status %v on the server span", got)
+	}
+}
+
+func TestMWSpanAfterFinish(t *testing.T) {
+	tr := mocktracer.New()
+	var finished int
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}), MWSpanAfterFinish(func(ctx context.Context, sp opentracing.Span, r *http.Request) {
+		finished = len(tr.FinishedSpans())
+		if ctx == nil || r == nil {
+			t.Error("got no context or request")
+		}
+	}))
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if finished != 1 {
+		t.Fatalf("got %d finished spans when the hook was called, expected 1", finished)
+	}
+}
+
+func TestMWTracerFunc(t *testing.T) {
+	fallback, tenant := mocktracer.New(), mocktracer.New()
+	mw := Middleware(fallback, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}), MWTracerFunc(func(r *http.Request) opentracing.Tracer {
+		if r.Header.Get("X-Tenant") == "acme" {
+			return tenant
+		}
+		return nil
+	}), MWHandlerSpan(true))
+
+	parent := tenant.StartSpan("parent")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	tenant.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if got, want := len(tenant.FinishedSpans()), 2; got != want {
+		t.Fatalf("got %d spans for the tenant, expected %d", got, want)
+	}
+	if got := tenant.FinishedSpans()[1].ParentID; got != parent.Context().(mocktracer.MockSpanContext).SpanID {
+		t.Fatalf("got parent %d, expected the span of the tenant", got)
+	}
+	if got, want := len(fallback.FinishedSpans()), 2; got != want {
+		t.Fatalf("got %d spans for the default tracer, expected %d", got, want)
+	}
+}
+
+func TestMWHTMLTraceMeta(t *testing.T) {
+	tr := mocktracer.New()
+	meta := func() string {
+		return `<meta name="trace-id" content="` + strconv.Itoa(tr.FinishedSpans()[0].SpanContext.TraceID) + `">`
+	}
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected func() string
+	}{
+		{"split head", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Length", "60")
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "<!DOCTYPE html><html><HE")
+			io.WriteString(w, "AD lang=\"en\"><title>t</title></head></html>")
+		}, func() string {
+			return "<!DOCTYPE html><html><HEAD lang=\"en\">" + meta() + "<title>t</title></head></html>"
+		}},
+		{"sniffed", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html><head></head><body></body></html>"))
+		}, func() string {
+			return "<html><head>" + meta() + "</head><body></body></html>"
+		}},
+		{"no head", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><header>x</header></html>"))
+		}, func() string { return "<html><header>x</header></html>" }},
+		{"json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"head": "<head>"}`))
+		}, func() string { return `{"head": "<head>"}` }},
+		{"flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("<head></head></html>"))
+		}, func() string { return "<html><head></head></html>" }},
+		{"read from", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.(io.ReaderFrom).ReadFrom(strings.NewReader("<html><head></head></html>"))
+		}, func() string { return "<html><head>" + meta() + "</head></html>" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr.Reset()
+			srv := httptest.NewServer(Middleware(tr, tt.handler, MWHTMLTraceMeta("trace-id", nil)))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got, want := string(body), tt.expected(); got != want {
+				t.Fatalf("got body %q, expected %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	tr := mocktracer.New()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mw := Middleware(tr, h,
+		MWComponentName("shop"),
+		MWSpanFilter(SkipPaths("/health")),
+		MWSpanSampler(ProbabilisticSampler(0.25)),
+		MWCaptureRequestHeaders([]string{"Authorization"}),
+		MWRedactQueryParams("Token", "api_key"),
+		MWSkipPreflight(true),
+	)
+
+	// defaults set afterwards do not apply to mw, so they are not reported
+	SetDefaultMWOptions(MWComponentName("platform"))
+	defer SetDefaultMWOptions()
+
+	rec := httptest.NewRecorder()
+	ConfigHandler(mw).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tracing", nil))
+	if got, want := rec.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Fatalf("got content type %q, expected %q", got, want)
+	}
+	var config mwConfig
+	if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	expected := mwConfig{
+		Tracer:    "*mocktracer.MockTracer",
+		Options:   []string{"MWComponentName", "MWSpanFilter", "MWSpanSampler", "MWCaptureRequestHeaders", "MWRedactQueryParams", "MWSkipPreflight"},
+		Filters:   []string{"MWSpanFilter", "MWSkipPreflight"},
+		Component: "shop",
+		Sampling:  samplingConfig{Sampler: "probabilistic", Probability: 0.25},
+		Redaction: redactionConfig{
+			HeaderRedactor:      "default",
+			RequestHeaders:      []string{"Authorization"},
+			RedactedQueryParams: []string{"api_key", "token"},
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("got config %+v, expected %+v", config, expected)
+	}
+
+	rec = httptest.NewRecorder()
+	ConfigHandler(MiddlewareFunc(tr, h)).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tracing", nil))
+	if got, want := rec.Code, http.StatusInternalServerError; got != want {
+		t.Fatalf("got status %d for a handler not returned by Middleware, expected %d", got, want)
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	tr := mocktracer.New()
+	tests := []struct {
+		name    string
+		options []MWOption
+		err     string
+	}{
+		{"valid", []MWOption{MWSpanSampler(ProbabilisticSampler(0.5)), MWRedactQueryParams("token")}, ""},
+		{"valid route", []MWOption{MWRedactQueryParams("token"), MWRouteOptions(NewRouteOptions().Add("/admin/*", MWAllowQueryParams("page")))}, ""},
+		{"nil option", []MWOption{MWErrorLogs(true), nil}, "nethttp: option 1 is nil"},
+		{"nil function", []MWOption{MWSpanFilter(nil)}, "nethttp: nil function given to MWSpanFilter"},
+		{"fraction", []MWOption{MWSpanSampler(ProbabilisticSampler(2))}, "nethttp: ProbabilisticSampler fraction 2 is not between 0 and 1"},
+		{"duration", []MWOption{MWMaxSpanLifetime(0, nil)}, "nethttp: MWMaxSpanLifetime duration 0s is not positive"},
+		{"conflict", []MWOption{MWRedactQueryParams("token"), MWAllowQueryParams("page")}, "nethttp: MWRedactQueryParams and MWAllowQueryParams override each other"},
+		{"route", []MWOption{MWRouteOptions(NewRouteOptions().Add("/admin/*", MWErrorFunc(nil)))}, "nethttp: nil function given to MWErrorFunc, for route /admin/*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw, err := NewMiddleware(tr, tt.options...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, expected %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr.Reset()
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		})
+	}
+}
+
+func TestNewTracingMiddleware(t *testing.T) {
+	tr := mocktracer.New()
+	chain := func(h http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+	var traced bool
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traced = opentracing.SpanFromContext(r.Context()) != nil
+	}), NewTracingMiddleware(tr, MWComponentName("shop")))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !traced {
+		t.Fatal("expected the request to be traced")
+	}
+	if got := tr.FinishedSpans()[0].Tag(string(ext.Component)); got != "shop" {
+		t.Fatalf("got component %v, expected shop", got)
+	}
+}
+
+func TestTraceIDFromContext(t *testing.T) {
+	tr := mocktracer.New()
+	var traceID, spanID string
+	var traceOK, spanOK bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID, traceOK = TraceIDFromContext(r.Context())
+		spanID, spanOK = SpanIDFromContext(r.Context())
+	})
+	Middleware(tr, h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	sc := tr.FinishedSpans()[0].Context().(mocktracer.MockSpanContext)
+	if want := strconv.Itoa(sc.TraceID); !traceOK || traceID != want {
+		t.Fatalf("got trace ID %q, %v, expected %q", traceID, traceOK, want)
+	}
+	if want := strconv.Itoa(sc.SpanID); !spanOK || spanID != want {
+		t.Fatalf("got span ID %q, %v, expected %q", spanID, spanOK, want)
+	}
+	if id, ok := TraceIDFromContext(context.Background()); ok {
+		t.Fatalf("got trace ID %q without span", id)
+	}
+	ctx := opentracing.ContextWithSpan(context.Background(), opentracing.NoopTracer{}.StartSpan("noop"))
+	if id, ok := TraceIDFromContext(ctx); ok {
+		t.Fatalf("got trace ID %q for a noop span", id)
+	}
+}
+
+func TestSetDefaultMWOptions(t *testing.T) {
+	SetDefaultMWOptions(MWComponentName("platform"), MWRedactQueryParams("token"))
+	defer SetDefaultMWOptions()
+
+	tr := mocktracer.New()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	Middleware(tr, h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?token=secret", nil))
+	Middleware(tr, h, MWComponentName("shop")).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?token=secret", nil))
+
+	spans := tr.FinishedSpans()
+	for i, want := range []string{"platform", "shop"} {
+		if got := spans[i].Tag(string(ext.Component)); got != want {
+			t.Fatalf("got component %v, expected %s", got, want)
+		}
+		if got, want := spans[i].Tag(string(ext.HTTPUrl)), "/?token="+RedactedValue; got != want {
+			t.Fatalf("got url %v, expected %s", got, want)
+		}
+	}
+}
+
+func TestMWTagFilter(t *testing.T) {
+	tr := mocktracer.New()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := opentracing.SpanFromContext(r.Context())
+		sp.SetTag("user.id", "42")
+		sp.SetTag("user.tier", "gold")
+		sp.SetTag("user.tier", "free")
+		sp.LogKV("event", "login", "user.id", "42")
+		sp.LogFields(log.String("user.id", "42"))
+	})
+	rejected := []string{string(ext.HTTPUrl), "url.full", "http.raw_url", "user.id", string(ext.SpanKind), RootIDHintTag}
+	mw := Middleware(tr, h,
+		MWDualTagNames(TagGroupHTTP),
+		MWRootIDHint(func(r *http.Request) string { return "hint" }),
+		MWSpanObserver(func(sp opentracing.Span, r *http.Request) {
+			sp.SetTag("http.raw_url", r.URL.String())
+		}),
+		MWTagFilter(func(key string, value interface{}) bool {
+			for _, k := range rejected {
+				if key == k {
+					return false
+				}
+			}
+			return true
+		}))
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42?token=secret", nil))
+
+	sp := tr.FinishedSpans()[0]
+	for _, key := range rejected {
+		if got := sp.Tag(key); got != nil {
+			t.Fatalf("got %s %v, expected it to be filtered out", key, got)
+		}
+	}
+	logs := sp.Logs()
+	if got, want := len(logs), 1; got != want {
+		t.Fatalf("got %d logs, expected %d", got, want)
+	}
+	if got, want := len(logs[0].Fields), 1; got != want || logs[0].Fields[0].Key != "event" {
+		t.Fatalf("got log fields %v, expected only the event", logs[0].Fields)
+	}
+	if got := sp.Tag("user.tier"); got != "free" {
+		t.Fatalf("got user.tier %v, expected free", got)
+	}
+	if got := sp.Tag(string(ext.HTTPMethod)); got != "GET" {
+		t.Fatalf("got method %v, expected GET", got)
+	}
+}
+
+func TestMWTagFilterSamplingPriority(t *testing.T) {
+	key := []byte("secret")
+	var sampled bool
+	tr := &samplingTracer{MockTracer: mocktracer.New()}
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := opentracing.SpanFromContext(r.Context())
+		sp.SetOperationName("renamed").SetTag("user.id", "42")
+		sampled = isSampled(sp)
+	}),
+		MWDebugTraceHeader(HMACDebugTraceVerifier(key, time.Minute)),
+		MWTagFilter(func(key string, value interface{}) bool {
+			return key != "user.id"
+		}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(DebugTraceHeader, SignDebugTrace(key, time.Now()))
+	mw.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !sampled {
+		t.Fatal("sampling.priority is not set on the span before the handler runs")
+	}
+	sp := tr.FinishedSpans()[0]
+	if got := sp.Tag("user.id"); got != nil {
+		t.Fatalf("got user.id %v set after SetOperationName, expected it to be filtered out", got)
+	}
+	if got, want := sp.OperationName, "renamed"; got != want {
+		t.Fatalf("got operation name %q, expected %q", got, want)
+	}
+}
+
+func TestMWAsyncFinisher(t *testing.T) {
+	f := NewAsyncFinisher(0, 0, 0)
+	defer f.Close()
+	finishing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	tr := mocktracer.New()
+	mw := Middleware(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		MWAsyncFinisher(f),
+		MWSpanAfterFinish(func(ctx context.Context, sp opentracing.Span, r *http.Request) {
+			if r.URL.Path == "/1" {
+				finishing <- struct{}{}
+				<-release
+			}
+		}))
+
+	// the first span blocks the single worker, the second one fills the
+	// queue of one, and the third one overflows and is finished in the
+	// request path
+	mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/1", nil))
+	<-finishing
+	for _, path := range []string{"/2", "/3"} {
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	overflowed, finished := f.Overflowed(), len(tr.FinishedSpans())
+	close(release)
+	f.Close()
+	if got, want := overflowed, int64(1); got != want {
+		t.Fatalf("got %d overflowed spans, expected %d", got, want)
+	}
+	// the first span is finished before its MWSpanAfterFinish hook blocks
+	if got, want := finished, 2; got != want {
+		t.Fatalf("got %d spans finished before the worker was released, expected %d", got, want)
+	}
+
+	spans := tr.FinishedSpans()
+	if got, want := len(spans), 3; got != want {
+		t.Fatalf("got %d spans, expected %d", got, want)
+	}
+	for i, want := range []string{"/1", "/3", "/2"} {
+		if got := spans[i].Tag(string(ext.HTTPUrl)); got != want {
+			t.Fatalf("got span of %v, expected %s", got, want)
+		}
+	}
+}
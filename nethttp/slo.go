@@ -0,0 +1,60 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// SLOClass is a class of operations sharing the same service level
+// objectives. A request violates the objectives if its span is tagged
+// as an error, see MWErrorFunc, or if it lasts longer than Latency.
+type SLOClass struct {
+	Name string
+	// Latency is the latency objective. Zero means there is none.
+	Latency time.Duration
+}
+
+type sloOptions struct {
+	classes      map[string]SLOClass
+	defaultClass *SLOClass
+}
+
+// MWSLOClasses returns a MWOption that maps the operation name of each
+// server-side span to an SLOClass, and tags the span with slo.class and
+// slo.violated=true/false so that error budgets can be computed from
+// trace data. Violations are detailed by the slo.violation tag, either
+// "error" or "latency". Operations missing from classes use
+// defaultClass, or are not tagged if it is nil.
+func MWSLOClasses(classes map[string]SLOClass, defaultClass *SLOClass) MWOption {
+	return func(options *mwOptions) {
+		options.slo = &sloOptions{
+			classes:      classes,
+			defaultClass: defaultClass,
+		}
+	}
+}
+
+func (o *sloOptions) tag(sp opentracing.Span, operationName string, isError bool, elapsed time.Duration) {
+	class, ok := o.classes[operationName]
+	if !ok {
+		if o.defaultClass == nil {
+			return
+		}
+		class = *o.defaultClass
+	}
+	sp.SetTag("slo.class", class.Name)
+	switch {
+	case isError:
+		sp.SetTag("slo.violated", true)
+		sp.SetTag("slo.violation", "error")
+	case class.Latency > 0 && elapsed > class.Latency:
+		sp.SetTag("slo.violated", true)
+		sp.SetTag("slo.violation", "latency")
+	default:
+		sp.SetTag("slo.violated", false)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// statusErrorKind is the error.kind of the error logs of responses
+// whose status is an error.
+const statusErrorKind = "HTTPStatus"
+
+// MWErrorLogs returns a MWOption that turns on or off logging the error
+// of server-side spans tagged as errors, following the OpenTracing
+// semantic conventions, so that backends such as Jaeger show what went
+// wrong: an event=error log with error.kind and message fields, plus
+// error.object and stack for panics. Responses whose status is an error
+// are logged with error.kind=HTTPStatus and a message such as
+// "503 Service Unavailable".
+//
+// Panics recovered by MWPanicHandler are always logged this way; with
+// this option, those that are not are logged too.
+func MWErrorLogs(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.errorLogs = enabled
+	}
+}
+
+// ClientErrorLogs returns a ClientOption that turns on or off logging
+// the error of client-side spans, see MWErrorLogs: responses whose
+// status is an error, and failed round trips, which are then tagged as
+// errors too, with error.kind the type of the error returned by the
+// RoundTripper.
+func ClientErrorLogs(enabled bool) ClientOption {
+	return func(options *clientOptions) {
+		options.errorLogs = enabled
+	}
+}
+
+// logStatusError logs the error of a response with the given status.
+func logStatusError(sp opentracing.Span, status int) {
+	message := strconv.Itoa(status)
+	if text := http.StatusText(status); text != "" {
+		message += " " + text
+	}
+	sp.LogFields(
+		log.String("event", "error"),
+		log.String("error.kind", statusErrorKind),
+		log.String("message", message),
+	)
+}
+
+// logErrorValue logs the error or panic value v, with the stack if not
+// nil.
+func logErrorValue(sp opentracing.Span, v interface{}, stack []byte) {
+	fields := []log.Field{
+		log.String("event", "error"),
+		log.String("error.kind", fmt.Sprintf("%T", v)),
+		log.Object("error.object", v),
+		log.String("message", panicMessage(v)),
+	}
+	if stack != nil {
+		fields = append(fields, log.String("stack", string(stack)))
+	}
+	sp.LogFields(fields...)
+}
+
+// logPanic logs the panic v with the stack of the panicking goroutine,
+// which is still complete in the deferred functions.
+func logPanic(sp opentracing.Span, v interface{}) {
+	logErrorValue(sp, v, debug.Stack())
+}
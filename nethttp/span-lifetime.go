@@ -0,0 +1,104 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+type spanLifetimeOptions struct {
+	max time.Duration
+	f   func(sp opentracing.Span, r *http.Request)
+}
+
+// MWMaxSpanLifetime returns a MWOption that force-finishes the span of a
+// request still open after d, so that the spans of handlers that never
+// return, e.g. streaming ones, or of hijacked connections never closed,
+// are reported instead of leaked. The span is tagged forced_finish=true
+// before being finished, then f, if not nil, is called from another
+// goroutine, e.g. to report which handler leaks. The span is already
+// finished then and must not be modified.
+//
+// Tags and logs set on the span after it is force-finished are dropped,
+// and it is not finished again when the handler returns.
+func MWMaxSpanLifetime(d time.Duration, f func(sp opentracing.Span, r *http.Request)) MWOption {
+	return func(options *mwOptions) {
+		options.spanLifetime = &spanLifetimeOptions{max: d, f: f}
+	}
+}
+
+// watch returns sp wrapped to be force-finished once its maximum
+// lifetime is exceeded.
+func (o *spanLifetimeOptions) watch(sp opentracing.Span, r *http.Request) opentracing.Span {
+	s := &lifetimeSpan{Span: sp}
+	s.timer = time.AfterFunc(o.max, func() {
+		if !s.finish() {
+			return
+		}
+		sp.SetTag("forced_finish", true)
+		sp.LogFields(
+			log.String("event", "ForcedFinish"),
+			log.Int64("max_lifetime_ms", int64(o.max/time.Millisecond)),
+		)
+		sp.Finish()
+		if o.f != nil {
+			o.f(s, r)
+		}
+	})
+	return s
+}
+
+// lifetimeSpan is finished once, either by its owner or by the timer
+// enforcing its maximum lifetime, and ignores tags and logs once
+// finished.
+type lifetimeSpan struct {
+	opentracing.Span
+	timer    *time.Timer
+	finished int32
+}
+
+// finish reports whether the span was not finished yet, marking it
+// finished.
+func (s *lifetimeSpan) finish() bool {
+	return atomic.CompareAndSwapInt32(&s.finished, 0, 1)
+}
+
+func (s *lifetimeSpan) isFinished() bool {
+	return atomic.LoadInt32(&s.finished) == 1
+}
+
+func (s *lifetimeSpan) SetTag(key string, value interface{}) opentracing.Span {
+	if !s.isFinished() {
+		s.Span.SetTag(key, value)
+	}
+	return s
+}
+
+func (s *lifetimeSpan) LogFields(fields ...log.Field) {
+	if !s.isFinished() {
+		s.Span.LogFields(fields...)
+	}
+}
+
+func (s *lifetimeSpan) LogKV(alternatingKeyValues ...interface{}) {
+	if !s.isFinished() {
+		s.Span.LogKV(alternatingKeyValues...)
+	}
+}
+
+func (s *lifetimeSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *lifetimeSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	if s.finish() {
+		s.timer.Stop()
+		s.Span.FinishWithOptions(opts)
+	}
+}
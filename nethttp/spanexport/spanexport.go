@@ -0,0 +1,122 @@
+// Package spanexport converts the spans recorded by mocktracer into a
+// stable, versioned JSON schema, for golden file tests and offline
+// analysis that must not depend on mocktracer internals.
+package spanexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+// SchemaVersion is the version of the schema produced by this package.
+// It changes whenever the schema changes incompatibly.
+const SchemaVersion = 1
+
+// Export is the exported form of a set of spans.
+type Export struct {
+	SchemaVersion int    `json:"schema_version"`
+	Spans         []Span `json:"spans"`
+}
+
+// Span is the exported form of a span. Span and trace IDs are numbered
+// from 1 in the order the spans are exported, so that they do not
+// depend on the IDs generated by the tracer. Timestamps are left out
+// for the same reason.
+type Span struct {
+	ID         int                    `json:"id"`
+	Trace      int                    `json:"trace"`
+	Operation  string                 `json:"operation"`
+	Tags       map[string]interface{} `json:"tags,omitempty"`
+	Logs       []Log                  `json:"logs,omitempty"`
+	References []Reference            `json:"references,omitempty"`
+}
+
+// Log is the exported form of a span log.
+type Log struct {
+	Fields []Field `json:"fields"`
+}
+
+// Field is a log field. Values are recorded as strings by mocktracer,
+// Kind keeps their original kind, e.g. "int64".
+type Field struct {
+	Key   string `json:"key"`
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Reference is a reference to another span. Span is 0 if the referenced
+// span is not part of the export.
+type Reference struct {
+	Type string `json:"type"`
+	Span int    `json:"span"`
+}
+
+// FromMockSpans converts spans, typically MockTracer.FinishedSpans().
+func FromMockSpans(spans []*mocktracer.MockSpan) Export {
+	spanIDs := make(map[int]int, len(spans))
+	traceIDs := make(map[int]int)
+	for i, sp := range spans {
+		spanIDs[sp.SpanContext.SpanID] = i + 1
+		if _, ok := traceIDs[sp.SpanContext.TraceID]; !ok {
+			traceIDs[sp.SpanContext.TraceID] = len(traceIDs) + 1
+		}
+	}
+
+	export := Export{SchemaVersion: SchemaVersion, Spans: make([]Span, 0, len(spans))}
+	for i, sp := range spans {
+		s := Span{
+			ID:        i + 1,
+			Trace:     traceIDs[sp.SpanContext.TraceID],
+			Operation: sp.OperationName,
+		}
+		for k, v := range sp.Tags() {
+			if s.Tags == nil {
+				s.Tags = make(map[string]interface{})
+			}
+			s.Tags[k] = tagValue(v)
+		}
+		for _, l := range sp.Logs() {
+			log := Log{Fields: make([]Field, 0, len(l.Fields))}
+			for _, f := range l.Fields {
+				log.Fields = append(log.Fields, Field{Key: f.Key, Kind: f.ValueKind.String(), Value: f.ValueString})
+			}
+			s.Logs = append(s.Logs, log)
+		}
+		if sp.ParentID != 0 {
+			s.References = append(s.References, Reference{Type: "child_of", Span: spanIDs[sp.ParentID]})
+		}
+		export.Spans = append(export.Spans, s)
+	}
+	return export
+}
+
+// Marshal returns the indented JSON encoding of the export of spans.
+func Marshal(spans []*mocktracer.MockSpan) ([]byte, error) {
+	return json.MarshalIndent(FromMockSpans(spans), "", "  ")
+}
+
+// tagValue returns v as a value with a stable JSON encoding: booleans,
+// numbers and strings are kept, anything else is formatted with
+// fmt.Sprint.
+func tagValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,74 @@
+package spanexport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+const golden = `{
+  "schema_version": 1,
+  "spans": [
+    {
+      "id": 1,
+      "trace": 1,
+      "operation": "child",
+      "tags": {
+        "error.object": "boom",
+        "http.status_code": 500,
+        "span.kind": "client"
+      },
+      "logs": [
+        {
+          "fields": [
+            {
+              "key": "event",
+              "kind": "string",
+              "value": "error"
+            },
+            {
+              "key": "size",
+              "kind": "int64",
+              "value": "42"
+            }
+          ]
+        }
+      ],
+      "references": [
+        {
+          "type": "child_of",
+          "span": 2
+        }
+      ]
+    },
+    {
+      "id": 2,
+      "trace": 1,
+      "operation": "parent"
+    }
+  ]
+}`
+
+func TestMarshal(t *testing.T) {
+	tr := mocktracer.New()
+	parent := tr.StartSpan("parent")
+	child := tr.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	ext.SpanKindRPCClient.Set(child)
+	ext.HTTPStatusCode.Set(child, 500)
+	child.SetTag("error.object", errors.New("boom"))
+	child.LogFields(log.String("event", "error"), log.Int64("size", 42))
+	child.Finish()
+	parent.Finish()
+
+	b, err := Marshal(tr.FinishedSpans())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != golden {
+		t.Fatalf("got\n%s\nexpected\n%s", got, golden)
+	}
+}
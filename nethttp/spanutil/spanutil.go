@@ -0,0 +1,79 @@
+// Package spanutil carries span contexts across message queues, in a
+// format compatible with the headers injected by nethttp.Transport.
+package spanutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// ErrNoSpan is returned when the context given to an injection helper
+// does not carry a span.
+var ErrNoSpan = errors.New("spanutil: no span in context")
+
+// Inject injects the span context of the span in ctx into w. Any
+// message header collection can be supported by implementing the
+// Set(key, value string) method of opentracing.TextMapWriter, e.g. for
+// a Kafka client:
+//
+//	type kafkaHeaders struct{ h *[]kafka.Header }
+//
+//	func (c kafkaHeaders) Set(key, value string) {
+//		*c.h = append(*c.h, kafka.Header{Key: key, Value: []byte(value)})
+//	}
+//
+// The span context is encoded with the opentracing.HTTPHeaders format
+// used by nethttp.Transport, so the same keys and values end up in the
+// message as in an outgoing HTTP request.
+func Inject(ctx context.Context, w opentracing.TextMapWriter) error {
+	sp := opentracing.SpanFromContext(ctx)
+	if sp == nil {
+		return ErrNoSpan
+	}
+	h := http.Header{}
+	if err := sp.Tracer().Inject(sp.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h)); err != nil {
+		return err
+	}
+	for k, vv := range h {
+		for _, v := range vv {
+			w.Set(k, v)
+		}
+	}
+	return nil
+}
+
+// InjectMap injects the span context of the span in ctx into m, see
+// Inject.
+func InjectMap(ctx context.Context, m map[string]string) error {
+	return Inject(ctx, opentracing.TextMapCarrier(m))
+}
+
+// Extract extracts a span context from r, typically the headers of a
+// consumed message. It accepts the keys and values written by Inject
+// and by nethttp.Transport, whatever the case of the keys.
+func Extract(tr opentracing.Tracer, r opentracing.TextMapReader) (opentracing.SpanContext, error) {
+	h := http.Header{}
+	err := r.ForeachKey(func(key, val string) error {
+		h.Add(key, val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tr.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+}
+
+// ContextFromMap extracts a span context from m, see Extract. The
+// result can be used to start the span of the message processing, e.g.
+// before making HTTP calls traced with nethttp.Transport:
+//
+//	sc, _ := spanutil.ContextFromMap(tracer, msg.Headers)
+//	sp := tracer.StartSpan("consume", opentracing.FollowsFrom(sc))
+//	defer sp.Finish()
+//	ctx = opentracing.ContextWithSpan(ctx, sp)
+func ContextFromMap(tr opentracing.Tracer, m map[string]string) (opentracing.SpanContext, error) {
+	return Extract(tr, opentracing.TextMapCarrier(m))
+}
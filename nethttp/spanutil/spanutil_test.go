@@ -0,0 +1,88 @@
+package spanutil
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+type byteHeader struct {
+	Key   string
+	Value []byte
+}
+
+type byteHeaders struct {
+	h *[]byteHeader
+}
+
+func (c byteHeaders) Set(key, value string) {
+	*c.h = append(*c.h, byteHeader{Key: key, Value: []byte(value)})
+}
+
+func TestInject(t *testing.T) {
+	tr := mocktracer.New()
+	sp := tr.StartSpan("handler")
+	ctx := opentracing.ContextWithSpan(context.Background(), sp)
+
+	m := map[string]string{}
+	if err := InjectMap(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m) == 0 {
+		t.Fatal("span context was not injected into the map")
+	}
+
+	var headers []byteHeader
+	if err := Inject(ctx, byteHeaders{&headers}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(headers), len(m); got != want {
+		t.Fatalf("got %d headers, expected %d", got, want)
+	}
+	for _, h := range headers {
+		if got, want := string(h.Value), m[h.Key]; got != want {
+			t.Fatalf("got %q for %s, expected %q", got, h.Key, want)
+		}
+	}
+
+	if err := InjectMap(context.Background(), m); err != ErrNoSpan {
+		t.Fatalf("got %v, expected %v", err, ErrNoSpan)
+	}
+}
+
+func TestContextFromMap(t *testing.T) {
+	tr := mocktracer.New()
+	sp := tr.StartSpan("client")
+	sp.SetBaggageItem("tenant", "acme corp")
+
+	// Headers as injected by nethttp.Transport, with the keys lower-cased
+	// as some message brokers do.
+	h := http.Header{}
+	if err := tr.Inject(sp.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h)); err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]string{}
+	for k := range h {
+		m[strings.ToLower(k)] = h.Get(k)
+	}
+
+	sc, err := ContextFromMap(tr, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msc := sc.(mocktracer.MockSpanContext)
+	if got, want := msc.SpanID, sp.Context().(mocktracer.MockSpanContext).SpanID; got != want {
+		t.Fatalf("got span id %d, expected %d", got, want)
+	}
+	if got, want := msc.Baggage["tenant"], "acme corp"; got != want {
+		t.Fatalf("got baggage %q, expected %q", got, want)
+	}
+
+	if _, err := ContextFromMap(tr, map[string]string{}); err != opentracing.ErrSpanContextNotFound {
+		t.Fatalf("got %v, expected %v", err, opentracing.ErrSpanContextNotFound)
+	}
+}
@@ -0,0 +1,201 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// SSEEvent is a single event read from a text/event-stream response.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+type sseOptions struct {
+	progressInterval time.Duration
+}
+
+// SSEOption controls the behavior of SSEReader.
+type SSEOption func(*sseOptions)
+
+// SSEProgressInterval returns a SSEOption that sets how often the
+// SSEReader logs the running event count and size to the client-side
+// span. A zero or negative interval disables the periodic logs.
+func SSEProgressInterval(d time.Duration) SSEOption {
+	return func(options *sseOptions) {
+		options.progressInterval = d
+	}
+}
+
+// SSEReader reads server-sent events from a response obtained through
+// Transport. The client-side span of the request is kept open while the
+// stream is consumed, periodically logging progress, and is finished
+// with the stream totals once the reader is closed.
+type SSEReader struct {
+	body io.ReadCloser
+	rd   *bufio.Reader
+	sp   opentracing.Span
+	opts sseOptions
+
+	events      int
+	bytes       int
+	lastEvents  int
+	lastBytes   int
+	lastLog     time.Time
+	lastEventID string
+	err         error
+}
+
+// NewSSEReader returns a SSEReader consuming the body of resp. If the
+// request of resp was not traced with TraceRequest, events are still
+// read but nothing is recorded.
+//
+// Example:
+//
+//	req, ht := nethttp.TraceRequest(tracer, req)
+//	defer ht.Finish()
+//	res, err := client.Do(req)
+//	if err != nil {
+//		return err
+//	}
+//	stream := nethttp.NewSSEReader(res)
+//	defer stream.Close()
+//	for {
+//		ev, err := stream.Next()
+//		if err != nil {
+//			return err
+//		}
+//		handle(ev)
+//	}
+func NewSSEReader(resp *http.Response, options ...SSEOption) *SSEReader {
+	opts := sseOptions{
+		progressInterval: 10 * time.Second,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	s := &SSEReader{
+		body:    resp.Body,
+		rd:      bufio.NewReader(resp.Body),
+		opts:    opts,
+		lastLog: time.Now(),
+	}
+	if resp.Request != nil {
+		if tracer := TracerFromRequest(resp.Request); tracer != nil {
+			s.sp = tracer.sp
+		}
+	}
+	if s.sp != nil {
+		s.sp.SetTag("http.content_type", resp.Header.Get("Content-Type"))
+		s.sp.LogFields(log.String("event", "SSEStreamStart"))
+	}
+	return s
+}
+
+// Next blocks until the next event is dispatched by the server and
+// returns it. At the end of the stream io.EOF is returned.
+func (s *SSEReader) Next() (*SSEEvent, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	ev := &SSEEvent{}
+	var data []string
+	hasData := false
+	for {
+		line, err := s.rd.ReadString('\n')
+		s.bytes += len(line)
+		if err != nil {
+			// An event that is not terminated by a blank line is
+			// discarded, as required by the specification.
+			s.err = err
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if !hasData {
+				ev = &SSEEvent{}
+				continue
+			}
+			ev.Data = strings.Join(data, "\n")
+			if ev.ID != "" {
+				s.lastEventID = ev.ID
+			}
+			s.events++
+			s.logProgress()
+			return ev, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		case "id":
+			ev.ID = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				ev.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+func (s *SSEReader) logProgress() {
+	if s.sp == nil || s.opts.progressInterval <= 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(s.lastLog) < s.opts.progressInterval {
+		return
+	}
+	s.sp.LogFields(
+		log.String("event", "SSEProgress"),
+		log.Int("sse.events", s.events-s.lastEvents),
+		log.Int("sse.bytes", s.bytes-s.lastBytes),
+	)
+	s.lastEvents, s.lastBytes, s.lastLog = s.events, s.bytes, now
+}
+
+// LastEventID returns the ID of the last event read, which can be sent
+// in the Last-Event-ID header when reconnecting.
+func (s *SSEReader) LastEventID() string {
+	return s.lastEventID
+}
+
+// Close closes the response body, records the stream totals and
+// finishes the client-side span.
+func (s *SSEReader) Close() error {
+	if s.sp != nil {
+		s.sp.SetTag("sse.events", s.events)
+		s.sp.SetTag("sse.bytes", s.bytes)
+		fields := []log.Field{
+			log.String("event", "SSEStreamEnd"),
+			log.Int("sse.events", s.events),
+			log.Int("sse.bytes", s.bytes),
+		}
+		if s.err != nil && s.err != io.EOF {
+			fields = append(fields, log.Error(s.err))
+		}
+		s.sp.LogFields(fields...)
+	}
+	return s.body.Close()
+}
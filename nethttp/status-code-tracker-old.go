@@ -1,3 +1,6 @@
+// Code generated by gen-wrappers.go; DO NOT EDIT.
+
+//go:build go1.7 && !go1.8
 // +build go1.7,!go1.8
 
 package nethttp
@@ -7,139 +10,126 @@ import (
 	"net/http"
 )
 
-type statusCodeTracker struct {
-	http.ResponseWriter
-	status      int
-	wroteheader bool
-}
-
-func (w *statusCodeTracker) WriteHeader(status int) {
-	w.status = status
-	w.wroteheader = true
-	w.ResponseWriter.WriteHeader(status)
-}
-
-func (w *statusCodeTracker) Write(b []byte) (int, error) {
-	if !w.wroteheader {
-		w.wroteheader = true
-		w.status = 200
-	}
-	return w.ResponseWriter.Write(b)
-}
-
 // wrappedResponseWriter returns a wrapped version of the original
 // ResponseWriter and only implements the same combination of additional
-// interfaces as the original.  This implementation is based on
-// https://github.com/felixge/httpsnoop.
+// interfaces as the original. Every interface is implemented by the
+// tracker itself, so that calls through them are tracked as well.
+// This implementation is based on https://github.com/felixge/httpsnoop.
 func (w *statusCodeTracker) wrappedResponseWriter() http.ResponseWriter {
-	var (
-		hj, i0 = w.ResponseWriter.(http.Hijacker)
-		cn, i1 = w.ResponseWriter.(http.CloseNotifier)
-		fl, i3 = w.ResponseWriter.(http.Flusher)
-		rf, i4 = w.ResponseWriter.(io.ReaderFrom)
-	)
-	i2 := false
+	var i int
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		i |= 1 << 0
+	}
+	if _, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		i |= 1 << 1
+	}
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		i |= 1 << 2
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		i |= 1 << 3
+	}
 
-	switch {
-	case !i0 && !i1 && !i2 && !i3 && !i4:
+	switch i {
+	case 0:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 		}{w}
-	case !i0 && !i1 && !i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			io.ReaderFrom
-		}{w, rf}
-	case !i0 && !i1 && !i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Flusher
-		}{w, fl}
-	case !i0 && !i1 && !i2 && i3 && i4:
+	case 1:
 		return struct {
-			http.ResponseWriter
-			http.Flusher
-			io.ReaderFrom
-		}{w, fl, rf}
-	case !i0 && i1 && !i2 && !i3 && !i4:
+			responseWriter
+			http.Hijacker
+		}{w, w}
+	case 2:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.CloseNotifier
-		}{w, cn}
-	case !i0 && i1 && !i2 && !i3 && i4:
+		}{w, w}
+	case 3:
 		return struct {
-			http.ResponseWriter
+			responseWriter
+			http.Hijacker
 			http.CloseNotifier
-			io.ReaderFrom
-		}{w, cn, rf}
-	case !i0 && i1 && !i2 && i3 && !i4:
+		}{w, w, w}
+	case 4:
 		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
+			responseWriter
+			http.Flusher
+		}{w, w}
+	case 5:
+		return struct {
+			responseWriter
+			http.Hijacker
 			http.Flusher
-		}{w, cn, fl}
-	case !i0 && i1 && !i2 && i3 && i4:
+		}{w, w, w}
+	case 6:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.CloseNotifier
 			http.Flusher
-			io.ReaderFrom
-		}{w, cn, fl, rf}
-	case i0 && !i1 && !i2 && !i3 && !i4:
+		}{w, w, w}
+	case 7:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.Hijacker
-		}{w, hj}
-	case i0 && !i1 && !i2 && !i3 && i4:
+			http.CloseNotifier
+			http.Flusher
+		}{w, w, w, w}
+	case 8:
 		return struct {
-			http.ResponseWriter
-			http.Hijacker
+			responseWriter
 			io.ReaderFrom
-		}{w, hj, rf}
-	case i0 && !i1 && !i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Flusher
-		}{w, hj, fl}
-	case i0 && !i1 && !i2 && i3 && i4:
+		}{w, w}
+	case 9:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.Hijacker
-			http.Flusher
 			io.ReaderFrom
-		}{w, hj, fl, rf}
-	case i0 && i1 && !i2 && !i3 && !i4:
+		}{w, w, w}
+	case 10:
 		return struct {
-			http.ResponseWriter
-			http.Hijacker
+			responseWriter
 			http.CloseNotifier
-		}{w, hj, cn}
-	case i0 && i1 && !i2 && !i3 && i4:
+			io.ReaderFrom
+		}{w, w, w}
+	case 11:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.Hijacker
 			http.CloseNotifier
 			io.ReaderFrom
-		}{w, hj, cn, rf}
-	case i0 && i1 && !i2 && i3 && !i4:
+		}{w, w, w, w}
+	case 12:
+		return struct {
+			responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case 13:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.Hijacker
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 14:
+		return struct {
+			responseWriter
 			http.CloseNotifier
 			http.Flusher
-		}{w, hj, cn, fl}
-	case i0 && i1 && !i2 && i3 && i4:
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 15:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 			http.Hijacker
 			http.CloseNotifier
 			http.Flusher
 			io.ReaderFrom
-		}{w, hj, cn, fl, rf}
+		}{w, w, w, w, w}
 	default:
 		return struct {
-			http.ResponseWriter
+			responseWriter
 		}{w}
 	}
 }
@@ -0,0 +1,12 @@
+//go:build go1.8
+// +build go1.8
+
+package nethttp
+
+import (
+	"net/http"
+)
+
+func (w *statusCodeTracker) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
@@ -0,0 +1,250 @@
+// Code generated by gen-wrappers.go; DO NOT EDIT.
+
+//go:build go1.8
+// +build go1.8
+
+package nethttp
+
+import (
+	"io"
+	"net/http"
+)
+
+// wrappedResponseWriter returns a wrapped version of the original
+// ResponseWriter and only implements the same combination of additional
+// interfaces as the original. Every interface is implemented by the
+// tracker itself, so that calls through them are tracked as well.
+// This implementation is based on https://github.com/felixge/httpsnoop.
+func (w *statusCodeTracker) wrappedResponseWriter() http.ResponseWriter {
+	var i int
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		i |= 1 << 0
+	}
+	if _, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		i |= 1 << 1
+	}
+	if _, ok := w.ResponseWriter.(http.Pusher); ok {
+		i |= 1 << 2
+	}
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		i |= 1 << 3
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		i |= 1 << 4
+	}
+
+	switch i {
+	case 0:
+		return struct {
+			responseWriter
+		}{w}
+	case 1:
+		return struct {
+			responseWriter
+			http.Hijacker
+		}{w, w}
+	case 2:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+		}{w, w}
+	case 3:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{w, w, w}
+	case 4:
+		return struct {
+			responseWriter
+			http.Pusher
+		}{w, w}
+	case 5:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, w, w}
+	case 6:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			http.Pusher
+		}{w, w, w}
+	case 7:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{w, w, w, w}
+	case 8:
+		return struct {
+			responseWriter
+			http.Flusher
+		}{w, w}
+	case 9:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.Flusher
+		}{w, w, w}
+	case 10:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			http.Flusher
+		}{w, w, w}
+	case 11:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Flusher
+		}{w, w, w, w}
+	case 12:
+		return struct {
+			responseWriter
+			http.Pusher
+			http.Flusher
+		}{w, w, w}
+	case 13:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.Pusher
+			http.Flusher
+		}{w, w, w, w}
+	case 14:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			http.Pusher
+			http.Flusher
+		}{w, w, w, w}
+	case 15:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+			http.Flusher
+		}{w, w, w, w, w}
+	case 16:
+		return struct {
+			responseWriter
+			io.ReaderFrom
+		}{w, w}
+	case 17:
+		return struct {
+			responseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w}
+	case 18:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			io.ReaderFrom
+		}{w, w, w}
+	case 19:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 20:
+		return struct {
+			responseWriter
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w}
+	case 21:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 22:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 23:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case 24:
+		return struct {
+			responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case 25:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 26:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 27:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case 28:
+		return struct {
+			responseWriter
+			http.Pusher
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 29:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.Pusher
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case 30:
+		return struct {
+			responseWriter
+			http.CloseNotifier
+			http.Pusher
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case 31:
+		return struct {
+			responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w, w, w}
+	default:
+		return struct {
+			responseWriter
+		}{w}
+	}
+}
@@ -1,257 +1,234 @@
-// +build go1.8
+//go:build go1.7
+// +build go1.7
 
 package nethttp
 
+//go:generate go run gen-wrappers.go
+
 import (
+	"bufio"
+	"hash"
 	"io"
+	"net"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
 )
 
+// responseWriter is the set of methods exposed by every wrapped
+// ResponseWriter, regardless of the original one.
+type responseWriter interface {
+	http.ResponseWriter
+	WriteString(s string) (int, error)
+	Unwrap() http.ResponseWriter
+}
+
 type statusCodeTracker struct {
 	http.ResponseWriter
 	status      int
 	wroteheader bool
+	size        int64
+
+	// superfluous counts the WriteHeader calls made after the headers
+	// were sent, which net/http ignores, and superfluousStatus is the
+	// status of the first one.
+	superfluous       int
+	superfluousStatus int
+
+	// headerHooks are called right before the headers are sent, either
+	// explicitly by WriteHeader or implicitly by the first write.
+	headerHooks []func(status int)
+
+	// timing turns on recording the time of the last write.
+	timing    bool
+	lastWrite time.Time
+	flushes   int
+
+	hijacked bool
+	// hijackHook, if not nil, wraps the connection returned by Hijack.
+	hijackHook func(conn net.Conn) net.Conn
+
+	// bodyHash, if not nil, is fed the body written.
+	bodyHash hash.Hash
+	// html, if not nil, injects an element into the HTML body written.
+	html *htmlInjector
+	// sp, if not nil, is the span informational responses are logged to.
+	sp opentracing.Span
 }
 
-func (w *statusCodeTracker) WriteHeader(status int) {
-	w.status = status
+// trackerPool recycles the trackers of finished requests, since one is
+// needed for every request served.
+var trackerPool = sync.Pool{
+	New: func() interface{} { return new(statusCodeTracker) },
+}
+
+// newStatusCodeTracker returns a tracker for w from the pool. It must be
+// released once the handler has returned and the tracker is no longer
+// read.
+func newStatusCodeTracker(w http.ResponseWriter) *statusCodeTracker {
+	sct := trackerPool.Get().(*statusCodeTracker)
+	sct.ResponseWriter = w
+	return sct
+}
+
+// release resets w and puts it back in the pool. Like the ResponseWriter
+// it wraps, w must not be used by the handler after it returned.
+func (w *statusCodeTracker) release() {
+	for i := range w.headerHooks {
+		w.headerHooks[i] = nil
+	}
+	*w = statusCodeTracker{headerHooks: w.headerHooks[:0]}
+	trackerPool.Put(w)
+}
+
+func (w *statusCodeTracker) writingHeader(status int) {
 	w.wroteheader = true
+	w.status = status
+	for _, f := range w.headerHooks {
+		f(status)
+	}
+}
+
+// Unwrap returns the original ResponseWriter, so that
+// http.ResponseController can reach the methods it does not expose,
+// such as SetReadDeadline.
+func (w *statusCodeTracker) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// wrote records that n bytes of the body were written.
+func (w *statusCodeTracker) wrote(n int64) {
+	w.size += n
+	if w.timing && n > 0 {
+		w.lastWrite = time.Now()
+	}
+}
+
+// flushHTML sends the part of the HTML body buffered while looking for
+// where to inject an element, if any.
+func (w *statusCodeTracker) flushHTML() {
+	if w.html != nil {
+		w.html.flush(w.ResponseWriter)
+	}
+}
+
+// recordImplicitStatus records status 200 if the handler returned
+// without writing anything, as net/http sends, and runs the header
+// hooks. The header itself is left to be written by net/http, or by the
+// middlewares wrapping this one, which may still set another status.
+func (w *statusCodeTracker) recordImplicitStatus() {
+	if !w.wroteheader && !w.hijacked {
+		w.writingHeader(http.StatusOK)
+	}
+}
+
+func (w *statusCodeTracker) WriteHeader(status int) {
+	if isInformational(status) {
+		// interim responses, such as 103 Early Hints, precede the final
+		// one: they neither set the status nor send the headers.
+		if !w.wroteheader && w.sp != nil {
+			w.sp.LogFields(log.String("event", "InformationalResponse"), log.Int("http.status_code", status))
+		}
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !w.wroteheader {
+		w.writingHeader(status)
+	} else if !w.hijacked {
+		if w.superfluous == 0 {
+			w.superfluousStatus = status
+		}
+		w.superfluous++
+	}
 	w.ResponseWriter.WriteHeader(status)
 }
 
+// isInformational reports whether status is the one of an interim
+// response. 101 Switching Protocols is final.
+func isInformational(status int) bool {
+	return status >= 100 && status < 200 && status != http.StatusSwitchingProtocols
+}
+
 func (w *statusCodeTracker) Write(b []byte) (int, error) {
 	if !w.wroteheader {
-		w.wroteheader = true
-		w.status = 200
+		w.writingHeader(http.StatusOK)
+	}
+	var n int
+	var err error
+	if w.html != nil {
+		n, err = w.html.write(w.ResponseWriter, b)
+	} else {
+		n, err = w.ResponseWriter.Write(b)
+	}
+	if w.bodyHash != nil {
+		w.bodyHash.Write(b[:n])
+	}
+	w.wrote(int64(n))
+	return n, err
+}
+
+func (w *statusCodeTracker) WriteString(s string) (int, error) {
+	sw, ok := w.ResponseWriter.(interface {
+		WriteString(s string) (int, error)
+	})
+	if !ok || w.html != nil {
+		return w.Write([]byte(s))
+	}
+	if !w.wroteheader {
+		w.writingHeader(http.StatusOK)
+	}
+	n, err := sw.WriteString(s)
+	if w.bodyHash != nil {
+		io.WriteString(w.bodyHash, s[:n])
 	}
-	return w.ResponseWriter.Write(b)
+	w.wrote(int64(n))
+	return n, err
 }
 
-// wrappedResponseWriter returns a wrapped version of the original
-// ResponseWriter and only implements the same combination of additional
-// interfaces as the original.  This implementation is based on
-// https://github.com/felixge/httpsnoop.
-func (w *statusCodeTracker) wrappedResponseWriter() http.ResponseWriter {
-	var (
-		hj, i0 = w.ResponseWriter.(http.Hijacker)
-		cn, i1 = w.ResponseWriter.(http.CloseNotifier)
-		pu, i2 = w.ResponseWriter.(http.Pusher)
-		fl, i3 = w.ResponseWriter.(http.Flusher)
-		rf, i4 = w.ResponseWriter.(io.ReaderFrom)
-	)
+// The methods below implement the optional interfaces of the original
+// ResponseWriter. They are only exposed to the handler when the
+// original ResponseWriter implements them, see wrappedResponseWriter.
+
+func (w *statusCodeTracker) Flush() {
+	if !w.wroteheader {
+		w.writingHeader(http.StatusOK)
+	}
+	w.flushes++
+	w.flushHTML()
+	w.ResponseWriter.(http.Flusher).Flush()
+}
 
-	switch {
-	case !i0 && !i1 && !i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-		}{w}
-	case !i0 && !i1 && !i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			io.ReaderFrom
-		}{w, rf}
-	case !i0 && !i1 && !i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Flusher
-		}{w, fl}
-	case !i0 && !i1 && !i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Flusher
-			io.ReaderFrom
-		}{w, fl, rf}
-	case !i0 && !i1 && i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Pusher
-		}{w, pu}
-	case !i0 && !i1 && i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Pusher
-			io.ReaderFrom
-		}{w, pu, rf}
-	case !i0 && !i1 && i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Pusher
-			http.Flusher
-		}{w, pu, fl}
-	case !i0 && !i1 && i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Pusher
-			http.Flusher
-			io.ReaderFrom
-		}{w, pu, fl, rf}
-	case !i0 && i1 && !i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-		}{w, cn}
-	case !i0 && i1 && !i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			io.ReaderFrom
-		}{w, cn, rf}
-	case !i0 && i1 && !i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			http.Flusher
-		}{w, cn, fl}
-	case !i0 && i1 && !i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			http.Flusher
-			io.ReaderFrom
-		}{w, cn, fl, rf}
-	case !i0 && i1 && i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			http.Pusher
-		}{w, cn, pu}
-	case !i0 && i1 && i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			http.Pusher
-			io.ReaderFrom
-		}{w, cn, pu, rf}
-	case !i0 && i1 && i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			http.Pusher
-			http.Flusher
-		}{w, cn, pu, fl}
-	case !i0 && i1 && i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.CloseNotifier
-			http.Pusher
-			http.Flusher
-			io.ReaderFrom
-		}{w, cn, pu, fl, rf}
-	case i0 && !i1 && !i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-		}{w, hj}
-	case i0 && !i1 && !i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			io.ReaderFrom
-		}{w, hj, rf}
-	case i0 && !i1 && !i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Flusher
-		}{w, hj, fl}
-	case i0 && !i1 && !i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Flusher
-			io.ReaderFrom
-		}{w, hj, fl, rf}
-	case i0 && !i1 && i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Pusher
-		}{w, hj, pu}
-	case i0 && !i1 && i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Pusher
-			io.ReaderFrom
-		}{w, hj, pu, rf}
-	case i0 && !i1 && i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Pusher
-			http.Flusher
-		}{w, hj, pu, fl}
-	case i0 && !i1 && i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.Pusher
-			http.Flusher
-			io.ReaderFrom
-		}{w, hj, pu, fl, rf}
-	case i0 && i1 && !i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-		}{w, hj, cn}
-	case i0 && i1 && !i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			io.ReaderFrom
-		}{w, hj, cn, rf}
-	case i0 && i1 && !i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			http.Flusher
-		}{w, hj, cn, fl}
-	case i0 && i1 && !i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			http.Flusher
-			io.ReaderFrom
-		}{w, hj, cn, fl, rf}
-	case i0 && i1 && i2 && !i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			http.Pusher
-		}{w, hj, cn, pu}
-	case i0 && i1 && i2 && !i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			http.Pusher
-			io.ReaderFrom
-		}{w, hj, cn, pu, rf}
-	case i0 && i1 && i2 && i3 && !i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			http.Pusher
-			http.Flusher
-		}{w, hj, cn, pu, fl}
-	case i0 && i1 && i2 && i3 && i4:
-		return struct {
-			http.ResponseWriter
-			http.Hijacker
-			http.CloseNotifier
-			http.Pusher
-			http.Flusher
-			io.ReaderFrom
-		}{w, hj, cn, pu, fl, rf}
-	default:
-		return struct {
-			http.ResponseWriter
-		}{w}
+func (w *statusCodeTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	w.hijacked = true
+	if w.hijackHook != nil {
+		conn = w.hijackHook(conn)
+	}
+	return conn, rw, nil
+}
+
+func (w *statusCodeTracker) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w *statusCodeTracker) ReadFrom(r io.Reader) (int64, error) {
+	if !w.wroteheader {
+		w.writingHeader(http.StatusOK)
+	}
+	if w.html != nil && !w.html.done {
+		// the body must go through Write until the element is injected
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+	if w.bodyHash != nil {
+		r = io.TeeReader(r, w.bodyHash)
 	}
+	n, err := w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	w.wrote(n)
+	return n, err
 }
@@ -0,0 +1,136 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// MWTagFilter returns a MWOption that keeps only the tags of the
+// server-side span for which f returns true, so that values such as
+// full URLs or user IDs never leave the process, whichever option,
+// observer or hook set them:
+//
+//	nethttp.MWTagFilter(func(key string, value interface{}) bool {
+//		return key != "http.url" && key != "user.id"
+//	})
+//
+// f is applied when the span is finished, to the last value of every
+// tag, so tags are only set on the underlying span then. It sees the
+// final keys, as renamed by MWDefaultTagNames and aliased by
+// MWDualTagNames, and the tags given when the span is started, such as
+// span.kind and the root ID hint. The fields of the span logs are
+// filtered too, as they are logged. Tags the tracer reads as they are
+// set, such as sampling.priority, are filtered and set right away.
+func MWTagFilter(f func(key string, value interface{}) bool) MWOption {
+	return func(options *mwOptions) {
+		options.tagFilter = f
+	}
+}
+
+// eagerTags are read by tracers as soon as they are set, e.g. to decide
+// the sampling of the child spans, so they cannot wait for Finish.
+var eagerTags = map[string]bool{
+	string(ext.SamplingPriority): true,
+}
+
+// filterSpan buffers tags until the span is finished, then sets those
+// the filter keeps.
+type filterSpan struct {
+	opentracing.Span
+	filter func(key string, value interface{}) bool
+
+	mu   sync.Mutex
+	keys []string
+	tags map[string]interface{}
+}
+
+func newFilterSpan(sp opentracing.Span, filter func(key string, value interface{}) bool) *filterSpan {
+	return &filterSpan{Span: sp, filter: filter, tags: make(map[string]interface{})}
+}
+
+func (s *filterSpan) SetTag(key string, value interface{}) opentracing.Span {
+	if eagerTags[key] {
+		if s.filter(key, value) {
+			s.Span.SetTag(key, value)
+		}
+		return s
+	}
+	s.mu.Lock()
+	if _, ok := s.tags[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.tags[key] = value
+	s.mu.Unlock()
+	return s
+}
+
+func (s *filterSpan) SetOperationName(operationName string) opentracing.Span {
+	s.Span.SetOperationName(operationName)
+	return s
+}
+
+func (s *filterSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *filterSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mu.Lock()
+	keys, tags := s.keys, s.tags
+	s.keys, s.tags = nil, make(map[string]interface{})
+	s.mu.Unlock()
+	for _, key := range keys {
+		if value := tags[key]; s.filter(key, value) {
+			s.Span.SetTag(key, value)
+		}
+	}
+	s.Span.FinishWithOptions(opts)
+}
+
+func (s *filterSpan) LogFields(fields ...log.Field) {
+	kept := fields[:0:0]
+	for _, f := range fields {
+		if s.filter(f.Key(), f.Value()) {
+			kept = append(kept, f)
+		}
+	}
+	if len(kept) > 0 {
+		s.Span.LogFields(kept...)
+	}
+}
+
+func (s *filterSpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := log.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		// the pairs cannot be filtered, only the error, which holds
+		// no value, is logged
+		s.Span.LogFields(log.Error(err))
+		return
+	}
+	s.LogFields(fields...)
+}
+
+// filterStartOption returns opt without the tags f rejects, if it is a
+// tag option, or opt unchanged.
+func filterStartOption(opt opentracing.StartSpanOption, f func(key string, value interface{}) bool) opentracing.StartSpanOption {
+	switch o := opt.(type) {
+	case opentracing.Tag:
+		if !f(o.Key, o.Value) {
+			return nil
+		}
+	case opentracing.Tags:
+		kept := opentracing.Tags{}
+		for k, v := range o {
+			if f(k, v) {
+				kept[k] = v
+			}
+		}
+		return kept
+	}
+	return opt
+}
@@ -0,0 +1,118 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"github.com/opentracing/opentracing-go"
+)
+
+// TagGroup is a group of tags whose names changed between tracing
+// conventions.
+type TagGroup int
+
+const (
+	// TagGroupHTTP covers http.method, http.url and http.status_code.
+	TagGroupHTTP TagGroup = iota
+	// TagGroupSize covers http.request_size and http.response_size.
+	TagGroupSize
+	// TagGroupPeer covers peer.address, peer.hostname and peer.port.
+	TagGroupPeer
+)
+
+// tagGroupNames maps the legacy tag names of each group to the new ones.
+var tagGroupNames = map[TagGroup]map[string]string{
+	TagGroupHTTP: {
+		"http.method":      "http.request.method",
+		"http.url":         "url.full",
+		"http.status_code": "http.response.status_code",
+	},
+	TagGroupSize: {
+		"http.request_size":  "http.request.body.size",
+		"http.response_size": "http.response.body.size",
+	},
+	TagGroupPeer: {
+		"peer.address":  "network.peer.address",
+		"peer.hostname": "server.address",
+		"peer.port":     "network.peer.port",
+	},
+}
+
+// MWDualTagNames returns a MWOption that sets the tags of the given
+// groups under both their legacy and their new names, e.g. both
+// http.status_code and http.response.status_code, for the duration of
+// a tracing backend migration. Tags set by observers and hooks on the
+// span given to them are duplicated as well.
+//
+// The span given to observers, hooks and handlers is then a wrapper of
+// the span created by the tracer.
+func MWDualTagNames(groups ...TagGroup) MWOption {
+	aliases := dualTagNames(groups)
+	return func(options *mwOptions) {
+		options.tagAliases = aliases
+	}
+}
+
+// ClientDualTagNames returns a ClientOption that sets the tags of the
+// given groups under both their legacy and their new names, see
+// MWDualTagNames.
+func ClientDualTagNames(groups ...TagGroup) ClientOption {
+	aliases := dualTagNames(groups)
+	return func(options *clientOptions) {
+		options.tagAliases = aliases
+	}
+}
+
+func dualTagNames(groups []TagGroup) map[string]string {
+	aliases := make(map[string]string)
+	for _, group := range groups {
+		for legacy, name := range tagGroupNames[group] {
+			aliases[legacy] = name
+		}
+	}
+	return aliases
+}
+
+// MWDefaultTagNames returns a MWOption that renames or suppresses the
+// default tags of the server-side span: component, http.method,
+// http.url, http.status_code and span.kind. names maps a default tag
+// name to the name to use instead, or to "" to not set the tag at all.
+// Other tags and the rest of the middleware behavior are unaffected.
+//
+// Example:
+//
+//	nethttp.MWDefaultTagNames(map[string]string{
+//		"component": "",
+//		"http.url":  "http.target",
+//	})
+func MWDefaultTagNames(names map[string]string) MWOption {
+	return func(options *mwOptions) {
+		options.defaultTagNames = names
+	}
+}
+
+// setDefaultTag sets the default tag key of sp, as renamed by
+// MWDefaultTagNames.
+func (o *mwOptions) setDefaultTag(sp opentracing.Span, key string, value interface{}) {
+	if name, ok := o.defaultTagNames[key]; ok {
+		if name == "" {
+			return
+		}
+		key = name
+	}
+	sp.SetTag(key, value)
+}
+
+// aliasSpan sets the tags in aliases under their alias too.
+type aliasSpan struct {
+	opentracing.Span
+	aliases map[string]string
+}
+
+func (s *aliasSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.Span.SetTag(key, value)
+	if alias, ok := s.aliases[key]; ok {
+		s.Span.SetTag(alias, value)
+	}
+	return s
+}
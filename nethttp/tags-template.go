@@ -0,0 +1,132 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// tagTemplate is a parsed MWTagsTemplate value: literal text alternating
+// with placeholders resolved against the request.
+type tagTemplate []func(r *http.Request) string
+
+type templatedTag struct {
+	key  string
+	tmpl tagTemplate
+}
+
+type tagsTemplate []templatedTag
+
+// MWTagsTemplate returns a MWOption that sets a tag on the server-side
+// span for each entry of tags, whose value is rendered from the request.
+// The following placeholders are supported:
+//
+//	{method}        the request method
+//	{host}          the request host
+//	{path}          the URL path
+//	{path[N]}       the N-th segment of the URL path, starting at 0
+//	{proto}         the protocol, e.g. HTTP/1.1
+//	{header:Name}   the value of the Name request header
+//	{query:name}    the value of the name query parameter
+//
+// Unknown placeholders are kept verbatim, and tags rendering to an empty
+// string are not set.
+//
+// Example:
+//
+//	nethttp.MWTagsTemplate(map[string]string{
+//		"tenant":   "{header:X-Tenant}",
+//		"resource": "{method} /{path[0]}",
+//	})
+func MWTagsTemplate(tags map[string]string) MWOption {
+	var t tagsTemplate
+	for key, value := range tags {
+		t = append(t, templatedTag{key: key, tmpl: parseTagTemplate(value)})
+	}
+	return func(options *mwOptions) {
+		options.tagsTemplate = t
+	}
+}
+
+func (t tagsTemplate) apply(sp opentracing.Span, r *http.Request) {
+	for _, tag := range t {
+		if v := tag.tmpl.render(r); v != "" {
+			sp.SetTag(tag.key, v)
+		}
+	}
+}
+
+func (t tagTemplate) render(r *http.Request) string {
+	if len(t) == 1 {
+		return t[0](r)
+	}
+	var v string
+	for _, part := range t {
+		v += part(r)
+	}
+	return v
+}
+
+func parseTagTemplate(s string) tagTemplate {
+	var t tagTemplate
+	for s != "" {
+		start := strings.IndexByte(s, '{')
+		end := -1
+		if start >= 0 {
+			end = strings.IndexByte(s[start:], '}')
+		}
+		if start < 0 || end < 0 {
+			t = append(t, literal(s))
+			break
+		}
+		end += start
+		if start > 0 {
+			t = append(t, literal(s[:start]))
+		}
+		t = append(t, placeholder(s[start:end+1]))
+		s = s[end+1:]
+	}
+	return t
+}
+
+func literal(s string) func(r *http.Request) string {
+	return func(*http.Request) string { return s }
+}
+
+func placeholder(p string) func(r *http.Request) string {
+	name := p[1 : len(p)-1]
+	switch {
+	case name == "method":
+		return func(r *http.Request) string { return r.Method }
+	case name == "host":
+		return func(r *http.Request) string { return r.Host }
+	case name == "path":
+		return func(r *http.Request) string { return r.URL.Path }
+	case name == "proto":
+		return func(r *http.Request) string { return r.Proto }
+	case strings.HasPrefix(name, "header:"):
+		header := name[len("header:"):]
+		return func(r *http.Request) string { return r.Header.Get(header) }
+	case strings.HasPrefix(name, "query:"):
+		param := name[len("query:"):]
+		return func(r *http.Request) string { return r.URL.Query().Get(param) }
+	case strings.HasPrefix(name, "path[") && strings.HasSuffix(name, "]"):
+		n, err := strconv.Atoi(name[len("path[") : len(name)-1])
+		if err != nil || n < 0 {
+			break
+		}
+		return func(r *http.Request) string {
+			segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if n >= len(segments) {
+				return ""
+			}
+			return segments[n]
+		}
+	}
+	return literal(p)
+}
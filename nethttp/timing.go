@@ -0,0 +1,43 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// MWTimingDetail returns a MWOption that turns on or off tagging the
+// server-side span with the detailed timing of the response, for
+// streaming and SSE handlers whose total duration says little:
+//
+//	http.time_to_first_byte_us: until the headers are written, by
+//	WriteHeader or the first write
+//	http.time_to_last_byte_us: until the last write of the body
+//	http.flush_count: the number of calls to Flush
+func MWTimingDetail(enabled bool) MWOption {
+	return func(options *mwOptions) {
+		options.timingDetail = enabled
+	}
+}
+
+// trackTiming turns on timing on sct and returns the function tagging
+// sp with it.
+func trackTiming(sp opentracing.Span, sct *statusCodeTracker, start time.Time) func() {
+	var firstByte time.Time
+	sct.timing = true
+	sct.headerHooks = append(sct.headerHooks, func(int) {
+		firstByte = time.Now()
+	})
+	return func() {
+		if !firstByte.IsZero() {
+			sp.SetTag("http.time_to_first_byte_us", int64(firstByte.Sub(start)/time.Microsecond))
+		}
+		if !sct.lastWrite.IsZero() {
+			sp.SetTag("http.time_to_last_byte_us", int64(sct.lastWrite.Sub(start)/time.Microsecond))
+		}
+		sp.SetTag("http.flush_count", sct.flushes)
+	}
+}
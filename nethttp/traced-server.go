@@ -0,0 +1,128 @@
+//go:build go1.8
+// +build go1.8
+
+package nethttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// TracedServer wraps an http.Server whose handler is traced with
+// Middleware, and keeps track of the requests in flight so that a
+// shutdown can be reported on.
+type TracedServer struct {
+	*http.Server
+	tr opentracing.Tracer
+
+	mu       sync.Mutex
+	inflight map[*inflightRequest]struct{}
+}
+
+type inflightRequest struct {
+	sp     opentracing.Span
+	method string
+	url    string
+	done   bool
+}
+
+// NewTracedServer wraps the handler of srv with Middleware, using the
+// given tracer and options, and returns the TracedServer serving it.
+//
+// Example:
+//
+//	srv := nethttp.NewTracedServer(&http.Server{Addr: ":80", Handler: mux}, tracer)
+//	go srv.ListenAndServe()
+//	...
+//	srv.Shutdown(ctx)
+func NewTracedServer(srv *http.Server, tr opentracing.Tracer, options ...MWOption) *TracedServer {
+	s := &TracedServer{
+		Server:   srv,
+		tr:       tr,
+		inflight: make(map[*inflightRequest]struct{}),
+	}
+	h := srv.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	srv.Handler = Middleware(tr, s.track(h), options...)
+	return s
+}
+
+func (s *TracedServer) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := opentracing.SpanFromContext(r.Context())
+		if sp == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		req := &inflightRequest{sp: sp, method: r.Method, url: r.URL.String()}
+		s.mu.Lock()
+		s.inflight[req] = struct{}{}
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			req.done = true
+			delete(s.inflight, req)
+			s.mu.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Shutdown gracefully shuts down the server as http.Server.Shutdown
+// does. If ctx expires before all requests are drained, the remaining
+// connections are closed with http.Server.Close.
+//
+// The shutdown is recorded in a "HTTP Server Shutdown" span tagged with
+// the number of drained and force-terminated requests, with one log per
+// request carrying its trace ID. The spans of force-terminated requests
+// are tagged shutdown.forced=true.
+func (s *TracedServer) Shutdown(ctx context.Context) error {
+	sp := s.tr.StartSpan("HTTP Server Shutdown")
+	defer sp.Finish()
+
+	s.mu.Lock()
+	pending := make([]*inflightRequest, 0, len(s.inflight))
+	for req := range s.inflight {
+		pending = append(pending, req)
+	}
+	s.mu.Unlock()
+
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		sp.LogFields(log.String("event", "error"), log.Error(err))
+		s.Server.Close()
+	}
+
+	var drained, forced int
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, req := range pending {
+		event := "RequestDrained"
+		if req.done {
+			drained++
+		} else {
+			forced++
+			event = "RequestForceTerminated"
+			req.sp.SetTag("shutdown.forced", true)
+		}
+		sp.LogFields(
+			log.String("event", event),
+			log.String("trace_id", traceIDOf(req.sp.Context())),
+			log.String("http.method", req.method),
+			log.String("http.url", req.url),
+		)
+	}
+	sp.SetTag("shutdown.drained", drained)
+	sp.SetTag("shutdown.forced", forced)
+	if forced > 0 {
+		ext.Error.Set(sp, true)
+	}
+	return err
+}
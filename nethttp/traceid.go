@@ -0,0 +1,71 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// TraceIDFromContext returns the trace ID of the span in ctx, e.g. to
+// stamp log lines and error reports with it, and whether there is one.
+// It works with tracers whose span contexts expose a TraceID method,
+// such as Jaeger, or field, such as Zipkin and mocktracer, without
+// importing their packages.
+//
+// Example:
+//
+//	if id, ok := nethttp.TraceIDFromContext(r.Context()); ok {
+//		logger = logger.With("trace_id", id)
+//	}
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	return idFromContext(ctx, "TraceID")
+}
+
+// SpanIDFromContext returns the ID of the span in ctx, and whether there
+// is one, see TraceIDFromContext.
+func SpanIDFromContext(ctx context.Context) (string, bool) {
+	return idFromContext(ctx, "SpanID")
+}
+
+func idFromContext(ctx context.Context, name string) (string, bool) {
+	sp := opentracing.SpanFromContext(ctx)
+	if sp == nil {
+		return "", false
+	}
+	id := idOf(sp.Context(), name)
+	return id, id != ""
+}
+
+// traceIDOf returns the trace identifier of sc in a tracer-agnostic way.
+// It understands span contexts exposing a TraceID method (Jaeger) or a
+// TraceID field (Zipkin, mocktracer), and returns "" otherwise.
+func traceIDOf(sc opentracing.SpanContext) string {
+	return idOf(sc, "TraceID")
+}
+
+func idOf(sc opentracing.SpanContext, name string) string {
+	if sc == nil {
+		return ""
+	}
+	v := reflect.ValueOf(sc)
+	if m := v.MethodByName(name); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+		return fmt.Sprint(m.Call(nil)[0].Interface())
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if f := v.FieldByName(name); f.IsValid() && f.CanInterface() {
+			return fmt.Sprint(f.Interface())
+		}
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+type uploadOptions struct {
+	progressBytes int64
+}
+
+// UploadOption controls the behavior of UploadHandler.
+type UploadOption func(*uploadOptions)
+
+// UploadProgressBytes returns an UploadOption that sets how many bytes
+// are read between two progress logs on the server-side span. A zero
+// or negative value disables the progress logs.
+func UploadProgressBytes(n int64) UploadOption {
+	return func(options *uploadOptions) {
+		options.progressBytes = n
+	}
+}
+
+// UploadHandler wraps an http.Handler receiving file uploads. The
+// request body, including multipart bodies read through
+// r.MultipartReader or r.ParseMultipartForm, is instrumented so that the
+// server-side span started by Middleware gets progress logs while the
+// body is read, and is tagged with the total size, the time spent
+// waiting on the client, the resulting throughput and the sniffed MIME
+// type of the content. The parts of multipart bodies are not sniffed,
+// the span only gets the multipart Content-Type of the request.
+//
+// The time spent reading the body versus the total handler time tells
+// slow client bandwidth apart from slow server processing.
+//
+// Example:
+//
+//	mux.Handle("/upload", nethttp.UploadHandler(uploadHandler))
+//	http.ListenAndServe(":80", nethttp.Middleware(tracer, mux))
+func UploadHandler(h http.Handler, options ...UploadOption) http.Handler {
+	opts := uploadOptions{
+		progressBytes: 1 << 20,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := opentracing.SpanFromContext(r.Context())
+		if sp == nil || r.Body == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		ur := &uploadReader{
+			ReadCloser: r.Body,
+			sp:         sp,
+			opts:       &opts,
+			sniff:      !strings.HasPrefix(mediaType, "multipart/"),
+			next:       opts.progressBytes,
+		}
+		r.Body = ur
+		start := time.Now()
+		defer func() {
+			ur.finish(time.Since(start), contentType)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+type uploadReader struct {
+	io.ReadCloser
+	sp       opentracing.Span
+	opts     *uploadOptions
+	n        int64
+	next     int64
+	readTime time.Duration
+	sniff    bool
+	head     []byte
+	err      error
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := u.ReadCloser.Read(p)
+	u.readTime += time.Since(start)
+	if u.sniff && len(u.head) < 512 {
+		rest := 512 - len(u.head)
+		if rest > n {
+			rest = n
+		}
+		u.head = append(u.head, p[:rest]...)
+	}
+	u.n += int64(n)
+	if u.opts.progressBytes > 0 && u.n >= u.next {
+		u.sp.LogFields(
+			log.String("event", "UploadProgress"),
+			log.Int64("upload.bytes", u.n),
+		)
+		u.next = u.n + u.opts.progressBytes
+	}
+	if err != nil && err != io.EOF && u.err == nil {
+		u.err = err
+		u.sp.LogFields(
+			log.String("event", "error"),
+			log.String("message", "upload read failed"),
+			log.Int64("upload.bytes", u.n),
+			log.Error(err),
+		)
+	}
+	return n, err
+}
+
+func (u *uploadReader) finish(total time.Duration, contentType string) {
+	u.sp.SetTag("upload.bytes", u.n)
+	u.sp.SetTag("upload.read_ms", u.readTime.Seconds()*1000)
+	u.sp.SetTag("upload.handler_ms", total.Seconds()*1000)
+	if u.readTime > 0 {
+		u.sp.SetTag("upload.throughput_bps", float64(u.n)/u.readTime.Seconds())
+	}
+	if contentType != "" {
+		u.sp.SetTag("upload.content_type", contentType)
+	}
+	if len(u.head) > 0 {
+		u.sp.SetTag("upload.sniffed_type", http.DetectContentType(u.head))
+	}
+}
@@ -0,0 +1,150 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// NewMiddleware returns NewTracingMiddleware(tr, options...) once
+// options are checked for misconfigurations that would otherwise go
+// unnoticed or panic while serving requests:
+//
+//   - nil options, and nil functions given to options that require one,
+//     such as MWSpanFilter
+//   - sampling fractions not between 0 and 1, and negative or zero
+//     durations and rates
+//   - options overriding each other, such as MWRedactQueryParams and
+//     MWAllowQueryParams, or MWExtractFunc and MWPropagators
+//
+// The options registered with MWRouteOptions are checked too, as they
+// are applied on top of options.
+//
+// Example:
+//
+//	mw, err := nethttp.NewMiddleware(tracer, options...)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":80", mw(mux))
+func NewMiddleware(tr opentracing.Tracer, options ...MWOption) (func(http.Handler) http.Handler, error) {
+	if err := validateMWOptions(tr, options); err != nil {
+		return nil, err
+	}
+	return NewTracingMiddleware(tr, options...), nil
+}
+
+// conflictingOptions are the pairs of options that override each other.
+var conflictingOptions = [][2]string{
+	{"MWRedactQueryParams", "MWAllowQueryParams"},
+	{"MWExtractFunc", "MWPropagators"},
+}
+
+func validateMWOptions(tr opentracing.Tracer, options []MWOption) error {
+	for i, opt := range getDefaultMWOptions() {
+		if opt == nil {
+			return fmt.Errorf("nethttp: default option %d is nil", i)
+		}
+	}
+	given := make(map[string]bool, len(options))
+	for i, opt := range options {
+		if opt == nil {
+			return fmt.Errorf("nethttp: option %d is nil", i)
+		}
+		given[funcName(opt)] = true
+	}
+	for _, pair := range conflictingOptions {
+		if given[pair[0]] && given[pair[1]] {
+			return fmt.Errorf("nethttp: %s and %s override each other", pair[0], pair[1])
+		}
+	}
+	opts := newMWOptions(options)
+	if err := validateOptions(opts); err != nil {
+		return err
+	}
+	if tr == nil && opts.tracerFunc == nil {
+		return errors.New("nethttp: nil tracer")
+	}
+	if opts.routes == nil {
+		return nil
+	}
+	// route options override the options given to Middleware on purpose,
+	// so only the values they end up with are checked
+	for _, r := range opts.routes.routes {
+		for i, opt := range r.options {
+			if opt == nil {
+				return fmt.Errorf("nethttp: option %d of route %s is nil", i, r.pattern)
+			}
+		}
+		opts := newMWOptions(append(append([]MWOption(nil), options...), r.options...))
+		if err := validateOptions(opts); err != nil {
+			return fmt.Errorf("%v, for route %s", err, r.pattern)
+		}
+	}
+	return nil
+}
+
+// validateOptions checks the values set by the options.
+func validateOptions(opts *mwOptions) error {
+	required := []struct {
+		option string
+		isNil  bool
+	}{
+		{"OperationNameFunc", opts.opNameFunc == nil},
+		{"MWSpanFilter", opts.spanFilter == nil},
+		{"MWSpanObserver", opts.spanObserver == nil},
+		{"MWSpanOnStart", opts.spanOnStart == nil},
+		{"MWSpanOnFinish", opts.spanOnFinish == nil},
+		{"MWErrorFunc", opts.errorFunc == nil},
+		{"MWHeaderRedactor", opts.headerRedactor == nil},
+	}
+	for _, r := range required {
+		if r.isNil {
+			return fmt.Errorf("nethttp: nil function given to %s", r.option)
+		}
+	}
+
+	type fraction struct {
+		option string
+		value  float64
+	}
+	fractions := []fraction{{"MWAllocationSampling", opts.allocSampling}}
+	if opts.leaks != nil {
+		fractions = append(fractions, fraction{"MWGoroutineLeakDetection", opts.leaks.fraction})
+	}
+	if s, ok := opts.sampler.(probabilisticSampler); ok {
+		fractions = append(fractions, fraction{"ProbabilisticSampler", float64(s)})
+	}
+	for _, f := range fractions {
+		if f.value < 0 || f.value > 1 {
+			return fmt.Errorf("nethttp: %s fraction %v is not between 0 and 1", f.option, f.value)
+		}
+	}
+	if s, ok := opts.sampler.(*tokenBucket); ok && s.rate <= 0 {
+		return fmt.Errorf("nethttp: RateLimitingSampler rate %v is not positive", s.rate)
+	}
+
+	if opts.hijackIdleTimeout < 0 || opts.hijackMaxLifetime < 0 {
+		return errors.New("nethttp: negative MWHijackedConnLimits duration")
+	}
+	if (opts.hijackIdleTimeout > 0 || opts.hijackMaxLifetime > 0) && !opts.keepHijackedSpans {
+		return errors.New("nethttp: MWHijackedConnLimits turned off by MWKeepHijackedSpans")
+	}
+	if opts.slowRequest != nil && opts.slowRequest.threshold <= 0 {
+		return fmt.Errorf("nethttp: MWSlowRequestThreshold duration %v is not positive", opts.slowRequest.threshold)
+	}
+	if opts.spanLifetime != nil {
+		if opts.spanLifetime.max <= 0 {
+			return fmt.Errorf("nethttp: MWMaxSpanLifetime duration %v is not positive", opts.spanLifetime.max)
+		}
+		if opts.slowRequest != nil && opts.slowRequest.threshold >= opts.spanLifetime.max {
+			return fmt.Errorf("nethttp: MWSlowRequestThreshold duration %v is not below MWMaxSpanLifetime duration %v", opts.slowRequest.threshold, opts.spanLifetime.max)
+		}
+	}
+	return nil
+}
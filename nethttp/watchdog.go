@@ -0,0 +1,61 @@
+//go:build go1.7
+// +build go1.7
+
+package nethttp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+type slowRequestOptions struct {
+	threshold time.Duration
+	f         func(sp opentracing.Span, r *http.Request)
+}
+
+// MWSlowRequestThreshold returns a MWOption that watches requests still
+// in flight past d, so that stuck requests are visible before they
+// finish, if ever. Once the threshold is crossed, the span gets a
+// SlowRequest log and is tagged http.slow=true, then f, if not nil, is
+// called from another goroutine, e.g. to report the trace ID. The span
+// is not finished before f returns, so f must not wait for the request
+// to complete.
+func MWSlowRequestThreshold(d time.Duration, f func(sp opentracing.Span, r *http.Request)) MWOption {
+	return func(options *mwOptions) {
+		options.slowRequest = &slowRequestOptions{threshold: d, f: f}
+	}
+}
+
+// watch starts watching the request and returns the function to call
+// when it is done, before the span is finished: it waits for the
+// callback if it is running, so that the span is never tagged once
+// finished.
+func (o *slowRequestOptions) watch(sp opentracing.Span, r *http.Request) func() {
+	var mu sync.Mutex
+	done := false
+	t := time.AfterFunc(o.threshold, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		sp.SetTag("http.slow", true)
+		sp.LogFields(
+			log.String("event", "SlowRequest"),
+			log.Int64("threshold_ms", int64(o.threshold/time.Millisecond)),
+		)
+		if o.f != nil {
+			o.f(sp, r)
+		}
+	})
+	return func() {
+		t.Stop()
+		mu.Lock()
+		done = true
+		mu.Unlock()
+	}
+}